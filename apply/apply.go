@@ -0,0 +1,240 @@
+package apply
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// Apply modes, selected with util.ApplyMode
+const (
+	// ModeFile writes the WireGuard config file, which has to be loaded with wg-quick afterwards
+	ModeFile = "file"
+	// ModeWgctrl configures the running interface through wgctrl, adding, updating and removing single peers so
+	// the sessions of unchanged peers survive
+	ModeWgctrl = "wgctrl"
+	// ModeBoth writes the config file and configures the running interface
+	ModeBoth = "both"
+)
+
+// Result describes what Apply changed
+type Result struct {
+	Mode string `json:"mode"`
+	// Revision is the config revision which was applied
+	Revision   uint64            `json:"revision"`
+	Interfaces []InterfaceResult `json:"interfaces"`
+}
+
+// InterfaceResult describes what Apply changed for one interface
+type InterfaceResult struct {
+	Name string `json:"name"`
+	// ConfigFile is set if the config file was written
+	ConfigFile string `json:"config_file,omitempty"`
+	// Configured is set if the running interface was configured
+	Configured bool `json:"configured"`
+	// InterfaceUpdated is set if the private key or listen port of the interface was changed
+	InterfaceUpdated bool `json:"interface_updated"`
+	PeersAdded       int  `json:"peers_added"`
+	PeersUpdated     int  `json:"peers_updated"`
+	PeersRemoved     int  `json:"peers_removed"`
+	// PreApplyHook and PostApplyHook are the runs of the hook commands for the interface, if they are configured
+	PreApplyHook  *HookResult `json:"pre_apply_hook,omitempty"`
+	PostApplyHook *HookResult `json:"post_apply_hook,omitempty"`
+}
+
+func (r InterfaceResult) String() string {
+	var parts []string
+	if r.ConfigFile != "" {
+		parts = append(parts, "wrote "+r.ConfigFile)
+	}
+	if r.Configured {
+		parts = append(parts, fmt.Sprintf("configured %s: %d peers added, %d updated, %d removed",
+			r.Name, r.PeersAdded, r.PeersUpdated, r.PeersRemoved))
+		if r.InterfaceUpdated {
+			parts = append(parts, "interface settings updated")
+		}
+	}
+	if r.PostApplyHook.Failed() {
+		parts = append(parts, "post-apply hook "+r.PostApplyHook.Error)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r Result) String() string {
+	parts := make([]string, 0, len(r.Interfaces))
+	for _, iface := range r.Interfaces {
+		parts = append(parts, iface.String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Target returns the names of the applied interfaces, as the target of the audit log entry
+func (r Result) Target() string {
+	names := make([]string, 0, len(r.Interfaces))
+	for _, iface := range r.Interfaces {
+		names = append(names, iface.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// FailedPostApplyHooks returns the post-apply hooks which failed
+func (r Result) FailedPostApplyHooks() []*HookResult {
+	var failed []*HookResult
+	for _, iface := range r.Interfaces {
+		if iface.PostApplyHook.Failed() {
+			failed = append(failed, iface.PostApplyHook)
+		}
+	}
+	return failed
+}
+
+// ValidateMode to check that mode is one of the apply modes
+func ValidateMode(mode string) error {
+	switch mode {
+	case ModeFile, ModeWgctrl, ModeBoth:
+		return nil
+	default:
+		return fmt.Errorf("invalid apply mode %q, must be %s, %s or %s", mode, ModeFile, ModeWgctrl, ModeBoth)
+	}
+}
+
+// state is the configuration read from the store
+type state struct {
+	servers  []model.Server
+	clients  []model.ClientData
+	users    []model.User
+	settings model.GlobalSetting
+}
+
+func load(db store.IStore) (state, error) {
+	var s state
+	var err error
+	if s.servers, err = db.GetServers(); err != nil {
+		return s, fmt.Errorf("cannot get server config: %v", err)
+	}
+	if s.clients, err = db.GetClients(false); err != nil {
+		return s, fmt.Errorf("cannot get client config: %v", err)
+	}
+	if s.users, err = db.GetUsers(); err != nil {
+		return s, fmt.Errorf("cannot get users config: %v", err)
+	}
+	if s.settings, err = db.GetGlobalSettings(); err != nil {
+		return s, fmt.Errorf("cannot get global settings: %v", err)
+	}
+	return s, nil
+}
+
+// server returns the server of the named interface, or an error if there is none
+func (s state) server(name string) (model.Server, error) {
+	for _, server := range s.servers {
+		if server.Interface.Name == name {
+			return server, nil
+		}
+	}
+	return model.Server{}, fmt.Errorf("interface %s not found", name)
+}
+
+// clientsOf returns the clients of the named interface
+func (s state) clientsOf(name string) []model.ClientData {
+	var clients []model.ClientData
+	for _, clientData := range s.clients {
+		if clientData.Client.Interface == name {
+			clients = append(clients, clientData)
+		}
+	}
+	return clients
+}
+
+func writesFile(mode string) bool {
+	return mode == ModeFile || mode == ModeBoth
+}
+
+func configuresDevice(mode string) bool {
+	return mode == ModeWgctrl || mode == ModeBoth
+}
+
+// applyMu serializes Apply, which runs from requests and from the AutoApplier
+var applyMu sync.Mutex
+
+// Apply to read the configuration from the store and apply it to every interface according to util.ApplyMode.
+// util.PreApplyHook runs for each interface before anything is applied and aborts the apply if it fails,
+// util.PostApplyHook runs for each interface afterwards.
+func Apply(db store.IStore, tmplDir fs.FS) (Result, error) {
+	applyMu.Lock()
+	defer applyMu.Unlock()
+
+	result := Result{Mode: util.ApplyMode, Interfaces: []InterfaceResult{}}
+	if err := ValidateMode(util.ApplyMode); err != nil {
+		return result, err
+	}
+
+	// the revision is read first, so changes made while applying are not taken as applied
+	revisions, err := db.GetConfigRevisions()
+	if err != nil {
+		return result, fmt.Errorf("cannot get config revisions: %v", err)
+	}
+	result.Revision = revisions.Current
+
+	s, err := load(db)
+	if err != nil {
+		return result, err
+	}
+
+	envs := make([][]string, len(s.servers))
+	for i, server := range s.servers {
+		result.Interfaces = append(result.Interfaces, InterfaceResult{Name: server.Interface.Name})
+		if util.PreApplyHook == "" && util.PostApplyHook == "" {
+			continue
+		}
+		var configFile string
+		if writesFile(util.ApplyMode) {
+			configFile = server.Interface.ConfigFilePath
+		}
+		envs[i] = hookEnv(util.ApplyMode, result.Revision, configFile, server.Interface.Name)
+	}
+	if util.PreApplyHook != "" {
+		for i := range result.Interfaces {
+			iface := &result.Interfaces[i]
+			iface.PreApplyHook = runHook(util.PreApplyHook, util.ApplyHookTimeout, envs[i])
+			if iface.PreApplyHook.Failed() {
+				return result, fmt.Errorf("pre-apply hook of %s %s, nothing was applied", iface.Name, iface.PreApplyHook.Error)
+			}
+		}
+	}
+
+	for i, server := range s.servers {
+		iface := &result.Interfaces[i]
+		clients := s.clientsOf(iface.Name)
+
+		if writesFile(util.ApplyMode) {
+			if err := util.WriteWireGuardServerConfig(tmplDir, server, clients, s.users, s.settings); err != nil {
+				return result, fmt.Errorf("cannot write config file of %s: %v", iface.Name, err)
+			}
+			iface.ConfigFile = server.Interface.ConfigFilePath
+		}
+
+		if configuresDevice(util.ApplyMode) {
+			if err := configureDevice(iface, server, clients, s.settings); err != nil {
+				return result, fmt.Errorf("cannot configure interface %s: %v", iface.Name, err)
+			}
+			iface.Configured = true
+		}
+	}
+
+	if err := db.SaveAppliedConfigRevision(result.Revision); err != nil {
+		return result, fmt.Errorf("cannot save applied config revision: %v", err)
+	}
+
+	// the config is applied even if a post-apply hook fails, its failure is reported in the result
+	if util.PostApplyHook != "" {
+		for i := range result.Interfaces {
+			result.Interfaces[i].PostApplyHook = runHook(util.PostApplyHook, util.ApplyHookTimeout, envs[i])
+		}
+	}
+	return result, nil
+}
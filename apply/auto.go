@@ -0,0 +1,159 @@
+package apply
+
+import (
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/labstack/gommon/log"
+
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// autoApplyAuditActor is recorded in the audit log for configs applied by the AutoApplier
+const autoApplyAuditActor = "auto-apply"
+
+// AutoApplyStatus describes the state of the AutoApplier
+type AutoApplyStatus struct {
+	Enabled bool `json:"enabled"`
+	// Delay is the time in seconds the AutoApplier waits for further changes before applying
+	Delay int `json:"delay"`
+	// Pending is set from a change until it is applied, it stays set if applying failed
+	Pending bool       `json:"pending"`
+	LastRun *time.Time `json:"last_run,omitempty"`
+	// LastResult is the result of the last run, with the output of the hooks also if it failed
+	LastResult *Result `json:"last_result,omitempty"`
+	// LastError is the reason the last run failed, empty if it succeeded
+	LastError string `json:"last_error,omitempty"`
+}
+
+// AutoApplier applies the configuration in the background after it was changed. Changes are collected until none
+// came in for the delay, so a series of changes is applied once.
+type AutoApplier struct {
+	db      store.IStore
+	tmplDir fs.FS
+	delay   time.Duration
+	trigger chan struct{}
+
+	mu     sync.Mutex
+	status AutoApplyStatus
+}
+
+// NewAutoApplier returns an AutoApplier which applies the configuration delay after the last change. It has to be
+// started with Start.
+func NewAutoApplier(db store.IStore, tmplDir fs.FS, delay time.Duration) *AutoApplier {
+	return &AutoApplier{
+		db:      db,
+		tmplDir: tmplDir,
+		delay:   delay,
+		trigger: make(chan struct{}, 1),
+		status:  AutoApplyStatus{Enabled: true, Delay: int(delay / time.Second)},
+	}
+}
+
+// Start to run the worker, changes made before the start are applied after the delay as well
+func (a *AutoApplier) Start() {
+	go a.run()
+	a.Trigger()
+}
+
+// Trigger to apply the configuration once no further change came in for the delay
+func (a *AutoApplier) Trigger() {
+	a.mu.Lock()
+	a.status.Pending = true
+	a.mu.Unlock()
+
+	select {
+	case a.trigger <- struct{}{}:
+	default:
+		// a trigger is queued already
+	}
+}
+
+// Status returns the current state of the AutoApplier
+func (a *AutoApplier) Status() AutoApplyStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status
+}
+
+func (a *AutoApplier) run() {
+	timer := time.NewTimer(a.delay)
+	timer.Stop()
+	for {
+		select {
+		case <-a.trigger:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(a.delay)
+		case <-timer.C:
+			a.applyPending()
+		}
+	}
+}
+
+// applyPending to apply the configuration if it has changes which are not applied yet
+func (a *AutoApplier) applyPending() {
+	revisions, err := a.db.GetConfigRevisions()
+	if err == nil && !revisions.Pending() {
+		a.mu.Lock()
+		a.status.Pending = false
+		a.mu.Unlock()
+		return
+	}
+
+	var result Result
+	if err == nil {
+		result, err = Apply(a.db, a.tmplDir)
+	}
+	now := time.Now().UTC()
+
+	a.mu.Lock()
+	a.status.LastRun = &now
+	a.status.LastResult = &result
+	if err != nil {
+		a.status.LastError = err.Error()
+	} else {
+		a.status.Pending = false
+		a.status.LastError = ""
+	}
+	a.mu.Unlock()
+
+	if err != nil {
+		log.Error("Cannot apply server config automatically: ", err)
+		return
+	}
+	log.Infof("Applied server config automatically: %s", result)
+	for _, hook := range result.FailedPostApplyHooks() {
+		log.Warnf("Post-apply hook %s: %s", hook.Error, hook.Output)
+	}
+
+	if err := a.db.SaveAuditLog(util.NewAuditLog(autoApplyAuditActor, "config.apply", result.Target(), nil, result)); err != nil {
+		log.Error("Cannot save audit log: ", err)
+	}
+}
+
+// Background returns a function which applies the configuration right away, for background workers which change
+// clients while the configuration is not applied automatically. The worker is recorded as actor in the audit log.
+func Background(db store.IStore, tmplDir fs.FS, actor string) func() {
+	return func() {
+		result, err := Apply(db, tmplDir)
+		if err != nil {
+			log.Errorf("Cannot apply server config for %s: %v", actor, err)
+			return
+		}
+		log.Infof("Applied server config for %s: %s", actor, result)
+		for _, hook := range result.FailedPostApplyHooks() {
+			log.Warnf("Post-apply hook %s: %s", hook.Error, hook.Output)
+		}
+
+		if err := db.SaveAuditLog(util.NewAuditLog(actor, "config.apply", result.Target(), nil, result)); err != nil {
+			log.Error("Cannot save audit log: ", err)
+		}
+	}
+}
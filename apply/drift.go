@@ -0,0 +1,406 @@
+package apply
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sort"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+	"github.com/ngoduykhanh/wireguard-ui/wgconf"
+)
+
+// Drift issues of a peer
+const (
+	// IssueMissingOnDevice is an enabled client which is not a peer of the running interface
+	IssueMissingOnDevice = "missing_on_device"
+	// IssueChangedOnDevice is an enabled client whose peer on the running interface has other settings
+	IssueChangedOnDevice = "changed_on_device"
+	// IssueDisabledOnDevice is a disabled client which is still a peer of the running interface
+	IssueDisabledOnDevice = "disabled_on_device"
+	// IssueUnknownOnDevice is a peer of the running interface without a client
+	IssueUnknownOnDevice = "unknown_on_device"
+	// IssueMissingInConfigFile is an enabled client which is not in the config file
+	IssueMissingInConfigFile = "missing_in_config_file"
+	// IssueChangedInConfigFile is an enabled client whose peer in the config file has other settings
+	IssueChangedInConfigFile = "changed_in_config_file"
+	// IssueDisabledInConfigFile is a disabled client which is still in the config file
+	IssueDisabledInConfigFile = "disabled_in_config_file"
+	// IssueUnknownInConfigFile is a peer in the config file without a client
+	IssueUnknownInConfigFile = "unknown_in_config_file"
+)
+
+var (
+	// ErrPeerNotFound is returned for a peer which is neither on the running interface nor in the config file
+	ErrPeerNotFound = errors.New("peer not found")
+	// ErrPeerHasClient is returned for actions on unknown peers if the peer belongs to a client
+	ErrPeerHasClient = errors.New("the peer belongs to client")
+)
+
+// DriftPeer is a peer which differs between the store, the config file and the running interface
+type DriftPeer struct {
+	PublicKey string `json:"public_key"`
+	// ClientID, Client and Enabled describe the client with the peer's public key, if there is one
+	ClientID     string `json:"client_id,omitempty"`
+	Client       string `json:"client,omitempty"`
+	Enabled      bool   `json:"enabled"`
+	InStore      bool   `json:"in_store"`
+	InConfigFile bool   `json:"in_config_file"`
+	OnDevice     bool   `json:"on_device"`
+	// AllowedIPs are the allowed ips of the peer on the running interface, or in the config file if it is not on
+	// the running interface
+	AllowedIPs []string `json:"allowed_ips"`
+	// Issues are the Issue constants which apply to the peer
+	Issues []string `json:"issues"`
+	// DeviceChanges and ConfigFileChanges are the fields which differ from the store
+	DeviceChanges     []string `json:"device_changes,omitempty"`
+	ConfigFileChanges []string `json:"config_file_changes,omitempty"`
+}
+
+// Drift compares the store with the config files and the running interfaces
+type Drift struct {
+	Interfaces []InterfaceDrift `json:"interfaces"`
+}
+
+// InSync reports whether no drift was found on any interface
+func (d Drift) InSync() bool {
+	for _, iface := range d.Interfaces {
+		if !iface.InSync() {
+			return false
+		}
+	}
+	return true
+}
+
+// InterfaceDrift compares the store with the config file and the running interface of one interface
+type InterfaceDrift struct {
+	Name       string `json:"name"`
+	ConfigFile string `json:"config_file"`
+	// ConfigFileChanged is set if the config file differs from the config rendered from the store
+	ConfigFileChanged bool `json:"config_file_changed"`
+	// ConfigFileError is the reason the config file could not be compared, e.g. because it does not exist
+	ConfigFileError  string   `json:"config_file_error,omitempty"`
+	InterfaceChanges []string `json:"interface_changes,omitempty"`
+	// InterfaceError is the reason the interface could not be compared, e.g. because it is not up
+	InterfaceError string      `json:"interface_error,omitempty"`
+	Peers          []DriftPeer `json:"peers"`
+}
+
+// InSync reports whether no drift was found. Parts which could not be compared do not count as drift.
+func (d InterfaceDrift) InSync() bool {
+	return !d.ConfigFileChanged && len(d.InterfaceChanges) == 0 && len(d.Peers) == 0
+}
+
+// DetectDrift to compare the clients in the store with the peers of the config file on disk and of the running
+// interface, for every interface. The config files are also compared as a whole with the configs rendered from the
+// store.
+func DetectDrift(db store.IStore, tmplDir fs.FS) (Drift, error) {
+	s, err := load(db)
+	if err != nil {
+		return Drift{}, err
+	}
+	drift := Drift{Interfaces: []InterfaceDrift{}}
+	for _, server := range s.servers {
+		iface, err := detectInterfaceDrift(tmplDir, s, server)
+		if err != nil {
+			return drift, err
+		}
+		drift.Interfaces = append(drift.Interfaces, iface)
+	}
+	return drift, nil
+}
+
+func detectInterfaceDrift(tmplDir fs.FS, s state, server model.Server) (InterfaceDrift, error) {
+	drift := InterfaceDrift{Name: server.Interface.Name, ConfigFile: server.Interface.ConfigFilePath, Peers: []DriftPeer{}}
+	clients := s.clientsOf(drift.Name)
+	peers := newDriftPeers(clients)
+
+	var rendered bytes.Buffer
+	if err := util.RenderWireGuardServerConfig(&rendered, tmplDir, server, clients, s.users, s.settings); err != nil {
+		return drift, fmt.Errorf("cannot render config file of %s: %v", drift.Name, err)
+	}
+	if err := compareConfigFile(&drift, peers, rendered.Bytes()); err != nil {
+		drift.ConfigFileError = err.Error()
+	}
+	if err := compareDevice(&drift, peers, server, clients, s.settings); err != nil {
+		drift.InterfaceError = err.Error()
+	}
+
+	for _, peer := range peers.byKey {
+		if len(peer.Issues) > 0 {
+			drift.Peers = append(drift.Peers, *peer)
+		}
+	}
+	sort.Slice(drift.Peers, func(i, j int) bool {
+		if drift.Peers[i].Client != drift.Peers[j].Client {
+			return drift.Peers[i].Client < drift.Peers[j].Client
+		}
+		return drift.Peers[i].PublicKey < drift.Peers[j].PublicKey
+	})
+	return drift, nil
+}
+
+// driftPeers are the peers seen so far, by public key, starting with the clients in the store
+type driftPeers struct {
+	byKey map[string]*DriftPeer
+}
+
+func newDriftPeers(clients []model.ClientData) driftPeers {
+	peers := driftPeers{byKey: make(map[string]*DriftPeer, len(clients))}
+	for _, clientData := range clients {
+		client := clientData.Client
+		peers.byKey[client.PublicKey] = &DriftPeer{
+			PublicKey:  client.PublicKey,
+			ClientID:   client.ID,
+			Client:     client.Name,
+			Enabled:    client.Enabled,
+			InStore:    true,
+			AllowedIPs: append(append([]string{}, client.AllocatedIPs...), client.ExtraAllowedIPs...),
+		}
+	}
+	return peers
+}
+
+func (p driftPeers) get(publicKey string) *DriftPeer {
+	peer, found := p.byKey[publicKey]
+	if !found {
+		peer = &DriftPeer{PublicKey: publicKey}
+		p.byKey[publicKey] = peer
+	}
+	return peer
+}
+
+// compareConfigFile to compare the peers of the config file on disk with those of the rendered config
+func compareConfigFile(drift *InterfaceDrift, peers driftPeers, rendered []byte) error {
+	content, err := os.ReadFile(drift.ConfigFile)
+	if err != nil {
+		return err
+	}
+	drift.ConfigFileChanged = !bytes.Equal(content, rendered)
+
+	current, err := wgconf.Parse(content)
+	if err != nil {
+		return fmt.Errorf("cannot parse config file: %v", err)
+	}
+	desired, err := wgconf.Parse(rendered)
+	if err != nil {
+		return fmt.Errorf("cannot parse rendered config: %v", err)
+	}
+
+	desiredPeers := make(map[string]*wgconf.Peer, len(desired.Peers))
+	for _, peer := range desired.Peers {
+		desiredPeers[peer.PublicKey] = peer
+	}
+	for _, filePeer := range current.Peers {
+		peer := peers.get(filePeer.PublicKey)
+		peer.InConfigFile = true
+		desiredPeer, found := desiredPeers[filePeer.PublicKey]
+		delete(desiredPeers, filePeer.PublicKey)
+		switch {
+		case !peer.InStore:
+			peer.AllowedIPs = filePeer.AllowedIPs
+			peer.Issues = append(peer.Issues, IssueUnknownInConfigFile)
+		case !peer.Enabled:
+			peer.Issues = append(peer.Issues, IssueDisabledInConfigFile)
+		case found:
+			if peer.ConfigFileChanges = filePeerChanges(filePeer, desiredPeer); len(peer.ConfigFileChanges) > 0 {
+				peer.Issues = append(peer.Issues, IssueChangedInConfigFile)
+			}
+		}
+	}
+	for publicKey := range desiredPeers {
+		peer := peers.get(publicKey)
+		peer.Issues = append(peer.Issues, IssueMissingInConfigFile)
+	}
+	return nil
+}
+
+// filePeerChanges to list the fields in which a peer of the config file differs from the rendered one
+func filePeerChanges(current *wgconf.Peer, desired *wgconf.Peer) []string {
+	var fields []string
+	if current.PresharedKey != desired.PresharedKey {
+		fields = append(fields, "preshared_key")
+	}
+	if current.PersistentKeepalive != desired.PersistentKeepalive {
+		fields = append(fields, "persistent_keepalive")
+	}
+	if current.Endpoint != desired.Endpoint {
+		fields = append(fields, "endpoint")
+	}
+	if !sameStrings(current.AllowedIPs, desired.AllowedIPs) {
+		fields = append(fields, "allowed_ips")
+	}
+	return fields
+}
+
+// compareDevice to compare the peers of the running interface with the enabled clients
+func compareDevice(drift *InterfaceDrift, peers driftPeers, server model.Server, clients []model.ClientData, settings model.GlobalSetting) error {
+	desired, err := DesiredConfig(server, clients, settings)
+	if err != nil {
+		return err
+	}
+
+	wgClient, device, err := openDevice(drift.Name)
+	if err != nil {
+		return err
+	}
+	defer wgClient.Close()
+
+	if *desired.PrivateKey != device.PrivateKey {
+		drift.InterfaceChanges = append(drift.InterfaceChanges, "private_key")
+	}
+	if *desired.ListenPort != device.ListenPort {
+		drift.InterfaceChanges = append(drift.InterfaceChanges, "listen_port")
+	}
+
+	desiredPeers := make(map[string]wgtypes.PeerConfig, len(desired.Peers))
+	for _, peer := range desired.Peers {
+		desiredPeers[peer.PublicKey.String()] = peer
+	}
+	for _, devicePeer := range device.Peers {
+		publicKey := devicePeer.PublicKey.String()
+		peer := peers.get(publicKey)
+		peer.OnDevice = true
+		peer.AllowedIPs = ipNetStrings(devicePeer.AllowedIPs)
+		desiredPeer, found := desiredPeers[publicKey]
+		delete(desiredPeers, publicKey)
+		switch {
+		case !peer.InStore:
+			peer.Issues = append(peer.Issues, IssueUnknownOnDevice)
+		case !peer.Enabled:
+			peer.Issues = append(peer.Issues, IssueDisabledOnDevice)
+		case found:
+			if peer.DeviceChanges = peerChanges(devicePeer, desiredPeer); len(peer.DeviceChanges) > 0 {
+				peer.Issues = append(peer.Issues, IssueChangedOnDevice)
+			}
+		}
+	}
+	for publicKey := range desiredPeers {
+		peer := peers.get(publicKey)
+		peer.Issues = append(peer.Issues, IssueMissingOnDevice)
+	}
+	return nil
+}
+
+// UnknownPeer to find a peer without a client on the named running interface or in its config file, for adopting it
+// as a client. The peer is taken from the config file if it is there, which keeps its "# Name:" comment and endpoint,
+// otherwise from the running interface. The returned source names where it was found.
+func UnknownPeer(db store.IStore, name string, publicKey string) (*wgconf.Peer, string, error) {
+	s, err := load(db)
+	if err != nil {
+		return nil, "", err
+	}
+	server, err := s.server(name)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid public key: %v", err)
+	}
+	for _, clientData := range s.clients {
+		if clientData.Client.PublicKey == key.String() {
+			return nil, "", fmt.Errorf("%w %s", ErrPeerHasClient, clientData.Client.Name)
+		}
+	}
+
+	if content, err := os.ReadFile(server.Interface.ConfigFilePath); err == nil {
+		if config, err := wgconf.Parse(content); err == nil {
+			for _, peer := range config.Peers {
+				if peer.PublicKey == key.String() {
+					return peer, server.Interface.ConfigFilePath, nil
+				}
+			}
+		}
+	}
+
+	wgClient, device, err := openDevice(name)
+	if err != nil {
+		return nil, "", err
+	}
+	defer wgClient.Close()
+	for _, devicePeer := range device.Peers {
+		if devicePeer.PublicKey != key {
+			continue
+		}
+		peer := &wgconf.Peer{
+			Comments:            map[string]string{},
+			PublicKey:           devicePeer.PublicKey.String(),
+			AllowedIPs:          ipNetStrings(devicePeer.AllowedIPs),
+			PersistentKeepalive: int(devicePeer.PersistentKeepaliveInterval.Seconds()),
+		}
+		// the endpoint of a peer on the interface is usually learned from its handshakes, so it is not kept
+		if devicePeer.PresharedKey != (wgtypes.Key{}) {
+			peer.PresharedKey = devicePeer.PresharedKey.String()
+		}
+		return peer, "interface " + name, nil
+	}
+	return nil, "", ErrPeerNotFound
+}
+
+// RemovePeer to remove a peer without an enabled client from the named running interface. The config file is not
+// changed, the next apply rewrites it from the store.
+func RemovePeer(db store.IStore, name string, publicKey string) error {
+	s, err := load(db)
+	if err != nil {
+		return err
+	}
+	if _, err := s.server(name); err != nil {
+		return err
+	}
+	key, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	for _, clientData := range s.clients {
+		if clientData.Client.PublicKey == key.String() && clientData.Client.Enabled {
+			return fmt.Errorf("%w %s, disable the client instead", ErrPeerHasClient, clientData.Client.Name)
+		}
+	}
+
+	wgClient, device, err := openDevice(name)
+	if err != nil {
+		return err
+	}
+	defer wgClient.Close()
+	for _, devicePeer := range device.Peers {
+		if devicePeer.PublicKey == key {
+			return wgClient.ConfigureDevice(name, wgtypes.Config{
+				Peers: []wgtypes.PeerConfig{{PublicKey: key, Remove: true}},
+			})
+		}
+	}
+	return ErrPeerNotFound
+}
+
+func ipNetStrings(ipNets []net.IPNet) []string {
+	cidrs := make([]string, 0, len(ipNets))
+	for _, ipNet := range ipNets {
+		cidrs = append(cidrs, ipNet.String())
+	}
+	return cidrs
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]int, len(a))
+	for _, s := range a {
+		set[s]++
+	}
+	for _, s := range b {
+		if set[s] == 0 {
+			return false
+		}
+		set[s]--
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package apply
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// maxHookOutput is the number of bytes of a hook's output which are kept, the rest is dropped
+const maxHookOutput = 64 << 10
+
+// HookResult describes a run of a pre-apply or post-apply hook command
+type HookResult struct {
+	Command string `json:"command"`
+	// Output is the combined standard output and error of the command, cut off after maxHookOutput bytes
+	Output   string `json:"output"`
+	ExitCode int    `json:"exit_code"`
+	// Duration is the run time in milliseconds
+	Duration int64 `json:"duration"`
+	// Error is the reason the command failed, e.g. a non-zero exit code or the timeout, empty if it succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// Failed reports whether the command did not succeed
+func (h *HookResult) Failed() bool {
+	return h != nil && h.Error != ""
+}
+
+// limitedBuffer keeps the first maxHookOutput bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxHookOutput - b.Len(); len(p) > room {
+		b.Buffer.Write(p[:room])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// hookEnv returns the environment variables describing the apply to its hooks
+func hookEnv(mode string, revision uint64, configFile string, iface string) []string {
+	return append(os.Environ(),
+		"WGUI_APPLY_MODE="+mode,
+		"WGUI_CONFIG_FILE="+configFile,
+		"WGUI_INTERFACE="+iface,
+		"WGUI_CONFIG_REVISION="+strconv.FormatUint(revision, 10),
+	)
+}
+
+// runHook to run command with sh, as wg-quick does for PostUp and the like, and kill it after timeout
+func runHook(command string, timeout time.Duration, env []string) *HookResult {
+	hook := &HookResult{Command: command}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var output limitedBuffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = env
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// do not wait for children of the shell which keep the output open after it was killed
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	hook.Duration = time.Since(start).Milliseconds()
+	hook.Output = output.String()
+	if output.truncated {
+		hook.Output += "\n(output truncated)"
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		hook.ExitCode = -1
+		hook.Error = fmt.Sprintf("timed out after %s", timeout)
+	case errors.As(err, &exitErr):
+		hook.ExitCode = exitErr.ExitCode()
+		hook.Error = fmt.Sprintf("exited with code %d", hook.ExitCode)
+	case err != nil:
+		hook.ExitCode = -1
+		hook.Error = err.Error()
+	}
+	return hook
+}
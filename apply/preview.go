@@ -0,0 +1,182 @@
+package apply
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+	"github.com/ngoduykhanh/wireguard-ui/wgconf"
+)
+
+// Peer change actions
+const (
+	PeerAdd    = "add"
+	PeerUpdate = "update"
+	PeerRemove = "remove"
+)
+
+// PeerChange is a peer which Apply would add, update or remove on the running interface
+type PeerChange struct {
+	Action    string `json:"action"`
+	PublicKey string `json:"public_key"`
+	// Client is the name of the client with the peer's public key, empty for peers unknown to the store
+	Client string `json:"client,omitempty"`
+	// Fields are the changed fields of an updated peer
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Preview describes what Apply would change, without changing anything
+type Preview struct {
+	Mode       string             `json:"mode"`
+	Interfaces []InterfacePreview `json:"interfaces"`
+}
+
+// InterfacePreview describes what Apply would change for one interface
+type InterfacePreview struct {
+	Name string `json:"name"`
+	// ConfigFile and ConfigDiff are set if the config file is written. ConfigDiff is a unified diff from the file on
+	// disk to the rendered config, with private and preshared keys hidden, empty if the file is up to date.
+	ConfigFile string `json:"config_file,omitempty"`
+	ConfigDiff string `json:"config_diff"`
+	// ConfigError is set if the rendered config is invalid, Apply refuses to write it
+	ConfigError string `json:"config_error,omitempty"`
+	// Device and the changes to the running interface are set if it is configured, or if asked for
+	Device           bool         `json:"device"`
+	InterfaceChanges []string     `json:"interface_changes,omitempty"`
+	Peers            []PeerChange `json:"peers,omitempty"`
+	// InterfaceError is the reason the interface could not be compared, e.g. because it is not up
+	InterfaceError string `json:"interface_error,omitempty"`
+}
+
+// PreviewChanges to compute what Apply would change according to util.ApplyMode. If live is set, the peers of the
+// running interfaces are compared even if the apply mode only writes the config files.
+func PreviewChanges(db store.IStore, tmplDir fs.FS, live bool) (Preview, error) {
+	preview := Preview{Mode: util.ApplyMode, Interfaces: []InterfacePreview{}}
+	if err := ValidateMode(util.ApplyMode); err != nil {
+		return preview, err
+	}
+
+	s, err := load(db)
+	if err != nil {
+		return preview, err
+	}
+
+	for _, server := range s.servers {
+		iface := InterfacePreview{Name: server.Interface.Name}
+		clients := s.clientsOf(iface.Name)
+
+		if writesFile(util.ApplyMode) {
+			iface.ConfigFile = server.Interface.ConfigFilePath
+			var rendered bytes.Buffer
+			if err := util.RenderWireGuardServerConfig(&rendered, tmplDir, server, clients, s.users, s.settings); err != nil {
+				return preview, fmt.Errorf("cannot render config file of %s: %v", iface.Name, err)
+			}
+			if err := wgconf.Check(rendered.Bytes()); err != nil {
+				iface.ConfigError = err.Error()
+			}
+			current, err := os.ReadFile(iface.ConfigFile)
+			if err != nil && !os.IsNotExist(err) {
+				return preview, fmt.Errorf("cannot read config file of %s: %v", iface.Name, err)
+			}
+			iface.ConfigDiff, err = configFileDiff(iface.ConfigFile, current, iface.ConfigFile+" (new)", rendered.Bytes())
+			if err != nil {
+				return preview, err
+			}
+		}
+
+		if configuresDevice(util.ApplyMode) || live {
+			iface.Device = true
+			if err := previewDevice(&iface, server, clients, s.settings); err != nil {
+				iface.InterfaceError = err.Error()
+			}
+		}
+
+		preview.Interfaces = append(preview.Interfaces, iface)
+	}
+
+	return preview, nil
+}
+
+// configFileDiff to compare two versions of a config file as a unified diff, with the keys hidden
+func configFileDiff(fromName string, from []byte, toName string, to []byte) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(hideKeys(string(from))),
+		B:        difflib.SplitLines(hideKeys(string(to))),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+}
+
+// hideKeys to replace the private and preshared keys of a config file with a short fingerprint, so the diff shows
+// which keys change without disclosing them
+func hideKeys(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "privatekey", "presharedkey":
+			sum := sha256.Sum256([]byte(strings.TrimSpace(value)))
+			lines[i] = name + "= (hidden, fingerprint " + hex.EncodeToString(sum[:4]) + ")"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// previewDevice to compare the peers of the running interface with the store
+func previewDevice(preview *InterfacePreview, server model.Server, clients []model.ClientData, settings model.GlobalSetting) error {
+	desired, err := DesiredConfig(server, clients, settings)
+	if err != nil {
+		return err
+	}
+
+	wgClient, device, err := openDevice(preview.Name)
+	if err != nil {
+		return err
+	}
+	defer wgClient.Close()
+
+	config := Diff(device, desired)
+	if config.PrivateKey != nil {
+		preview.InterfaceChanges = append(preview.InterfaceChanges, "private_key")
+	}
+	if config.ListenPort != nil {
+		preview.InterfaceChanges = append(preview.InterfaceChanges, "listen_port")
+	}
+
+	clientNames := make(map[string]string, len(clients))
+	for _, clientData := range clients {
+		clientNames[clientData.Client.PublicKey] = clientData.Client.Name
+	}
+	existing := make(map[string]int, len(device.Peers))
+	for i, peer := range device.Peers {
+		existing[peer.PublicKey.String()] = i
+	}
+	for _, peer := range config.Peers {
+		change := PeerChange{PublicKey: peer.PublicKey.String(), Client: clientNames[peer.PublicKey.String()]}
+		switch {
+		case peer.Remove:
+			change.Action = PeerRemove
+		case peer.UpdateOnly:
+			change.Action = PeerUpdate
+			change.Fields = peerChanges(device.Peers[existing[change.PublicKey]], peer)
+		default:
+			change.Action = PeerAdd
+		}
+		preview.Peers = append(preview.Peers, change)
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package apply
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// RollbackDiff to compare the config file on disk with one of its backups, as a unified diff with the keys hidden
+func RollbackDiff(configPath string, name string) (string, error) {
+	backup, err := util.ReadConfigBackup(configPath, name)
+	if err != nil {
+		return "", err
+	}
+	current, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("cannot read config file: %v", err)
+	}
+	return configFileDiff(configPath, current, name, backup)
+}
+
+// Rollback to replace the config file with one of its backups. Only the file is changed, the running interface and
+// the database are left as they are, so the next Apply writes the configuration from the database again.
+func Rollback(configPath string, name string) error {
+	return util.RollbackConfigFile(configPath, name)
+}
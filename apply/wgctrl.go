@@ -0,0 +1,193 @@
+package apply
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+)
+
+// configureDevice to bring the running interface in line with the store. Only the peers which differ from the
+// desired state are sent to the kernel, so the sessions of the other peers are kept.
+func configureDevice(result *InterfaceResult, server model.Server, clients []model.ClientData, settings model.GlobalSetting) error {
+	desired, err := DesiredConfig(server, clients, settings)
+	if err != nil {
+		return err
+	}
+
+	wgClient, device, err := openDevice(result.Name)
+	if err != nil {
+		return err
+	}
+	defer wgClient.Close()
+
+	config := Diff(device, desired)
+	result.InterfaceUpdated = config.PrivateKey != nil || config.ListenPort != nil
+	for _, peer := range config.Peers {
+		switch {
+		case peer.Remove:
+			result.PeersRemoved++
+		case peer.UpdateOnly:
+			result.PeersUpdated++
+		default:
+			result.PeersAdded++
+		}
+	}
+	if !result.InterfaceUpdated && len(config.Peers) == 0 {
+		return nil
+	}
+
+	return wgClient.ConfigureDevice(result.Name, config)
+}
+
+// openDevice to read the current configuration of the interface. The returned client has to be closed.
+func openDevice(name string) (*wgctrl.Client, *wgtypes.Device, error) {
+	wgClient, err := wgctrl.New()
+	if err != nil {
+		return nil, nil, err
+	}
+	device, err := wgClient.Device(name)
+	if err != nil {
+		wgClient.Close()
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("interface %s not found, it has to be up", name)
+		}
+		return nil, nil, err
+	}
+	return wgClient, device, nil
+}
+
+// DesiredConfig to compute the interface configuration and the peers of the enabled clients from the store, clients
+// being those of the server's interface. Only the settings which can be changed through wgctrl are included,
+// addresses, MTU, routing table and scripts are only applied by wg-quick. The firewall mark is left out, the config
+// file does not set it either, so both apply modes leave the mark of the interface as it is.
+func DesiredConfig(server model.Server, clients []model.ClientData, settings model.GlobalSetting) (wgtypes.Config, error) {
+	config := wgtypes.Config{}
+
+	privateKey, err := wgtypes.ParseKey(server.KeyPair.PrivateKey)
+	if err != nil {
+		return config, fmt.Errorf("invalid server private key: %v", err)
+	}
+	config.PrivateKey = &privateKey
+	listenPort := server.Interface.ListenPort
+	config.ListenPort = &listenPort
+
+	keepalive := time.Duration(settings.PersistentKeepalive) * time.Second
+	for _, clientData := range clients {
+		client := clientData.Client
+		if !client.Enabled {
+			continue
+		}
+
+		peer := wgtypes.PeerConfig{PersistentKeepaliveInterval: &keepalive, ReplaceAllowedIPs: true}
+		if peer.PublicKey, err = wgtypes.ParseKey(client.PublicKey); err != nil {
+			return config, fmt.Errorf("client %s has an invalid public key: %v", client.Name, err)
+		}
+		presharedKey := wgtypes.Key{}
+		if client.PresharedKey != "" {
+			if presharedKey, err = wgtypes.ParseKey(client.PresharedKey); err != nil {
+				return config, fmt.Errorf("client %s has an invalid preshared key: %v", client.Name, err)
+			}
+		}
+		peer.PresharedKey = &presharedKey
+		for _, cidr := range append(append([]string{}, client.AllocatedIPs...), client.ExtraAllowedIPs...) {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return config, fmt.Errorf("client %s has an invalid allowed ip %s: %v", client.Name, cidr, err)
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, *ipNet)
+		}
+		if client.Endpoint != "" {
+			if peer.Endpoint, err = net.ResolveUDPAddr("udp", client.Endpoint); err != nil {
+				return config, fmt.Errorf("client %s has an invalid endpoint: %v", client.Name, err)
+			}
+		}
+		config.Peers = append(config.Peers, peer)
+	}
+
+	return config, nil
+}
+
+// Diff to compute the changes which bring the device to the desired configuration. Peers are added, updated or
+// removed one by one, unchanged peers and interface settings are left out.
+func Diff(device *wgtypes.Device, desired wgtypes.Config) wgtypes.Config {
+	config := wgtypes.Config{}
+	if desired.PrivateKey != nil && *desired.PrivateKey != device.PrivateKey {
+		config.PrivateKey = desired.PrivateKey
+	}
+	if desired.ListenPort != nil && *desired.ListenPort != device.ListenPort {
+		config.ListenPort = desired.ListenPort
+	}
+
+	current := make(map[wgtypes.Key]wgtypes.Peer, len(device.Peers))
+	for _, peer := range device.Peers {
+		current[peer.PublicKey] = peer
+	}
+
+	for _, peer := range desired.Peers {
+		existing, found := current[peer.PublicKey]
+		delete(current, peer.PublicKey)
+		if !found {
+			config.Peers = append(config.Peers, peer)
+			continue
+		}
+		if len(peerChanges(existing, peer)) == 0 {
+			continue
+		}
+		// an endpoint learned from the peer's handshakes is kept unless the client has a configured one
+		peer.UpdateOnly = true
+		config.Peers = append(config.Peers, peer)
+	}
+
+	removed := make([]wgtypes.PeerConfig, 0, len(current))
+	for publicKey := range current {
+		removed = append(removed, wgtypes.PeerConfig{PublicKey: publicKey, Remove: true})
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].PublicKey.String() < removed[j].PublicKey.String()
+	})
+	config.Peers = append(config.Peers, removed...)
+
+	return config
+}
+
+// peerChanges to list the fields in which the device's configuration of a peer differs from the desired one
+func peerChanges(existing wgtypes.Peer, desired wgtypes.PeerConfig) []string {
+	var fields []string
+	if desired.PresharedKey != nil && *desired.PresharedKey != existing.PresharedKey {
+		fields = append(fields, "preshared_key")
+	}
+	if desired.PersistentKeepaliveInterval != nil && *desired.PersistentKeepaliveInterval != existing.PersistentKeepaliveInterval {
+		fields = append(fields, "persistent_keepalive")
+	}
+	if desired.Endpoint != nil && (existing.Endpoint == nil || desired.Endpoint.String() != existing.Endpoint.String()) {
+		fields = append(fields, "endpoint")
+	}
+	if !sameIPNets(existing.AllowedIPs, desired.AllowedIPs) {
+		fields = append(fields, "allowed_ips")
+	}
+	return fields
+}
+
+func sameIPNets(a, b []net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]int, len(a))
+	for _, ipNet := range a {
+		set[ipNet.String()]++
+	}
+	for _, ipNet := range b {
+		if set[ipNet.String()] == 0 {
+			return false
+		}
+		set[ipNet.String()]--
+	}
+	return true
+}
@@ -0,0 +1,287 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/ngoduykhanh/wireguard-ui/ipam"
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// FormatVersion is the version of the archive format written by Encode. Version 1 held a single server interface.
+const FormatVersion = 2
+
+// Archive is a consistent snapshot of all data held by a store. Keys are held in plaintext in memory, Encode
+// encrypts them with the database encryption key if one is configured.
+type Archive struct {
+	Version        int                   `json:"version"`
+	CreatedAt      time.Time             `json:"created_at"`
+	Users          []model.User          `json:"users"`
+	Interfaces     []Interface           `json:"interfaces"`
+	GlobalSettings model.GlobalSetting   `json:"global_settings"`
+	Clients        []model.Client        `json:"clients"`
+	WakeOnLanHosts []model.WakeOnLanHost `json:"wake_on_lan_hosts"`
+}
+
+// Interface is a server interface with its key pair
+type Interface struct {
+	Interface model.ServerInterface `json:"interface"`
+	KeyPair   model.ServerKeypair   `json:"keypair"`
+}
+
+// archiveV1 holds the parts of a version 1 archive which changed in version 2
+type archiveV1 struct {
+	ServerInterface model.ServerInterface `json:"server_interface"`
+	ServerKeyPair   model.ServerKeypair   `json:"server_keypair"`
+	GlobalSettings  struct {
+		ConfigFilePath string `json:"config_file_path"`
+	} `json:"global_settings"`
+}
+
+// Create to take a snapshot of all data in the store. The data is read within a single store update, so no
+// concurrent write can be half included.
+func Create(db store.IStore) (Archive, error) {
+	archive := Archive{Version: FormatVersion, CreatedAt: time.Now().UTC()}
+
+	err := db.Update(func(tx store.IStore) error {
+		users, err := tx.GetUsers()
+		if err != nil {
+			return fmt.Errorf("cannot read users: %v", err)
+		}
+		servers, err := tx.GetServers()
+		if err != nil {
+			return fmt.Errorf("cannot read servers: %v", err)
+		}
+		globalSettings, err := tx.GetGlobalSettings()
+		if err != nil {
+			return fmt.Errorf("cannot read global settings: %v", err)
+		}
+		clients, err := tx.GetClients(false)
+		if err != nil {
+			return fmt.Errorf("cannot read clients: %v", err)
+		}
+		hosts, err := tx.GetWakeOnLanHosts()
+		if err != nil {
+			return fmt.Errorf("cannot read wake on lan hosts: %v", err)
+		}
+
+		archive.Users = users
+		for _, server := range servers {
+			archive.Interfaces = append(archive.Interfaces, Interface{Interface: *server.Interface, KeyPair: *server.KeyPair})
+		}
+		archive.GlobalSettings = globalSettings
+		for _, clientData := range clients {
+			archive.Clients = append(archive.Clients, *clientData.Client)
+		}
+		archive.WakeOnLanHosts = hosts
+		return nil
+	})
+
+	return archive, err
+}
+
+// Encode to write the archive as JSON, encrypting the keys if a database encryption key is configured
+func Encode(w io.Writer, archive Archive) error {
+	interfaces := make([]Interface, 0, len(archive.Interfaces))
+	for _, iface := range archive.Interfaces {
+		var err error
+		if iface.KeyPair, err = util.EncryptServerKeyPair(iface.KeyPair); err != nil {
+			return err
+		}
+		interfaces = append(interfaces, iface)
+	}
+	archive.Interfaces = interfaces
+	clients := make([]model.Client, 0, len(archive.Clients))
+	for _, client := range archive.Clients {
+		encrypted, err := util.EncryptClientKeys(client)
+		if err != nil {
+			return err
+		}
+		clients = append(clients, encrypted)
+	}
+	archive.Clients = clients
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(archive)
+}
+
+// Decode to read an archive written by Encode, decrypting the keys with the configured database encryption key.
+// Archives of version 1 are converted, their interface is named after the config file and gets all clients.
+func Decode(r io.Reader) (Archive, error) {
+	archive := Archive{}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return archive, fmt.Errorf("cannot read backup: %v", err)
+	}
+	if err := json.Unmarshal(content, &archive); err != nil {
+		return archive, fmt.Errorf("cannot decode backup: %v", err)
+	}
+	if archive.Version < 1 || archive.Version > FormatVersion {
+		return archive, fmt.Errorf("unsupported backup version %d, must be between 1 and %d", archive.Version, FormatVersion)
+	}
+
+	if archive.Version == 1 {
+		v1 := archiveV1{}
+		if err := json.Unmarshal(content, &v1); err != nil {
+			return archive, fmt.Errorf("cannot decode backup: %v", err)
+		}
+		configFilePath := v1.GlobalSettings.ConfigFilePath
+		if configFilePath == "" {
+			configFilePath = util.DefaultConfigFilePath
+		}
+		v1.ServerInterface.Name = util.InterfaceNameFromPath(configFilePath)
+		v1.ServerInterface.ConfigFilePath = configFilePath
+		archive.Interfaces = []Interface{{Interface: v1.ServerInterface, KeyPair: v1.ServerKeyPair}}
+		for i := range archive.Clients {
+			archive.Clients[i].Interface = v1.ServerInterface.Name
+		}
+		archive.Version = FormatVersion
+	}
+
+	for i := range archive.Interfaces {
+		if err := util.DecryptServerKeyPair(&archive.Interfaces[i].KeyPair); err != nil {
+			return archive, err
+		}
+	}
+	for i := range archive.Clients {
+		if err := util.DecryptClientKeys(&archive.Clients[i]); err != nil {
+			return archive, err
+		}
+	}
+	return archive, nil
+}
+
+// Validate to check that the archive holds a complete and consistent data set
+func Validate(archive Archive) error {
+	var problems []string
+	addProblem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	// users
+	hasAdmin := false
+	usernames := make(map[string]bool)
+	for _, user := range archive.Users {
+		if user.Username == "" {
+			addProblem("user without username")
+			continue
+		}
+		if usernames[user.Username] {
+			addProblem("duplicate user %s", user.Username)
+		}
+		usernames[user.Username] = true
+		if user.PasswordHash == "" && user.Password == "" {
+			addProblem("user %s has no password", user.Username)
+		}
+		hasAdmin = hasAdmin || user.Admin
+	}
+	if !hasAdmin {
+		addProblem("no administrator user")
+	}
+
+	// server interfaces
+	if len(archive.Interfaces) == 0 {
+		addProblem("no server interface")
+	}
+	interfaceAddresses := make(map[string][]string, len(archive.Interfaces))
+	allocatedIPs := make([]string, 0)
+	for _, iface := range archive.Interfaces {
+		name := iface.Interface.Name
+		if !util.ValidateInterfaceName(name) {
+			addProblem("invalid server interface name %q", name)
+			continue
+		}
+		if _, found := interfaceAddresses[name]; found {
+			addProblem("duplicate server interface %s", name)
+		}
+		interfaceAddresses[name] = iface.Interface.Addresses
+		if !util.ValidateServerAddresses(iface.Interface.Addresses) {
+			addProblem("invalid addresses %v of server interface %s", iface.Interface.Addresses, name)
+		}
+		if iface.Interface.ListenPort < 1 || iface.Interface.ListenPort > 65535 {
+			addProblem("invalid listen port %d of server interface %s", iface.Interface.ListenPort, name)
+		}
+		if iface.Interface.ConfigFilePath == "" {
+			addProblem("server interface %s has no config file path", name)
+		}
+		if privateKey, err := wgtypes.ParseKey(iface.KeyPair.PrivateKey); err != nil {
+			addProblem("invalid private key of server interface %s", name)
+		} else if privateKey.PublicKey().String() != iface.KeyPair.PublicKey {
+			addProblem("public key of server interface %s does not match the private key", name)
+		}
+		for _, cidr := range iface.Interface.Addresses {
+			if ip, err := util.GetIPFromCIDR(cidr); err == nil {
+				allocatedIPs = append(allocatedIPs, ip)
+			}
+		}
+	}
+
+	// global settings
+	if !util.ValidateIPAddressList(archive.GlobalSettings.DNSServers) {
+		addProblem("invalid dns servers %v", archive.GlobalSettings.DNSServers)
+	}
+
+	// clients
+	clientIDs := make(map[string]bool)
+	publicKeys := make(map[string]bool)
+	for _, client := range archive.Clients {
+		if client.ID == "" {
+			addProblem("client %s without id", client.Name)
+			continue
+		}
+		if clientIDs[client.ID] {
+			addProblem("duplicate client %s", client.ID)
+		}
+		clientIDs[client.ID] = true
+		if _, err := wgtypes.ParseKey(client.PublicKey); err != nil {
+			addProblem("client %s has an invalid public key", client.ID)
+		} else if publicKeys[client.PublicKey] {
+			addProblem("client %s has a duplicate public key", client.ID)
+		}
+		publicKeys[client.PublicKey] = true
+		if addresses, found := interfaceAddresses[client.Interface]; !found {
+			addProblem("client %s has an unknown interface %q", client.ID, client.Interface)
+		} else if _, err := ipam.ValidateIPAllocation(addresses, allocatedIPs, client.AllocatedIPs); err != nil {
+			addProblem("client %s: %v", client.ID, err)
+		}
+		for _, cidr := range client.AllocatedIPs {
+			if ip, err := util.GetIPFromCIDR(cidr); err == nil {
+				allocatedIPs = append(allocatedIPs, ip)
+			}
+		}
+		if !util.ValidateAllowedIPs(client.AllowedIPs) || !util.ValidateExtraAllowedIPs(client.ExtraAllowedIPs) {
+			addProblem("client %s has invalid allowed ips", client.ID)
+		}
+		if !util.ValidateQuota(client.Quota) {
+			addProblem("client %s has an invalid quota", client.ID)
+		}
+	}
+
+	// wake on lan hosts
+	hosts := make(map[string]bool)
+	for _, host := range archive.WakeOnLanHosts {
+		resourceName, err := host.ResolveResourceName()
+		if err != nil {
+			addProblem("wake on lan host %s: %v", host.Name, err)
+			continue
+		}
+		if hosts[resourceName] {
+			addProblem("duplicate wake on lan host %s", host.MacAddress)
+		}
+		hosts[resourceName] = true
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid backup: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
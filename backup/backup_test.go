@@ -0,0 +1,268 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// testKeys are key pairs generated once for the tests, the first for the server and the others for clients
+var testKeys = func() []model.ServerKeypair {
+	keys := make([]model.ServerKeypair, 3)
+	for i := range keys {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			panic(err)
+		}
+		keys[i] = model.ServerKeypair{PrivateKey: key.String(), PublicKey: key.PublicKey().String()}
+	}
+	return keys
+}()
+
+// archiveV1JSON returns a version 1 archive with a single client, and with the config file path of the interface in
+// the global settings if it is not empty
+func archiveV1JSON(configFilePath string) string {
+	settings := `"dns_servers": ["1.1.1.1"], "mtu": "1450", "persistent_keepalive": "15"`
+	if configFilePath != "" {
+		settings += fmt.Sprintf(`, "config_file_path": %q`, configFilePath)
+	}
+	return fmt.Sprintf(`{
+	"version": 1,
+	"created_at": "2023-06-01T12:00:00Z",
+	"users": [{"username": "admin", "password_hash": "hash", "admin": true}],
+	"server_interface": {"addresses": ["10.252.1.1/24"], "listen_port": "51820", "post_up": "echo up"},
+	"server_keypair": {"private_key": %q, "public_key": %q},
+	"global_settings": {%s},
+	"clients": [{"id": "client1", "name": "alice", "public_key": %q, "allocated_ips": ["10.252.1.2/32"],
+		"allowed_ips": ["0.0.0.0/0"], "extra_allowed_ips": [], "enabled": true}],
+	"wake_on_lan_hosts": []
+}`, testKeys[0].PrivateKey, testKeys[0].PublicKey, settings, testKeys[1].PublicKey)
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name                string
+		content             string
+		wantErr             string
+		wantInterface       string
+		wantConfigFilePath  string
+		wantClientInterface string
+	}{
+		{
+			name:                "version 1 named after the config file",
+			content:             archiveV1JSON("/etc/wireguard/wg1.conf"),
+			wantInterface:       "wg1",
+			wantConfigFilePath:  "/etc/wireguard/wg1.conf",
+			wantClientInterface: "wg1",
+		},
+		{
+			name:                "version 1 without config file path",
+			content:             archiveV1JSON(""),
+			wantInterface:       "wg0",
+			wantConfigFilePath:  util.DefaultConfigFilePath,
+			wantClientInterface: "wg0",
+		},
+		{
+			name: "version 2",
+			content: fmt.Sprintf(`{"version": 2, "interfaces": [{"interface": {"name": "wg5", "config_file_path": "/tmp/wg5.conf"},
+				"keypair": {"private_key": %q, "public_key": %q}}], "clients": [{"id": "client1", "interface": "wg5"}]}`,
+				testKeys[0].PrivateKey, testKeys[0].PublicKey),
+			wantInterface:       "wg5",
+			wantConfigFilePath:  "/tmp/wg5.conf",
+			wantClientInterface: "wg5",
+		},
+		{
+			name:    "without version",
+			content: `{"users": []}`,
+			wantErr: "unsupported backup version 0",
+		},
+		{
+			name:    "newer version",
+			content: `{"version": 3}`,
+			wantErr: "unsupported backup version 3",
+		},
+		{
+			name:    "not json",
+			content: `version: 2`,
+			wantErr: "cannot decode backup",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := Decode(strings.NewReader(tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if archive.Version != FormatVersion {
+				t.Errorf("version = %d, want %d", archive.Version, FormatVersion)
+			}
+			if len(archive.Interfaces) != 1 {
+				t.Fatalf("got %d interfaces, want 1", len(archive.Interfaces))
+			}
+			iface := archive.Interfaces[0]
+			if iface.Interface.Name != tt.wantInterface || iface.Interface.ConfigFilePath != tt.wantConfigFilePath {
+				t.Errorf("interface = %s with %s, want %s with %s", iface.Interface.Name, iface.Interface.ConfigFilePath,
+					tt.wantInterface, tt.wantConfigFilePath)
+			}
+			if iface.KeyPair.PrivateKey != testKeys[0].PrivateKey || iface.KeyPair.PublicKey != testKeys[0].PublicKey {
+				t.Errorf("key pair = %+v, want %+v", iface.KeyPair, testKeys[0])
+			}
+			for _, client := range archive.Clients {
+				if client.Interface != tt.wantClientInterface {
+					t.Errorf("client %s interface = %q, want %q", client.ID, client.Interface, tt.wantClientInterface)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeVersion1(t *testing.T) {
+	archive, err := Decode(strings.NewReader(archiveV1JSON("/etc/wireguard/wg1.conf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(archive); err != nil {
+		t.Fatalf("converted archive is invalid: %v", err)
+	}
+	iface := archive.Interfaces[0].Interface
+	if iface.ListenPort != 51820 || iface.PostUp != "echo up" || len(iface.Addresses) != 1 {
+		t.Errorf("interface = %+v, want the settings of the version 1 server interface", iface)
+	}
+	if len(archive.GlobalSettings.DNSServers) != 1 || archive.GlobalSettings.MTU != 1450 {
+		t.Errorf("global settings = %+v, want those of the archive", archive.GlobalSettings)
+	}
+
+	// written again it is a version 2 archive, which reads the same
+	var buf bytes.Buffer
+	if err := Encode(&buf, archive); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "server_interface") {
+		t.Errorf("encoded archive holds version 1 fields:\n%s", buf.String())
+	}
+	again, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameRecord(archive, again) {
+		t.Errorf("archive read again = %+v, want %+v", again, archive)
+	}
+}
+
+// validArchive returns an archive which passes Validate, for the tests to break
+func validArchive() Archive {
+	return Archive{
+		Version: FormatVersion,
+		Users:   []model.User{{Username: "admin", PasswordHash: "hash", Admin: true}},
+		Interfaces: []Interface{{
+			Interface: model.ServerInterface{Name: "wg0", Addresses: []string{"10.252.1.1/24"}, ListenPort: 51820,
+				ConfigFilePath: "/etc/wireguard/wg0.conf"},
+			KeyPair: testKeys[0],
+		}},
+		GlobalSettings: model.GlobalSetting{DNSServers: []string{"1.1.1.1"}},
+		Clients: []model.Client{
+			{ID: "client1", PublicKey: testKeys[1].PublicKey, Interface: "wg0", AllocatedIPs: []string{"10.252.1.2/32"},
+				AllowedIPs: []string{"0.0.0.0/0"}, ExtraAllowedIPs: []string{}},
+			{ID: "client2", PublicKey: testKeys[2].PublicKey, Interface: "wg0", AllocatedIPs: []string{"10.252.1.3/32"},
+				AllowedIPs: []string{"0.0.0.0/0"}, ExtraAllowedIPs: []string{}},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(archive *Archive)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			change: func(archive *Archive) {},
+		},
+		{
+			name:    "no administrator",
+			change:  func(archive *Archive) { archive.Users[0].Admin = false },
+			wantErr: "no administrator user",
+		},
+		{
+			name:    "user without password",
+			change:  func(archive *Archive) { archive.Users[0].PasswordHash = "" },
+			wantErr: "user admin has no password",
+		},
+		{
+			name:    "no interface",
+			change:  func(archive *Archive) { archive.Interfaces = nil },
+			wantErr: "no server interface",
+		},
+		{
+			name:    "invalid interface name",
+			change:  func(archive *Archive) { archive.Interfaces[0].Interface.Name = "../wg0" },
+			wantErr: `invalid server interface name "../wg0"`,
+		},
+		{
+			name:    "mismatching key pair",
+			change:  func(archive *Archive) { archive.Interfaces[0].KeyPair.PublicKey = testKeys[1].PublicKey },
+			wantErr: "public key of server interface wg0 does not match the private key",
+		},
+		{
+			name:    "duplicate client",
+			change:  func(archive *Archive) { archive.Clients[1].ID = "client1" },
+			wantErr: "duplicate client client1",
+		},
+		{
+			name:    "duplicate public key",
+			change:  func(archive *Archive) { archive.Clients[1].PublicKey = testKeys[1].PublicKey },
+			wantErr: "client client2 has a duplicate public key",
+		},
+		{
+			name:    "unknown interface",
+			change:  func(archive *Archive) { archive.Clients[0].Interface = "wg1" },
+			wantErr: `client client1 has an unknown interface "wg1"`,
+		},
+		{
+			name:    "address allocated twice",
+			change:  func(archive *Archive) { archive.Clients[1].AllocatedIPs = []string{"10.252.1.2/32"} },
+			wantErr: "client client2: IP 10.252.1.2 already allocated",
+		},
+		{
+			name:    "address of the server",
+			change:  func(archive *Archive) { archive.Clients[0].AllocatedIPs = []string{"10.252.1.1/32"} },
+			wantErr: "client client1: IP 10.252.1.1 already allocated",
+		},
+		{
+			name:    "invalid dns servers",
+			change:  func(archive *Archive) { archive.GlobalSettings.DNSServers = []string{"dns"} },
+			wantErr: "invalid dns servers [dns]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := validArchive()
+			tt.change(&archive)
+			err := Validate(archive)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
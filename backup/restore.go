@@ -0,0 +1,317 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+)
+
+// Changes lists the records which a restore adds, updates or removes
+type Changes struct {
+	Added   []string `json:"added"`
+	Updated []string `json:"updated"`
+	Removed []string `json:"removed"`
+}
+
+// Count returns the number of changed records
+func (c Changes) Count() int {
+	return len(c.Added) + len(c.Updated) + len(c.Removed)
+}
+
+// Plan describes what restoring an archive changes in a store
+type Plan struct {
+	Users          Changes `json:"users"`
+	Interfaces     Changes `json:"interfaces"`
+	Clients        Changes `json:"clients"`
+	WakeOnLanHosts Changes `json:"wake_on_lan_hosts"`
+	GlobalSettings bool    `json:"global_settings"`
+}
+
+// Empty reports whether the restore would not change anything
+func (p Plan) Empty() bool {
+	return p.Users.Count()+p.Interfaces.Count()+p.Clients.Count()+p.WakeOnLanHosts.Count() == 0 && !p.GlobalSettings
+}
+
+// String returns a human readable summary of the plan
+func (p Plan) String() string {
+	if p.Empty() {
+		return "No changes"
+	}
+
+	var b strings.Builder
+	for _, section := range []struct {
+		name    string
+		changes Changes
+	}{{"Users", p.Users}, {"Interfaces", p.Interfaces}, {"Clients", p.Clients}, {"Wake on lan hosts", p.WakeOnLanHosts}} {
+		for _, key := range section.changes.Added {
+			fmt.Fprintf(&b, "%s: add %s\n", section.name, key)
+		}
+		for _, key := range section.changes.Updated {
+			fmt.Fprintf(&b, "%s: update %s\n", section.name, key)
+		}
+		for _, key := range section.changes.Removed {
+			fmt.Fprintf(&b, "%s: remove %s\n", section.name, key)
+		}
+	}
+	if p.GlobalSettings {
+		b.WriteString("Global settings: update\n")
+	}
+	return b.String()
+}
+
+// Preview to validate the archive and compute what restoring it would change in the store, without writing anything
+func Preview(db store.IStore, archive Archive) (Plan, error) {
+	if err := Validate(archive); err != nil {
+		return Plan{}, err
+	}
+
+	var plan Plan
+	err := db.Update(func(tx store.IStore) error {
+		var err error
+		plan, err = diff(tx, archive, false)
+		return err
+	})
+	return plan, err
+}
+
+// Restore to validate the archive and replace all data in the store with it. Records missing from the archive are
+// removed. Records which change get a new revision, so editors holding the old one see a conflict.
+func Restore(db store.IStore, archive Archive) (Plan, error) {
+	if err := Validate(archive); err != nil {
+		return Plan{}, err
+	}
+
+	var plan Plan
+	err := db.Update(func(tx store.IStore) error {
+		var err error
+		plan, err = diff(tx, archive, true)
+		return err
+	})
+	return plan, err
+}
+
+// diff to compare the archive with the data in the store, and write the differences if apply is set
+func diff(tx store.IStore, archive Archive, apply bool) (Plan, error) {
+	var plan Plan
+
+	// users
+	users, err := tx.GetUsers()
+	if err != nil {
+		return plan, fmt.Errorf("cannot read users: %v", err)
+	}
+	currentUsers := make(map[string]model.User, len(users))
+	for _, user := range users {
+		currentUsers[user.Username] = user
+	}
+	for _, user := range archive.Users {
+		current, found := currentUsers[user.Username]
+		delete(currentUsers, user.Username)
+		if found {
+			user.Revision = current.Revision
+			if sameRecord(current, user) {
+				continue
+			}
+			plan.Users.Updated = append(plan.Users.Updated, user.Username)
+			user.Revision = current.Revision + 1
+		} else {
+			plan.Users.Added = append(plan.Users.Added, user.Username)
+			// revisions start at 1, archives of older versions hold none
+			if user.Revision < 1 {
+				user.Revision = 1
+			}
+		}
+		if apply {
+			if err := tx.SaveUser(user); err != nil {
+				return plan, fmt.Errorf("cannot save user %s: %v", user.Username, err)
+			}
+		}
+	}
+	for _, username := range sortedKeys(currentUsers) {
+		plan.Users.Removed = append(plan.Users.Removed, username)
+		if apply {
+			if err := tx.DeleteUser(username); err != nil {
+				return plan, fmt.Errorf("cannot remove user %s: %v", username, err)
+			}
+		}
+	}
+
+	// server interfaces
+	servers, err := tx.GetServers()
+	if err != nil {
+		return plan, fmt.Errorf("cannot read servers: %v", err)
+	}
+	currentServers := make(map[string]model.Server, len(servers))
+	for _, server := range servers {
+		currentServers[server.Interface.Name] = server
+	}
+	for _, iface := range archive.Interfaces {
+		name := iface.Interface.Name
+		current, found := currentServers[name]
+		delete(currentServers, name)
+		if found {
+			if sameRecord(*current.Interface, iface.Interface) && sameRecord(*current.KeyPair, iface.KeyPair) {
+				continue
+			}
+			plan.Interfaces.Updated = append(plan.Interfaces.Updated, name)
+		} else {
+			plan.Interfaces.Added = append(plan.Interfaces.Added, name)
+		}
+		if apply {
+			if err := tx.SaveServerInterface(iface.Interface); err != nil {
+				return plan, fmt.Errorf("cannot save server interface %s: %v", name, err)
+			}
+			if err := tx.SaveServerKeyPair(name, iface.KeyPair); err != nil {
+				return plan, fmt.Errorf("cannot save key pair of server interface %s: %v", name, err)
+			}
+		}
+	}
+	for _, name := range sortedKeys(currentServers) {
+		plan.Interfaces.Removed = append(plan.Interfaces.Removed, name)
+		if apply {
+			if err := tx.DeleteServer(name); err != nil {
+				return plan, fmt.Errorf("cannot remove server interface %s: %v", name, err)
+			}
+		}
+	}
+
+	// global settings
+	globalSettings, err := tx.GetGlobalSettings()
+	if err != nil {
+		return plan, fmt.Errorf("cannot read global settings: %v", err)
+	}
+	restoredSettings := archive.GlobalSettings
+	restoredSettings.Revision = globalSettings.Revision
+	if !sameRecord(globalSettings, restoredSettings) {
+		plan.GlobalSettings = true
+		restoredSettings.Revision = globalSettings.Revision + 1
+		if apply {
+			if err := tx.SaveGlobalSettings(restoredSettings); err != nil {
+				return plan, fmt.Errorf("cannot save global settings: %v", err)
+			}
+		}
+	}
+
+	// clients
+	clients, err := tx.GetClients(false)
+	if err != nil {
+		return plan, fmt.Errorf("cannot read clients: %v", err)
+	}
+	currentClients := make(map[string]model.Client, len(clients))
+	for _, clientData := range clients {
+		currentClients[clientData.Client.ID] = *clientData.Client
+	}
+	for _, client := range archive.Clients {
+		current, found := currentClients[client.ID]
+		delete(currentClients, client.ID)
+		if found {
+			client.Revision = current.Revision
+			if sameRecord(current, client) {
+				continue
+			}
+			plan.Clients.Updated = append(plan.Clients.Updated, clientLabel(client))
+			client.Revision = current.Revision + 1
+		} else {
+			plan.Clients.Added = append(plan.Clients.Added, clientLabel(client))
+			// revisions start at 1, archives of older versions hold none
+			if client.Revision < 1 {
+				client.Revision = 1
+			}
+		}
+		if apply {
+			if err := tx.SaveClient(client); err != nil {
+				return plan, fmt.Errorf("cannot save client %s: %v", client.ID, err)
+			}
+		}
+	}
+	for _, clientID := range sortedKeys(currentClients) {
+		plan.Clients.Removed = append(plan.Clients.Removed, clientLabel(currentClients[clientID]))
+		if apply {
+			if err := tx.DeleteClient(clientID); err != nil {
+				return plan, fmt.Errorf("cannot remove client %s: %v", clientID, err)
+			}
+		}
+	}
+
+	// wake on lan hosts
+	hosts, err := tx.GetWakeOnLanHosts()
+	if err != nil {
+		return plan, fmt.Errorf("cannot read wake on lan hosts: %v", err)
+	}
+	currentHosts := make(map[string]model.WakeOnLanHost, len(hosts))
+	for _, host := range hosts {
+		resourceName, _ := host.ResolveResourceName()
+		currentHosts[resourceName] = host
+	}
+	for _, host := range archive.WakeOnLanHosts {
+		resourceName, _ := host.ResolveResourceName()
+		current, found := currentHosts[resourceName]
+		delete(currentHosts, resourceName)
+		if found && sameRecord(current, host) {
+			continue
+		}
+		if found {
+			plan.WakeOnLanHosts.Updated = append(plan.WakeOnLanHosts.Updated, host.MacAddress)
+		} else {
+			plan.WakeOnLanHosts.Added = append(plan.WakeOnLanHosts.Added, host.MacAddress)
+		}
+		if apply {
+			if err := tx.SaveWakeOnLanHost(host); err != nil {
+				return plan, fmt.Errorf("cannot save wake on lan host %s: %v", host.MacAddress, err)
+			}
+		}
+	}
+	for _, resourceName := range sortedKeys(currentHosts) {
+		host := currentHosts[resourceName]
+		plan.WakeOnLanHosts.Removed = append(plan.WakeOnLanHosts.Removed, host.MacAddress)
+		if apply {
+			if err := tx.DeleteWakeOnHost(host); err != nil {
+				return plan, fmt.Errorf("cannot remove wake on lan host %s: %v", host.MacAddress, err)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// sameRecord to compare two records by their JSON encoding
+func sameRecord(a, b interface{}) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(encodedA) == string(encodedB)
+}
+
+func clientLabel(client model.Client) string {
+	if client.Name == "" {
+		return client.ID
+	}
+	return fmt.Sprintf("%s (%s)", client.Name, client.ID)
+}
+
+// sortedKeys returns the keys of a map keyed by string in order, so plans list records deterministically
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]model.User:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]model.Server:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]model.Client:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]model.WakeOnLanHost:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
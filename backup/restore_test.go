@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/store/jsondb"
+)
+
+// newTestDB returns an empty database. It is not initialized, which takes long to hash the password of the default
+// user.
+func newTestDB(t *testing.T) store.IStore {
+	dbPath := t.TempDir()
+	for _, collection := range []string{"users", "interfaces", "clients", model.WakeOnLanHostCollectionName} {
+		if err := os.Mkdir(filepath.Join(dbPath, collection), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := jsondb.New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveGlobalSettings(model.GlobalSetting{DNSServers: []string{}}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRestore(t *testing.T) {
+	archiveV1, err := Decode(strings.NewReader(archiveV1JSON("/etc/wireguard/wg1.conf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// before is restored first, as the data in the store
+		before  *Archive
+		archive Archive
+		wantErr string
+		want    Plan
+	}{
+		{
+			name:    "version 1 archive into an empty store",
+			archive: archiveV1,
+			want: Plan{
+				Users:          Changes{Added: []string{"admin"}},
+				Interfaces:     Changes{Added: []string{"wg1"}},
+				Clients:        Changes{Added: []string{"alice (client1)"}},
+				GlobalSettings: true,
+			},
+		},
+		{
+			name:    "same archive again",
+			before:  &archiveV1,
+			archive: archiveV1,
+		},
+		{
+			name:    "version 2 archive over a version 1 archive",
+			before:  &archiveV1,
+			archive: validArchive(),
+			want: Plan{
+				Interfaces:     Changes{Added: []string{"wg0"}, Removed: []string{"wg1"}},
+				Clients:        Changes{Added: []string{"client2"}, Updated: []string{"client1"}},
+				GlobalSettings: true,
+			},
+		},
+		{
+			name:    "invalid archive",
+			before:  &archiveV1,
+			archive: Archive{Version: FormatVersion},
+			wantErr: "invalid backup",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			if tt.before != nil {
+				if _, err := Restore(db, *tt.before); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before, err := Create(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			preview, err := Preview(db, tt.archive)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("preview error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			plan, err := Restore(db, tt.archive)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("restore error = %v, want %q", err, tt.wantErr)
+				}
+				after, err := Create(db)
+				if err != nil {
+					t.Fatal(err)
+				}
+				after.CreatedAt = before.CreatedAt
+				if !sameRecord(before, after) {
+					t.Errorf("store changed by a refused restore")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(plan, tt.want) {
+				t.Errorf("plan = %+v, want %+v", plan, tt.want)
+			}
+			if !reflect.DeepEqual(preview, plan) {
+				t.Errorf("preview = %+v, want the plan of the restore %+v", preview, plan)
+			}
+
+			// the store holds the archive, and restoring it again changes nothing
+			again, err := Preview(db, tt.archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !again.Empty() {
+				t.Errorf("plan after restoring = %+v, want no changes", again)
+			}
+			servers, err := db.GetServers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(servers) != len(tt.archive.Interfaces) || *servers[0].KeyPair != tt.archive.Interfaces[0].KeyPair {
+				t.Errorf("servers = %d, want the %d interfaces of the archive with their key pairs", len(servers),
+					len(tt.archive.Interfaces))
+			}
+		})
+	}
+}
+
+func TestRestoreRevisions(t *testing.T) {
+	db := newTestDB(t)
+	archive := validArchive()
+	if _, err := Restore(db, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client changed in the archive gets a new revision, the unchanged one keeps its revision
+	archive.Clients[0].Name = "renamed"
+	if _, err := Restore(db, archive); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]int64{"client1": 2, "client2": 1} {
+		clientData, err := db.GetClientByID(id, model.QRCodeSettings{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clientData.Client.Revision != want {
+			t.Errorf("client %s revision = %d, want %d", id, clientData.Client.Revision, want)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ngoduykhanh/wireguard-ui/backup"
+	"github.com/ngoduykhanh/wireguard-ui/importer"
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// cliAuditActor is recorded in the audit log for changes made by maintenance commands
+const cliAuditActor = "cli"
+
+// runCommand to execute a maintenance command given as positional arguments
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "rekey":
+		return rekeyCommand(args[1:])
+	case "backup":
+		return backupCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "import-config":
+		return importConfigCommand(args[1:])
+	case "import-clients":
+		return importClientsCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q, must be migrate, rekey, backup, restore, import-config or import-clients", args[0])
+	}
+}
+
+// migrateCommand to copy all data from one store backend to another, e.g.
+//
+//	wireguard-ui migrate --from jsondb:./db --to sqlite:./db/wireguard-ui.db
+func migrateCommand(args []string) error {
+	var from, to string
+
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.StringVar(&from, "from", "jsondb:"+flagDBPath, "Source store as type:path.")
+	fs.StringVar(&to, "to", "", "Target store as type:path, e.g. sqlite:./db/wireguard-ui.db.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if to == "" {
+		return errors.New("--to is required")
+	}
+	if from == to {
+		return errors.New("--from and --to must be different stores")
+	}
+
+	src, err := openStoreSpec(from)
+	if err != nil {
+		return fmt.Errorf("cannot open source store: %v", err)
+	}
+	if err := src.Init(); err != nil {
+		return fmt.Errorf("cannot initialize source store: %v", err)
+	}
+
+	dst, err := openStoreSpec(to)
+	if err != nil {
+		return fmt.Errorf("cannot open target store: %v", err)
+	}
+	if err := dst.Init(); err != nil {
+		return fmt.Errorf("cannot initialize target store: %v", err)
+	}
+
+	result, err := store.Copy(src, dst)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Migrated %s to %s: %d users, %d interfaces, %d clients, %d wake on lan hosts, %d audit log entries\n",
+		from, to, result.Users, result.Interfaces, result.Clients, result.WakeOnLanHosts, result.AuditLogs)
+	return nil
+}
+
+// openStoreSpec to open a store given as "type:path", e.g. "jsondb:./db". The path may be omitted to use the
+// default location of the backend.
+func openStoreSpec(spec string) (store.IStore, error) {
+	dbType, dbPath, _ := strings.Cut(spec, ":")
+	return openStore(dbType, dbPath)
+}
+
+// rekeyCommand to re-encrypt the private keys stored in the database of the configured store, e.g.
+//
+//	wireguard-ui rekey                                 encrypt plaintext keys with the configured key
+//	wireguard-ui rekey --new-key-file /path/to/new.key rotate the configured key to a new one
+//	wireguard-ui rekey --decrypt                       store the keys in plaintext again
+func rekeyCommand(args []string) error {
+	var newKeyFile string
+	var decrypt bool
+
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	fs.StringVar(&newKeyFile, "new-key-file", "", "File containing the new database encryption key. The configured key is used if empty.")
+	fs.BoolVar(&decrypt, "decrypt", false, "Decrypt the keys and store them in plaintext.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	oldKey := util.DBEncryptionKey
+	newKey := oldKey
+	switch {
+	case decrypt && newKeyFile != "":
+		return errors.New("--decrypt and --new-key-file cannot be used together")
+	case decrypt:
+		newKey = nil
+	case newKeyFile != "":
+		content, err := os.ReadFile(newKeyFile)
+		if err != nil {
+			return fmt.Errorf("cannot read new key file: %v", err)
+		}
+		// strip line breaks the same way as the *_FILE environment variables
+		newKey = util.NewEncryptionKey(strings.NewReplacer("\r", "", "\n", "").Replace(string(content)))
+		if newKey == nil {
+			return errors.New("the new key file is empty")
+		}
+	case oldKey == nil:
+		return errors.New("no database encryption key is configured, set WGUI_DB_ENCRYPTION_KEY or WGUI_DB_ENCRYPTION_KEY_FILE, or use --new-key-file")
+	}
+
+	db, err := openStore(flagDBType, "")
+	if err != nil {
+		return err
+	}
+	if err := db.Init(); err != nil {
+		return err
+	}
+
+	// the writes of a jsondb database are not atomic, so an interrupted rekey would leave keys encrypted with either
+	// key. A copy of the database is taken to recover from it.
+	backupPath := ""
+	if snapshotter, ok := db.(store.Snapshotter); ok {
+		if backupPath, err = snapshotter.Snapshot("rekey"); err != nil {
+			return fmt.Errorf("cannot back up database before rekeying: %v", err)
+		}
+		fmt.Printf("Backed up database to %s\n", backupPath)
+	}
+
+	interfaceCount, count := 0, 0
+	err = db.Update(func(tx store.IStore) error {
+		// read everything with the old key before writing anything with the new one
+		util.DBEncryptionKey = oldKey
+		servers, err := tx.GetServers()
+		if err != nil {
+			return fmt.Errorf("cannot read server key pairs: %v", err)
+		}
+		clients, err := tx.GetClients(false)
+		if err != nil {
+			return fmt.Errorf("cannot read clients: %v", err)
+		}
+		versions := make(map[string][]model.ClientVersion, len(clients))
+		for _, clientData := range clients {
+			if versions[clientData.Client.ID], err = tx.GetClientVersions(clientData.Client.ID); err != nil {
+				return fmt.Errorf("cannot read versions of client %s: %v", clientData.Client.ID, err)
+			}
+		}
+
+		util.DBEncryptionKey = newKey
+		for _, server := range servers {
+			if err := tx.SaveServerKeyPair(server.Interface.Name, *server.KeyPair); err != nil {
+				return fmt.Errorf("cannot save key pair of server interface %s: %v", server.Interface.Name, err)
+			}
+			interfaceCount++
+		}
+		for _, clientData := range clients {
+			if err := tx.SaveClient(*clientData.Client); err != nil {
+				return fmt.Errorf("cannot save client %s: %v", clientData.Client.ID, err)
+			}
+			for _, version := range versions[clientData.Client.ID] {
+				if err := tx.SaveClientVersion(version); err != nil {
+					return fmt.Errorf("cannot save version %d of client %s: %v", version.Revision, clientData.Client.ID, err)
+				}
+			}
+			count++
+		}
+		return tx.SaveAuditLog(util.NewAuditLog(cliAuditActor, "database.rekey", "database", nil, nil))
+	})
+	if err != nil {
+		if backupPath != "" {
+			return fmt.Errorf("%v, the database from before the rekey can be restored from %s", err, backupPath)
+		}
+		return err
+	}
+
+	if newKey == nil {
+		fmt.Printf("Decrypted the keys of %d interfaces and %d clients\n", interfaceCount, count)
+	} else {
+		fmt.Printf("Encrypted the keys of %d interfaces and %d clients\n", interfaceCount, count)
+	}
+	if backupPath != "" {
+		fmt.Printf("The backup in %s holds the keys as they were before, delete it once the database works with the new key\n", backupPath)
+	}
+	return nil
+}
+
+// backupCommand to write an archive of all data in the configured store, e.g.
+//
+//	wireguard-ui backup --output /var/backups/wireguard-ui.json
+func backupCommand(args []string) error {
+	var output string
+
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.StringVar(&output, "output", "", "File to write the backup to. The backup is written to stdout if empty.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openStore(flagDBType, "")
+	if err != nil {
+		return err
+	}
+	if err := db.Init(); err != nil {
+		return err
+	}
+
+	archive, err := backup.Create(db)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		return backup.Encode(os.Stdout, archive)
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot create backup file: %v", err)
+	}
+	if err := backup.Encode(f, archive); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote backup of %d users, %d clients, %d wake on lan hosts to %s\n",
+		len(archive.Users), len(archive.Clients), len(archive.WakeOnLanHosts), output)
+	return nil
+}
+
+// restoreCommand to replace all data in the configured store with an archive written by backup, e.g.
+//
+//	wireguard-ui restore --input /var/backups/wireguard-ui.json --dry-run
+func restoreCommand(args []string) error {
+	var input string
+	var dryRun bool
+
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.StringVar(&input, "input", "", "Backup file to restore.")
+	fs.BoolVar(&dryRun, "dry-run", false, "Validate the backup and print the changes without writing them.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if input == "" {
+		return errors.New("--input is required")
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		return fmt.Errorf("cannot open backup file: %v", err)
+	}
+	archive, err := backup.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	db, err := openStore(flagDBType, "")
+	if err != nil {
+		return err
+	}
+	if err := db.Init(); err != nil {
+		return err
+	}
+
+	var plan backup.Plan
+	if dryRun {
+		plan, err = backup.Preview(db, archive)
+	} else {
+		plan, err = backup.Restore(db, archive)
+	}
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		if err := db.SaveAuditLog(util.NewAuditLog(cliAuditActor, "backup.restore", "database", nil, plan)); err != nil {
+			return err
+		}
+	}
+
+	fmt.Print(plan)
+	if plan.Empty() {
+		fmt.Println()
+	}
+	return nil
+}
+
+// importConfigCommand to create clients, and optionally the server settings, from an existing wg-quick server config
+// file, e.g.
+//
+//	wireguard-ui import-config --input /etc/wireguard/wg0.conf --server
+func importConfigCommand(args []string) error {
+	var input string
+	var opts importer.ServerOptions
+
+	fs := flag.NewFlagSet("import-config", flag.ContinueOnError)
+	fs.StringVar(&input, "input", "", "WireGuard server config file to import.")
+	fs.BoolVar(&opts.Server, "server", false, "Also import the interface settings and private key, replacing those in the database.")
+	fs.StringVar(&opts.Interface, "interface", "", "Interface to import into, defaults to the one named after the config file. It is created if --server is given.")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Print what would be imported without writing it.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if input == "" {
+		return errors.New("--input is required")
+	}
+
+	content, err := os.ReadFile(input)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %v", err)
+	}
+
+	db, err := openStore(flagDBType, "")
+	if err != nil {
+		return err
+	}
+	if err := db.Init(); err != nil {
+		return err
+	}
+
+	report, err := importer.ServerConfig(db, input, content, opts)
+	if err != nil {
+		return err
+	}
+	if !opts.DryRun {
+		if err := db.SaveAuditLog(util.NewAuditLog(cliAuditActor, "config.import", input, nil, report)); err != nil {
+			return err
+		}
+	}
+
+	fmt.Print(report)
+	return nil
+}
+
+// importClientsCommand to create clients from wg-quick client config files or zip archives of them, e.g.
+//
+//	wireguard-ui import-clients alice.conf bob.conf contractors.zip
+func importClientsCommand(args []string) error {
+	var opts importer.ClientOptions
+
+	fs := flag.NewFlagSet("import-clients", flag.ContinueOnError)
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Print what would be imported without writing it.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("at least one config file is required")
+	}
+
+	var files []importer.File
+	for _, name := range fs.Args() {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("cannot read config file: %v", err)
+		}
+		files = append(files, importer.File{Name: name, Content: content})
+	}
+
+	db, err := openStore(flagDBType, "")
+	if err != nil {
+		return err
+	}
+	if err := db.Init(); err != nil {
+		return err
+	}
+
+	report, err := importer.ClientConfigs(db, files, opts)
+	if err != nil {
+		return err
+	}
+	if !opts.DryRun {
+		if err := db.SaveAuditLog(util.NewAuditLog(cliAuditActor, "client.import", "client configs", nil, report)); err != nil {
+			return err
+		}
+	}
+
+	fmt.Print(report)
+	return nil
+}
@@ -43,8 +43,27 @@ function renderClientList(data) {
             additionalNotesHtml = `<span class="info-box-text" style="display: none"><i class="fas fa-additional_notes"></i>${obj.Client.additional_notes.toUpperCase()}</span>`
         }
 
+        let expiresHtml = "";
+        if (obj.Client.expires_at) {
+            expiresHtml = `<span class="info-box-text" title="${prettyDateTime(obj.Client.expires_at)}"><i class="fas fa-hourglass-half"></i>
+                                    ${prettyRemainingTime(obj.Client.expires_at)}</span>`
+        }
+
+        // render the data volume of the current period, against the quota if the client has one
+        let usageHtml = "";
+        if (obj.Usage || obj.Client.quota) {
+            const used = obj.Usage ? obj.Usage.received_bytes + obj.Usage.transmit_bytes : 0;
+            const period = obj.Client.quota ? obj.Client.quota.period : "month";
+            let usageText = `${prettyBytes(used)} this ${period}`;
+            if (obj.Client.quota) {
+                usageText = `${prettyBytes(used)} of ${prettyBytes(obj.Client.quota.bytes)} this ${period}`;
+            }
+            usageHtml = `<span class="info-box-text${obj.Usage && obj.Usage.exceeded ? " text-danger" : ""}"><i class="fas fa-tachometer-alt"></i>
+                                    ${usageText}</span>`
+        }
+
         // render client html content
-        let html = `<div class="col-sm-6 col-md-6 col-lg-4" id="client_${obj.Client.id}">
+        let html = `<div class="col-sm-6 col-md-6 col-lg-4" id="client_${obj.Client.id}" data-revision="${obj.Client.revision}">
                         <div class="info-box">
                             <div class="overlay" id="paused_${obj.Client.id}"` + clientStatusHtml
                                 + `<i class="paused-client fas fa-3x fa-play" onclick="resumeClient('${obj.Client.id}')"></i>
@@ -73,6 +92,10 @@ function renderClientList(data) {
                                         <a class="dropdown-item" href="#" data-toggle="modal"
                                         data-target="#modal_edit_client" data-clientid="${obj.Client.id}"
                                         data-clientname="${obj.Client.name}">Edit</a>
+                                        <a class="dropdown-item" href="client/${obj.Client.id}">Details</a>
+                                        <a class="dropdown-item" href="#" data-toggle="modal"
+                                        data-target="#modal_client_history" data-clientid="${obj.Client.id}"
+                                        data-clientname="${obj.Client.name}">History</a>
                                         <a class="dropdown-item" href="#" data-toggle="modal"
                                         data-target="#modal_pause_client" data-clientid="${obj.Client.id}"
                                         data-clientname="${obj.Client.name}">Disable</a>
@@ -83,6 +106,7 @@ function renderClientList(data) {
                                 </div>
                                 <hr>
                                 <span class="info-box-text"><i class="fas fa-user"></i> ${obj.Client.name}</span>
+                                <span class="info-box-text"><i class="fas fa-network-wired"></i> ${obj.Client.interface}</span>
                                 <span class="info-box-text" style="display: none"><i class="fas fa-key"></i> ${obj.Client.public_key}</span>
                                 <span class="info-box-text" style="display: none"><i class="fas fa-subnetrange"></i>${subnetRangesString}</span>
                                 ${telegramHtml}
@@ -92,6 +116,8 @@ function renderClientList(data) {
                                     ${prettyDateTime(obj.Client.created_at)}</span>
                                 <span class="info-box-text"><i class="fas fa-history"></i>
                                     ${prettyDateTime(obj.Client.updated_at)}</span>
+                                ${expiresHtml}
+                                ${usageHtml}
                                 <span class="info-box-text"><i class="fas fa-server" style="${obj.Client.use_server_dns ? "opacity: 1.0" : "opacity: 0.5"}"></i>
                                     ${obj.Client.use_server_dns ? 'DNS enabled' : 'DNS disabled'}</span>
                                 <span class="info-box-text"><i class="fas fa-file"></i>
@@ -143,3 +169,66 @@ function prettyDateTime(timeStr) {
     const dateLocal = new Date(dt.getTime() - offsetMs);
     return dateLocal.toISOString().slice(0, 19).replace(/-/g, "/").replace("T", " ");
 }
+
+// prettyRemainingTime returns the time left until timeStr in the largest fitting unit
+function prettyRemainingTime(timeStr) {
+    const remainingMs = new Date(timeStr).getTime() - Date.now();
+    if (remainingMs <= 0) {
+        return "Expired " + prettyDateTime(timeStr);
+    }
+    const units = [["day", 24 * 60 * 60 * 1000], ["hour", 60 * 60 * 1000], ["minute", 60 * 1000]];
+    for (const [unit, unitMs] of units) {
+        const count = Math.floor(remainingMs / unitMs);
+        if (count > 0) {
+            return `Expires in ${count} ${unit}${count > 1 ? "s" : ""}`;
+        }
+    }
+    return "Expires in less than a minute";
+}
+
+// toDateTimeLocal formats timeStr as the value of a datetime-local input
+function toDateTimeLocal(timeStr) {
+    if (!timeStr) {
+        return "";
+    }
+    return prettyDateTime(timeStr).replace(/\//g, "-").replace(" ", "T").slice(0, 16);
+}
+
+// fromDateTimeLocal returns the value of a datetime-local input as ISO string, or null if it is empty
+function fromDateTimeLocal(value) {
+    if (!value) {
+        return null;
+    }
+    return new Date(value).toISOString();
+}
+
+// quotaUnit is the number of bytes of a GB in the quota inputs, in binary units like the status page
+const quotaUnit = 1024 * 1024 * 1024;
+
+// readQuota returns the quota entered in the inputs with the given id prefix, or null if it is unlimited
+function readQuota(prefix) {
+    const gb = parseFloat($(prefix + "_quota_gb").val());
+    if (!(gb > 0)) {
+        return null;
+    }
+    return {"bytes": Math.round(gb * quotaUnit), "period": $(prefix + "_quota_period").val(),
+        "action": $(prefix + "_quota_action").val()};
+}
+
+// fillQuota sets the inputs with the given id prefix to the quota, or empties them if it is null
+function fillQuota(prefix, quota) {
+    $(prefix + "_quota_gb").val(quota ? parseFloat((quota.bytes / quotaUnit).toFixed(3)) : "");
+    $(prefix + "_quota_period").val(quota ? quota.period : "month");
+    $(prefix + "_quota_action").val(quota ? quota.action : "disable");
+}
+
+// prettyBytes returns the number of bytes in binary units
+function prettyBytes(bytes) {
+    const units = ["B", "KB", "MB", "GB", "TB", "PB"];
+    let pow = 0;
+    while (bytes >= 1024 && pow < units.length - 1) {
+        bytes /= 1024;
+        pow++;
+    }
+    return parseFloat(bytes.toFixed(2)) + " " + units[pow];
+}
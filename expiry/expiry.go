@@ -0,0 +1,106 @@
+package expiry
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/labstack/gommon/log"
+
+	"github.com/ngoduykhanh/wireguard-ui/apply"
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// auditActor is recorded in the audit log for clients disabled by the Scheduler
+const auditActor = "expiry"
+
+// Scheduler disables clients in the background once their expiry date has passed
+type Scheduler struct {
+	db       store.IStore
+	interval time.Duration
+	// onExpire is called after clients were disabled, to get the change into the running interfaces
+	onExpire func()
+}
+
+// NewScheduler returns a Scheduler which checks for expired clients every interval and calls onExpire, if not nil,
+// after it disabled any. It has to be started with Start.
+func NewScheduler(db store.IStore, interval time.Duration, onExpire func()) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		interval: interval,
+		onExpire: onExpire,
+	}
+}
+
+// Start to run the worker, clients which expired while wireguard-ui was not running are disabled right away
+func (s *Scheduler) Start() {
+	go s.run()
+}
+
+func (s *Scheduler) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.check()
+		<-ticker.C
+	}
+}
+
+func (s *Scheduler) check() {
+	clients, err := DisableExpired(s.db, time.Now())
+	if err != nil {
+		log.Error("Cannot disable expired clients: ", err)
+	}
+	if len(clients) == 0 {
+		return
+	}
+	for _, client := range clients {
+		log.Infof("Disabled expired client %s (%s)", client.Name, client.ID)
+	}
+	if s.onExpire != nil {
+		s.onExpire()
+	}
+}
+
+// DisableExpired disables the enabled clients which expired at now and returns them
+func DisableExpired(db store.IStore, now time.Time) ([]model.Client, error) {
+	var disabled []model.Client
+	err := db.Update(func(tx store.IStore) error {
+		clients, err := tx.GetClients(false)
+		if err != nil {
+			return err
+		}
+		for _, clientData := range clients {
+			client := *clientData.Client
+			if !client.Enabled || !client.Expired(now) {
+				continue
+			}
+			before := client
+
+			client.Enabled = false
+			client.UpdatedAt = now.UTC()
+			client.Revision++
+			if err := tx.SaveClient(client); err != nil {
+				return err
+			}
+			target := fmt.Sprintf("%s (%s)", client.Name, client.ID)
+			if err := tx.SaveAuditLog(util.NewAuditLog(auditActor, "client.expire", target, before, client)); err != nil {
+				return err
+			}
+			disabled = append(disabled, client)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return disabled, nil
+}
+
+// ApplyFunc returns an onExpire function which applies the configuration right away, for when it is not applied
+// automatically after changes anyway
+func ApplyFunc(db store.IStore, tmplDir fs.FS) func() {
+	return apply.Background(db, tmplDir, auditActor)
+}
@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"crypto/subtle"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+
+	"github.com/ngoduykhanh/wireguard-ui/apply"
 )
 
 // ContentTypeJson checks that the requests have the Content-Type header set to "application/json".
@@ -18,3 +21,32 @@ func ContentTypeJson(next echo.HandlerFunc) echo.HandlerFunc {
 		return next(c)
 	}
 }
+
+// AutoApply returns a middleware which triggers the AutoApplier after every successful request which may have
+// changed the configuration. The AutoApplier skips runs without changes, so requests changing nothing are harmless.
+func AutoApply(applier *apply.AutoApplier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			if c.Request().Method != http.MethodGet && err == nil && c.Response().Status < http.StatusBadRequest {
+				applier.Trigger()
+			}
+			return err
+		}
+	}
+}
+
+// BearerToken returns a middleware which requires the requests to have the token in the Authorization header, for
+// endpoints scraped by other services without a session. An empty token lets no request pass.
+func BearerToken(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			expected := []byte("Bearer " + token)
+			if token == "" || subtle.ConstantTimeCompare([]byte(c.Request().Header.Get("Authorization")), expected) != 1 {
+				c.Response().Header().Set("WWW-Authenticate", "Bearer")
+				return c.JSON(http.StatusUnauthorized, jsonHTTPResponse{false, "Invalid or missing bearer token"})
+			}
+			return next(c)
+		}
+	}
+}
@@ -1,6 +1,78 @@
 package handler
 
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/ngoduykhanh/wireguard-ui/apply"
+)
+
 type jsonHTTPResponse struct {
 	Status  bool   `json:"status"`
 	Message string `json:"message"`
 }
+
+// jsonConflictResponse is sent with HTTP 409 when the record was modified since the caller read it, and with HTTP 428
+// when the caller did not send the revision it read
+type jsonConflictResponse struct {
+	Status  bool        `json:"status"`
+	Message string      `json:"message"`
+	Current interface{} `json:"current"`
+}
+
+// jsonApplyResponse is sent by ApplyServerConfig, with the hook output in Result also when applying failed
+type jsonApplyResponse struct {
+	Status  bool         `json:"status"`
+	Message string       `json:"message"`
+	Result  apply.Result `json:"result"`
+}
+
+// httpError aborts a store transaction with the status code and message to respond with
+type httpError struct {
+	Code    int
+	Message string
+	// Current is the stored record, sent back when the revision sent by the caller does not match
+	Current interface{}
+}
+
+func (e *httpError) Error() string {
+	return e.Message
+}
+
+// txErrorResponse to respond with the error returned by store.IStore.Update
+func txErrorResponse(c echo.Context, err error) error {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		if httpErr.Current != nil {
+			return c.JSON(httpErr.Code, jsonConflictResponse{false, httpErr.Message, httpErr.Current})
+		}
+		return c.JSON(httpErr.Code, jsonHTTPResponse{false, httpErr.Message})
+	}
+	log.Error("Cannot update database: ", err)
+	return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
+}
+
+// checkRevision to abort a store transaction with the current record if the revision sent by the caller is not the
+// stored one: HTTP 428 if the caller sent none (0), HTTP 409 if the record was changed since the caller read it.
+// Stored revisions start at 1, so a record is never updated without the caller having read it.
+func checkRevision(kind string, sent, stored int64, current interface{}) error {
+	if sent == 0 {
+		return &httpError{
+			Code:    http.StatusPreconditionRequired,
+			Message: fmt.Sprintf("The revision of the %s is missing. Please reload and try again", kind),
+			Current: current,
+		}
+	}
+	if sent != stored {
+		return &httpError{
+			Code:    http.StatusConflict,
+			Message: fmt.Sprintf("Someone else has changed this %s in the meantime. Please reload and try again", kind),
+			Current: current,
+		}
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"io/fs"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -23,7 +24,9 @@ import (
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
+	"github.com/ngoduykhanh/wireguard-ui/apply"
 	"github.com/ngoduykhanh/wireguard-ui/emailer"
+	"github.com/ngoduykhanh/wireguard-ui/ipam"
 	"github.com/ngoduykhanh/wireguard-ui/model"
 	"github.com/ngoduykhanh/wireguard-ui/store"
 	"github.com/ngoduykhanh/wireguard-ui/telegram"
@@ -213,6 +216,7 @@ func UpdateUser(db store.IStore) echo.HandlerFunc {
 		password := data["password"].(string)
 		previousUsername := data["previous_username"].(string)
 		admin := data["admin"].(bool)
+		revision, _ := data["revision"].(float64)
 
 		if !isAdmin(c) && (previousUsername != currentUser(c)) {
 			return c.JSON(http.StatusForbidden, jsonHTTPResponse{false, "Manager cannot access other user data"})
@@ -226,41 +230,56 @@ func UpdateUser(db store.IStore) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid username"})
 		}
 
-		user, err := db.GetUserByName(previousUsername)
-		if err != nil {
-			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, err.Error()})
-		}
-
 		if username == "" || !usernameRegexp.MatchString(username) {
 			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid username"})
-		} else {
-			user.Username = username
-		}
-
-		if username != previousUsername {
-			_, err := db.GetUserByName(username)
-			if err == nil {
-				return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "This username is taken"})
-			}
 		}
 
+		var passwordHash string
 		if password != "" {
 			hash, err := util.HashPassword(password)
 			if err != nil {
 				return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
 			}
-			user.PasswordHash = hash
+			passwordHash = hash
 		}
 
-		if previousUsername != currentUser(c) {
-			user.Admin = admin
-		}
+		var user model.User
+		err = db.Update(func(tx store.IStore) error {
+			user, err = tx.GetUserByName(previousUsername)
+			if err != nil {
+				return &httpError{Code: http.StatusNotFound, Message: err.Error()}
+			}
+			if err := checkRevision("user", int64(revision), user.Revision, user); err != nil {
+				return err
+			}
+			before := user
 
-		if err := db.DeleteUser(previousUsername); err != nil {
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
-		}
-		if err := db.SaveUser(user); err != nil {
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
+			if username != previousUsername {
+				_, err := tx.GetUserByName(username)
+				if err == nil {
+					return &httpError{Code: http.StatusBadRequest, Message: "This username is taken"}
+				}
+			}
+
+			user.Username = username
+			if passwordHash != "" {
+				user.PasswordHash = passwordHash
+			}
+			if previousUsername != currentUser(c) {
+				user.Admin = admin
+			}
+			user.Revision++
+
+			if err := tx.DeleteUser(previousUsername); err != nil {
+				return err
+			}
+			if err := tx.SaveUser(user); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "user.update", user.Username, before, user)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
 		}
 		log.Infof("Updated user information successfully")
 
@@ -293,13 +312,6 @@ func CreateUser(db store.IStore) echo.HandlerFunc {
 			user.Username = username
 		}
 
-		{
-			_, err := db.GetUserByName(username)
-			if err == nil {
-				return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "This username is taken"})
-			}
-		}
-
 		hash, err := util.HashPassword(password)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
@@ -307,9 +319,20 @@ func CreateUser(db store.IStore) echo.HandlerFunc {
 		user.PasswordHash = hash
 
 		user.Admin = admin
+		user.Revision = 1
 
-		if err := db.SaveUser(user); err != nil {
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
+		err = db.Update(func(tx store.IStore) error {
+			// checked within the transaction, so a concurrent create cannot overwrite the user
+			if _, err := tx.GetUserByName(username); err == nil {
+				return &httpError{Code: http.StatusBadRequest, Message: "This username is taken"}
+			}
+			if err := tx.SaveUser(user); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "user.create", user.Username, nil, user)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
 		}
 		log.Infof("Created user successfully")
 
@@ -337,8 +360,17 @@ func RemoveUser(db store.IStore) echo.HandlerFunc {
 			return c.JSON(http.StatusForbidden, jsonHTTPResponse{false, "User cannot delete itself"})
 		}
 		// delete user from database
-
-		if err := db.DeleteUser(username); err != nil {
+		err = db.Update(func(tx store.IStore) error {
+			user, err := tx.GetUserByName(username)
+			if err != nil {
+				return err
+			}
+			if err := tx.DeleteUser(username); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "user.delete", username, user, nil)
+		})
+		if err != nil {
 			log.Error("Cannot delete user: ", err)
 			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot delete user from database"})
 		}
@@ -376,8 +408,19 @@ func GetClients(db store.IStore) echo.HandlerFunc {
 			})
 		}
 
+		// the usage is shown along with the quota, the clients are listed without it if it cannot be read
+		usages := make(map[string]model.TrafficUsage)
+		if usageList, err := db.GetTrafficUsages(); err == nil {
+			for _, usage := range usageList {
+				usages[usage.ClientID] = usage
+			}
+		}
+
 		for i, clientData := range clientDataList {
 			clientDataList[i] = util.FillClientSubnetRange(clientData)
+			if usage, found := usages[clientData.Client.ID]; found {
+				clientDataList[i].Usage = &usage
+			}
 		}
 
 		return c.JSON(http.StatusOK, clientDataList)
@@ -422,20 +465,6 @@ func NewClient(db store.IStore) echo.HandlerFunc {
 			}
 		}
 
-		// read server information
-		server, err := db.GetServer()
-		if err != nil {
-			log.Error("Cannot fetch server from database: ", err)
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
-		}
-
-		// validate the input Allocation IPs
-		allocatedIPs, err := util.GetAllocatedIPs("")
-		check, err := util.ValidateIPAllocation(server.Interface.Addresses, allocatedIPs, client.AllocatedIPs)
-		if !check {
-			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, fmt.Sprintf("%s", err)})
-		}
-
 		// validate the input AllowedIPs
 		if util.ValidateAllowedIPs(client.AllowedIPs) == false {
 			log.Warnf("Invalid Allowed IPs input from user: %v", client.AllowedIPs)
@@ -448,11 +477,21 @@ func NewClient(db store.IStore) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Extra AllowedIPs must be in CIDR format"})
 		}
 
+		if util.ValidateQuota(client.Quota) == false {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Quota must be a positive number of bytes per day, week or month"})
+		}
+
+		// an enabled client which expired already would be disabled right away
+		if client.Enabled && client.Expired(time.Now()) {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Expiry date must be in the future"})
+		}
+
 		// gen ID
 		guid := xid.New()
 		client.ID = guid.String()
 
 		// gen Wireguard key pair
+		checkDuplicatePublicKey := false
 		if client.PublicKey == "" {
 			key, err := wgtypes.GeneratePrivateKey()
 			if err != nil {
@@ -467,18 +506,7 @@ func NewClient(db store.IStore) echo.HandlerFunc {
 				log.Error("Cannot verify wireguard public key: ", err)
 				return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot verify Wireguard public key"})
 			}
-			// check for duplicates
-			clients, err := db.GetClients(false)
-			if err != nil {
-				log.Error("Cannot get clients for duplicate check")
-				return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get clients for duplicate check"})
-			}
-			for _, other := range clients {
-				if other.Client.PublicKey == client.PublicKey {
-					log.Error("Duplicate Public Key")
-					return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Duplicate Public Key"})
-				}
-			}
+			checkDuplicatePublicKey = true
 		}
 
 		if client.PresharedKey == "" {
@@ -502,12 +530,46 @@ func NewClient(db store.IStore) echo.HandlerFunc {
 		}
 		client.CreatedAt = time.Now().UTC()
 		client.UpdatedAt = client.CreatedAt
+		client.Revision = 1
 
-		// write client to the database
-		if err := db.SaveClient(client); err != nil {
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{
-				false, err.Error(),
-			})
+		// validate against the existing clients and write within one transaction, so concurrent requests cannot
+		// allocate the same ip addresses or public key
+		err := db.Update(func(tx store.IStore) error {
+			// the client becomes a peer of the first interface unless one is given
+			server, err := tx.GetServer(client.Interface)
+			if err != nil {
+				return &httpError{Code: http.StatusBadRequest, Message: "Unknown interface " + client.Interface}
+			}
+			client.Interface = server.Interface.Name
+
+			// validate the input Allocation IPs
+			if err := ipam.New(tx).Validate(client.Interface, "", client.AllocatedIPs); err != nil {
+				return &httpError{Code: http.StatusBadRequest, Message: err.Error()}
+			}
+
+			// check for duplicates
+			if checkDuplicatePublicKey {
+				clients, err := tx.GetClients(false)
+				if err != nil {
+					log.Error("Cannot get clients for duplicate check")
+					return &httpError{Code: http.StatusInternalServerError, Message: "Cannot get clients for duplicate check"}
+				}
+				for _, other := range clients {
+					if other.Client.PublicKey == client.PublicKey {
+						log.Error("Duplicate Public Key")
+						return &httpError{Code: http.StatusInternalServerError, Message: "Duplicate Public Key"}
+					}
+				}
+			}
+
+			// write client to the database
+			if err := tx.SaveClient(client); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "client.create", clientAuditTarget(client), nil, client)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
 		}
 		log.Infof("Created wireguard client: %v", client)
 
@@ -543,11 +605,11 @@ func EmailClient(db store.IStore, mailer emailer.Emailer, emailSubject, emailCon
 		}
 
 		// build config
-		server, _ := db.GetServer()
+		server, _ := db.GetServer(clientData.Client.Interface)
 		globalSettings, _ := db.GetGlobalSettings()
 		config := util.BuildClientConfig(*clientData.Client, server, globalSettings)
 
-		cfgAtt := emailer.Attachment{Name: "wg0.conf", Data: []byte(config)}
+		cfgAtt := emailer.Attachment{Name: clientData.Client.Interface + ".conf", Data: []byte(config)}
 		var attachments []emailer.Attachment
 		if clientData.Client.PrivateKey != "" {
 			qrdata, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(clientData.QRCode, "data:image/png;base64,"))
@@ -592,7 +654,7 @@ func SendTelegramClient(db store.IStore) echo.HandlerFunc {
 		}
 
 		// build config
-		server, _ := db.GetServer()
+		server, _ := db.GetServer(clientData.Client.Interface)
 		globalSettings, _ := db.GetGlobalSettings()
 		config := util.BuildClientConfig(*clientData.Client, server, globalSettings)
 		configData := []byte(config)
@@ -630,12 +692,6 @@ func UpdateClient(db store.IStore) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid client ID"})
 		}
 
-		// validate client existence
-		clientData, err := db.GetClientByID(_client.ID, model.QRCodeSettings{Enabled: false})
-		if err != nil {
-			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, "Client not found"})
-		}
-
 		// Validate Telegram userid if provided
 		if _client.TgUserid != "" {
 			idNum, err := strconv.ParseInt(_client.TgUserid, 10, 64)
@@ -644,20 +700,6 @@ func UpdateClient(db store.IStore) echo.HandlerFunc {
 			}
 		}
 
-		server, err := db.GetServer()
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{
-				false, fmt.Sprintf("Cannot fetch server config: %s", err),
-			})
-		}
-		client := *clientData.Client
-		// validate the input Allocation IPs
-		allocatedIPs, err := util.GetAllocatedIPs(client.ID)
-		check, err := util.ValidateIPAllocation(server.Interface.Addresses, allocatedIPs, _client.AllocatedIPs)
-		if !check {
-			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, fmt.Sprintf("%s", err)})
-		}
-
 		// validate the input AllowedIPs
 		if util.ValidateAllowedIPs(_client.AllowedIPs) == false {
 			log.Warnf("Invalid Allowed IPs input from user: %v", _client.AllowedIPs)
@@ -669,62 +711,107 @@ func UpdateClient(db store.IStore) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Extra Allowed IPs must be in CIDR format"})
 		}
 
-		// update Wireguard Client PublicKey
-		if client.PublicKey != _client.PublicKey && _client.PublicKey != "" {
-			_, err := wgtypes.ParseKey(_client.PublicKey)
+		if util.ValidateQuota(_client.Quota) == false {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Quota must be a positive number of bytes per day, week or month"})
+		}
+
+		// an enabled client which expired already would be disabled right away
+		if _client.Enabled && _client.Expired(time.Now()) {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Expiry date must be in the future"})
+		}
+
+		// read, validate and write the client within one transaction, so concurrent requests cannot allocate the
+		// same ip addresses or overwrite each other's changes
+		var client model.Client
+		err := db.Update(func(tx store.IStore) error {
+			// validate client existence
+			clientData, err := tx.GetClientByID(_client.ID, model.QRCodeSettings{Enabled: false})
 			if err != nil {
-				log.Error("Cannot verify provided Wireguard public key: ", err)
-				return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot verify provided Wireguard public key"})
+				return &httpError{Code: http.StatusNotFound, Message: "Client not found"}
 			}
-			// check for duplicates
-			clients, err := db.GetClients(false)
-			if err != nil {
-				log.Error("Cannot get client list for duplicate public key check")
-				return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get client list for duplicate public key check"})
+			client = *clientData.Client
+			if err := checkRevision("client", _client.Revision, client.Revision, client); err != nil {
+				return err
 			}
-			for _, other := range clients {
-				if other.Client.PublicKey == _client.PublicKey {
-					log.Error("Duplicate Public Key")
-					return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Duplicate Public Key"})
+			before := client
+
+			// the client moves to another interface if one is given
+			if _client.Interface != "" && _client.Interface != client.Interface {
+				if _, err := tx.GetServer(_client.Interface); err != nil {
+					return &httpError{Code: http.StatusBadRequest, Message: "Unknown interface " + _client.Interface}
 				}
+				client.Interface = _client.Interface
 			}
 
-			// When replacing any PublicKey, discard any locally stored Wireguard Client PrivateKey
-			// Client PubKey no longer corresponds to locally stored PrivKey.
-			// QR code (needs PrivateKey) for this client is no longer possible now.
+			// validate the input Allocation IPs
+			if err := ipam.New(tx).Validate(client.Interface, client.ID, _client.AllocatedIPs); err != nil {
+				return &httpError{Code: http.StatusBadRequest, Message: err.Error()}
+			}
 
-			if client.PrivateKey != "" {
-				client.PrivateKey = ""
+			// update Wireguard Client PublicKey
+			if client.PublicKey != _client.PublicKey && _client.PublicKey != "" {
+				_, err := wgtypes.ParseKey(_client.PublicKey)
+				if err != nil {
+					log.Error("Cannot verify provided Wireguard public key: ", err)
+					return &httpError{Code: http.StatusInternalServerError, Message: "Cannot verify provided Wireguard public key"}
+				}
+				// check for duplicates
+				clients, err := tx.GetClients(false)
+				if err != nil {
+					log.Error("Cannot get client list for duplicate public key check")
+					return &httpError{Code: http.StatusInternalServerError, Message: "Cannot get client list for duplicate public key check"}
+				}
+				for _, other := range clients {
+					if other.Client.PublicKey == _client.PublicKey {
+						log.Error("Duplicate Public Key")
+						return &httpError{Code: http.StatusInternalServerError, Message: "Duplicate Public Key"}
+					}
+				}
+
+				// When replacing any PublicKey, discard any locally stored Wireguard Client PrivateKey
+				// Client PubKey no longer corresponds to locally stored PrivKey.
+				// QR code (needs PrivateKey) for this client is no longer possible now.
+
+				if client.PrivateKey != "" {
+					client.PrivateKey = ""
+				}
 			}
-		}
 
-		// update Wireguard Client PresharedKey
-		if client.PresharedKey != _client.PresharedKey && _client.PresharedKey != "" {
-			_, err := wgtypes.ParseKey(_client.PresharedKey)
-			if err != nil {
-				log.Error("Cannot verify provided Wireguard preshared key: ", err)
-				return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot verify provided Wireguard preshared key"})
-			}
-		}
-
-		// map new data
-		client.Name = _client.Name
-		client.Email = _client.Email
-		client.TgUserid = _client.TgUserid
-		client.Enabled = _client.Enabled
-		client.UseServerDNS = _client.UseServerDNS
-		client.AllocatedIPs = _client.AllocatedIPs
-		client.AllowedIPs = _client.AllowedIPs
-		client.ExtraAllowedIPs = _client.ExtraAllowedIPs
-		client.Endpoint = _client.Endpoint
-		client.PublicKey = _client.PublicKey
-		client.PresharedKey = _client.PresharedKey
-		client.UpdatedAt = time.Now().UTC()
-		client.AdditionalNotes = strings.ReplaceAll(strings.Trim(_client.AdditionalNotes, "\r\n"), "\r\n", "\n")
-
-		// write to the database
-		if err := db.SaveClient(client); err != nil {
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
+			// update Wireguard Client PresharedKey
+			if client.PresharedKey != _client.PresharedKey && _client.PresharedKey != "" {
+				_, err := wgtypes.ParseKey(_client.PresharedKey)
+				if err != nil {
+					log.Error("Cannot verify provided Wireguard preshared key: ", err)
+					return &httpError{Code: http.StatusInternalServerError, Message: "Cannot verify provided Wireguard preshared key"}
+				}
+			}
+
+			// map new data
+			client.Name = _client.Name
+			client.Email = _client.Email
+			client.TgUserid = _client.TgUserid
+			client.Enabled = _client.Enabled
+			client.UseServerDNS = _client.UseServerDNS
+			client.AllocatedIPs = _client.AllocatedIPs
+			client.AllowedIPs = _client.AllowedIPs
+			client.ExtraAllowedIPs = _client.ExtraAllowedIPs
+			client.Endpoint = _client.Endpoint
+			client.PublicKey = _client.PublicKey
+			client.PresharedKey = _client.PresharedKey
+			client.ExpiresAt = _client.ExpiresAt
+			client.Quota = _client.Quota
+			client.UpdatedAt = time.Now().UTC()
+			client.Revision++
+			client.AdditionalNotes = strings.ReplaceAll(strings.Trim(_client.AdditionalNotes, "\r\n"), "\r\n", "\n")
+
+			// write to the database
+			if err := tx.SaveClient(client); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "client.update", clientAuditTarget(client), before, client)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
 		}
 		log.Infof("Updated client information successfully => %v", client)
 
@@ -744,23 +831,45 @@ func SetClientStatus(db store.IStore) echo.HandlerFunc {
 
 		clientID := data["id"].(string)
 		status := data["status"].(bool)
+		// the revision is a JSON number, missing if the caller did not send it
+		revision, _ := data["revision"].(float64)
 
 		if _, err := xid.FromString(clientID); err != nil {
 			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid client ID"})
 		}
 
-		clientData, err := db.GetClientByID(clientID, model.QRCodeSettings{Enabled: false})
-		if err != nil {
-			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, err.Error()})
-		}
+		err = db.Update(func(tx store.IStore) error {
+			clientData, err := tx.GetClientByID(clientID, model.QRCodeSettings{Enabled: false})
+			if err != nil {
+				return &httpError{Code: http.StatusNotFound, Message: err.Error()}
+			}
 
-		client := *clientData.Client
+			client := *clientData.Client
+			if err := checkRevision("client", int64(revision), client.Revision, client); err != nil {
+				return err
+			}
+			before := client
 
-		client.Enabled = status
-		if err := db.SaveClient(client); err != nil {
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
+			if status && client.Expired(time.Now()) {
+				return &httpError{Code: http.StatusBadRequest, Message: "Client has expired, change its expiry date to enable it"}
+			}
+
+			client.Enabled = status
+			client.UpdatedAt = time.Now().UTC()
+			client.Revision++
+			if err := tx.SaveClient(client); err != nil {
+				return err
+			}
+			action := "client.disable"
+			if status {
+				action = "client.enable"
+			}
+			return auditLog(c, tx, action, clientAuditTarget(client), before, client)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
 		}
-		log.Infof("Changed client %s enabled status to %v", client.ID, status)
+		log.Infof("Changed client %s enabled status to %v", clientID, status)
 
 		return c.JSON(http.StatusOK, jsonHTTPResponse{true, "Changed client status successfully"})
 	}
@@ -785,7 +894,7 @@ func DownloadClient(db store.IStore) echo.HandlerFunc {
 		}
 
 		// build config
-		server, err := db.GetServer()
+		server, err := db.GetServer(clientData.Client.Interface)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
 		}
@@ -815,8 +924,17 @@ func RemoveClient(db store.IStore) echo.HandlerFunc {
 		}
 
 		// delete client from database
-
-		if err := db.DeleteClient(client.ID); err != nil {
+		err := db.Update(func(tx store.IStore) error {
+			clientData, err := tx.GetClientByID(client.ID, model.QRCodeSettings{Enabled: false})
+			if err != nil {
+				return err
+			}
+			if err := tx.DeleteClient(client.ID); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "client.delete", clientAuditTarget(*clientData.Client), *clientData.Client, nil)
+		})
+		if err != nil {
 			log.Error("Cannot delete wireguard client: ", err)
 			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot delete client from database"})
 		}
@@ -826,50 +944,259 @@ func RemoveClient(db store.IStore) echo.HandlerFunc {
 	}
 }
 
-// WireGuardServer handler
+// WireGuardServer handler to show the interface chosen with the interface query parameter, the first by default
 func WireGuardServer(db store.IStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		server, err := db.GetServer()
+		servers, err := db.GetServers()
 		if err != nil {
 			log.Error("Cannot get server config: ", err)
 		}
+		var server model.Server
+		for _, candidate := range servers {
+			if server.Interface == nil || candidate.Interface.Name == c.QueryParam("interface") {
+				server = candidate
+			}
+		}
 
 		return c.Render(http.StatusOK, "server.html", map[string]interface{}{
 			"baseData":        model.BaseData{Active: "wg-server", CurrentUser: currentUser(c), Admin: isAdmin(c)},
+			"servers":         servers,
 			"serverInterface": server.Interface,
 			"serverKeyPair":   server.KeyPair,
 		})
 	}
 }
 
-// WireGuardServerInterfaces handler
-func WireGuardServerInterfaces(db store.IStore) echo.HandlerFunc {
+// interfaceInfo describes a server interface to every user, without its private key
+type interfaceInfo struct {
+	Name            string   `json:"name"`
+	Addresses       []string `json:"addresses"`
+	ListenPort      int      `json:"listen_port"`
+	ConfigFilePath  string   `json:"config_file_path"`
+	EndpointAddress string   `json:"endpoint_address"`
+	PublicKey       string   `json:"public_key"`
+}
+
+// GetInterfaces handler to list the server interfaces, e.g. to choose the interface of a client
+func GetInterfaces(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		servers, err := db.GetServers()
+		if err != nil {
+			log.Error("Cannot get server config: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get server interfaces"})
+		}
+
+		interfaces := make([]interfaceInfo, 0, len(servers))
+		for _, server := range servers {
+			interfaces = append(interfaces, interfaceInfo{
+				Name:            server.Interface.Name,
+				Addresses:       server.Interface.Addresses,
+				ListenPort:      server.Interface.ListenPort,
+				ConfigFilePath:  server.Interface.ConfigFilePath,
+				EndpointAddress: server.Interface.EndpointAddress,
+				PublicKey:       server.KeyPair.PublicKey,
+			})
+		}
+		return c.JSON(http.StatusOK, interfaces)
+	}
+}
+
+// validateServerInterface to check the settings of an interface, and that its name, listen port and config file are
+// not used by another of the servers. exclude is the current name of the interface, empty for a new one.
+func validateServerInterface(serverInterface model.ServerInterface, servers []model.Server, exclude string) error {
+	if !util.ValidateInterfaceName(serverInterface.Name) {
+		return &httpError{Code: http.StatusBadRequest, Message: "Interface name must be 1 to 15 letters, digits or _=+.-"}
+	}
+	if util.ValidateServerAddresses(serverInterface.Addresses) == false {
+		log.Warnf("Invalid server interface addresses input from user: %v", serverInterface.Addresses)
+		return &httpError{Code: http.StatusBadRequest, Message: "Interface IP address must be in CIDR format"}
+	}
+	if serverInterface.ListenPort < 1 || serverInterface.ListenPort > 65535 {
+		return &httpError{Code: http.StatusBadRequest, Message: "Listen port must be between 1 and 65535"}
+	}
+	if !filepath.IsAbs(serverInterface.ConfigFilePath) {
+		return &httpError{Code: http.StatusBadRequest, Message: "Config file path must be an absolute path"}
+	}
+
+	for _, server := range servers {
+		if server.Interface.Name == exclude {
+			continue
+		}
+		switch {
+		case server.Interface.Name == serverInterface.Name:
+			return &httpError{Code: http.StatusBadRequest, Message: "Interface " + serverInterface.Name + " exists already"}
+		case server.Interface.ListenPort == serverInterface.ListenPort:
+			return &httpError{Code: http.StatusBadRequest, Message: "Listen port is used by interface " + server.Interface.Name}
+		case filepath.Clean(server.Interface.ConfigFilePath) == filepath.Clean(serverInterface.ConfigFilePath):
+			return &httpError{Code: http.StatusBadRequest, Message: "Config file is used by interface " + server.Interface.Name}
+		}
+	}
+	return nil
+}
+
+// NewServerInterface handler to create an interface with a new key pair
+func NewServerInterface(db store.IStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var serverInterface model.ServerInterface
 		c.Bind(&serverInterface)
 
-		// validate the input addresses
-		if util.ValidateServerAddresses(serverInterface.Addresses) == false {
-			log.Warnf("Invalid server interface addresses input from user: %v", serverInterface.Addresses)
-			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Interface IP address must be in CIDR format"})
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			log.Error("Cannot generate wireguard key pair: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot generate Wireguard key pair"})
+		}
+		serverKeyPair := model.ServerKeypair{
+			PrivateKey: key.String(),
+			PublicKey:  key.PublicKey().String(),
+			UpdatedAt:  time.Now().UTC(),
 		}
+		serverInterface.UpdatedAt = serverKeyPair.UpdatedAt
+
+		err = db.Update(func(tx store.IStore) error {
+			servers, err := tx.GetServers()
+			if err != nil {
+				return err
+			}
+			if err := validateServerInterface(serverInterface, servers, ""); err != nil {
+				return err
+			}
+			if err := tx.SaveServerInterface(serverInterface); err != nil {
+				return err
+			}
+			if err := tx.SaveServerKeyPair(serverInterface.Name, serverKeyPair); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "server.interface.create", serverInterface.Name, nil, serverInterface)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
+		}
+		log.Infof("Created wireguard server interface: %v", serverInterface)
+
+		return c.JSON(http.StatusOK, jsonHTTPResponse{true, "Created interface " + serverInterface.Name})
+	}
+}
+
+// WireGuardServerInterfaces handler to update an interface. A new name renames it, its clients are moved along.
+func WireGuardServerInterfaces(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var serverInterface model.ServerInterface
+		c.Bind(&serverInterface)
 
+		name := c.Param("name")
+		if serverInterface.Name == "" {
+			serverInterface.Name = name
+		}
 		serverInterface.UpdatedAt = time.Now().UTC()
 
 		// write config to the database
-
-		if err := db.SaveServerInterface(serverInterface); err != nil {
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Interface IP address must be in CIDR format"})
+		err := db.Update(func(tx store.IStore) error {
+			server, err := tx.GetServer(name)
+			if err != nil || name == "" {
+				return &httpError{Code: http.StatusNotFound, Message: "Interface not found"}
+			}
+			servers, err := tx.GetServers()
+			if err != nil {
+				return err
+			}
+			if err := validateServerInterface(serverInterface, servers, name); err != nil {
+				return err
+			}
+			if err := tx.SaveServerInterface(serverInterface); err != nil {
+				return err
+			}
+			if serverInterface.Name != name {
+				if err := renameServerInterface(tx, server, serverInterface.Name); err != nil {
+					return err
+				}
+			}
+			return auditLog(c, tx, "server.interface.update", name, *server.Interface, serverInterface)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
 		}
 		log.Infof("Updated wireguard server interfaces settings: %v", serverInterface)
 
-		return c.JSON(http.StatusOK, jsonHTTPResponse{true, "Updated interface addresses successfully"})
+		return c.JSON(http.StatusOK, jsonHTTPResponse{true, "Updated interface successfully"})
 	}
 }
 
-// WireGuardServerKeyPair handler to generate private and public keys
+// renameServerInterface to move the key pair and the clients of server to the interface saved as name, and remove
+// the interface under its old name
+func renameServerInterface(tx store.IStore, server model.Server, name string) error {
+	if err := tx.SaveServerKeyPair(name, *server.KeyPair); err != nil {
+		return err
+	}
+	clients, err := tx.GetClients(false)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, clientData := range clients {
+		client := *clientData.Client
+		if client.Interface != server.Interface.Name {
+			continue
+		}
+		client.Interface = name
+		client.UpdatedAt = now
+		client.Revision++
+		if err := tx.SaveClient(client); err != nil {
+			return err
+		}
+	}
+	return tx.DeleteServer(server.Interface.Name)
+}
+
+// RemoveServerInterface handler to remove an interface which has no clients
+func RemoveServerInterface(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+
+		err := db.Update(func(tx store.IStore) error {
+			servers, err := tx.GetServers()
+			if err != nil {
+				return err
+			}
+			var server *model.Server
+			for i := range servers {
+				if servers[i].Interface.Name == name {
+					server = &servers[i]
+				}
+			}
+			if server == nil {
+				return &httpError{Code: http.StatusNotFound, Message: "Interface not found"}
+			}
+			if len(servers) == 1 {
+				return &httpError{Code: http.StatusBadRequest, Message: "Cannot remove the only interface"}
+			}
+			clients, err := tx.GetClients(false)
+			if err != nil {
+				return err
+			}
+			for _, clientData := range clients {
+				if clientData.Client.Interface == name {
+					return &httpError{Code: http.StatusBadRequest, Message: "Interface " + name + " has clients, please move or remove them first"}
+				}
+			}
+			if err := tx.DeleteServer(name); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "server.interface.delete", name, *server.Interface, nil)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
+		}
+		log.Infof("Removed wireguard server interface %s", name)
+
+		return c.JSON(http.StatusOK, jsonHTTPResponse{true, "Removed interface " + name})
+	}
+}
+
+// WireGuardServerKeyPair handler to generate private and public keys of an interface
 func WireGuardServerKeyPair(db store.IStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		name := c.Param("name")
+
 		// gen Wireguard key pair
 		key, err := wgtypes.GeneratePrivateKey()
 		if err != nil {
@@ -882,10 +1209,20 @@ func WireGuardServerKeyPair(db store.IStore) echo.HandlerFunc {
 		serverKeyPair.PublicKey = key.PublicKey().String()
 		serverKeyPair.UpdatedAt = time.Now().UTC()
 
-		if err := db.SaveServerKeyPair(serverKeyPair); err != nil {
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot generate Wireguard key pair"})
+		err = db.Update(func(tx store.IStore) error {
+			server, err := tx.GetServer(name)
+			if err != nil || name == "" {
+				return &httpError{Code: http.StatusNotFound, Message: "Interface not found"}
+			}
+			if err := tx.SaveServerKeyPair(name, serverKeyPair); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "server.keypair.generate", name, *server.KeyPair, serverKeyPair)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
 		}
-		log.Infof("Updated wireguard server interfaces settings: %v", serverKeyPair)
+		log.Infof("Generated a new key pair for wireguard server interface %s", name)
 
 		return c.JSON(http.StatusOK, serverKeyPair)
 	}
@@ -922,8 +1259,10 @@ func Status(db store.IStore) echo.HandlerFunc {
 	}
 
 	type DeviceVM struct {
-		Name  string
-		Peers []PeerVM
+		Name string
+		// Managed is set for the interfaces of the servers, other WireGuard interfaces are shown as well
+		Managed bool
+		Peers   []PeerVM
 	}
 	return func(c echo.Context) error {
 		wgClient, err := wgctrl.New()
@@ -957,13 +1296,19 @@ func Status(db store.IStore) echo.HandlerFunc {
 			}
 			for i := range clients {
 				if clients[i].Client != nil {
-					m[clients[i].Client.PublicKey] = clients[i].Client
+					m[clients[i].Client.Interface+"/"+clients[i].Client.PublicKey] = clients[i].Client
+				}
+			}
+			managed := make(map[string]bool)
+			if servers, err := db.GetServers(); err == nil {
+				for _, server := range servers {
+					managed[server.Interface.Name] = true
 				}
 			}
 
 			conv := map[bool]int{true: 1, false: 0}
 			for i := range devices {
-				devVm := DeviceVM{Name: devices[i].Name}
+				devVm := DeviceVM{Name: devices[i].Name, Managed: managed[devices[i].Name]}
 				for j := range devices[i].Peers {
 					var allocatedIPs string
 					for _, ip := range devices[i].Peers[j].AllowedIPs {
@@ -986,7 +1331,7 @@ func Status(db store.IStore) echo.HandlerFunc {
 						pVm.Endpoint = devices[i].Peers[j].Endpoint.String()
 					}
 
-					if _client, ok := m[pVm.PublicKey]; ok {
+					if _client, ok := m[devVm.Name+"/"+pVm.PublicKey]; ok {
 						pVm.Name = _client.Name
 						pVm.Email = _client.Email
 					}
@@ -996,6 +1341,7 @@ func Status(db store.IStore) echo.HandlerFunc {
 				sort.SliceStable(devVm.Peers, func(i, j int) bool { return conv[devVm.Peers[i].Connected] > conv[devVm.Peers[j].Connected] })
 				devicesVm = append(devicesVm, devVm)
 			}
+			sort.SliceStable(devicesVm, func(i, j int) bool { return devicesVm[i].Managed && !devicesVm[j].Managed })
 		}
 
 		return c.Render(http.StatusOK, "status.html", map[string]interface{}{
@@ -1020,9 +1366,23 @@ func GlobalSettingSubmit(db store.IStore) echo.HandlerFunc {
 
 		globalSettings.UpdatedAt = time.Now().UTC()
 
-		// write config to the database
-		if err := db.SaveGlobalSettings(globalSettings); err != nil {
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot generate Wireguard key pair"})
+		// write config to the database, unless someone else changed the settings since the caller read them
+		err := db.Update(func(tx store.IStore) error {
+			current, err := tx.GetGlobalSettings()
+			if err != nil {
+				return err
+			}
+			if err := checkRevision("global settings", globalSettings.Revision, current.Revision, current); err != nil {
+				return err
+			}
+			globalSettings.Revision = current.Revision + 1
+			if err := tx.SaveGlobalSettings(globalSettings); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "settings.update", "global settings", current, globalSettings)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
 		}
 
 		log.Infof("Updated global settings: %v", globalSettings)
@@ -1061,128 +1421,100 @@ func GetOrderedSubnetRanges() echo.HandlerFunc {
 	}
 }
 
-// SuggestIPAllocation handler to get the list of ip address for client
+// SuggestIPAllocation handler to get the list of ip address for a client of the interface query parameter, the first
+// interface by default
 func SuggestIPAllocation(db store.IStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		server, err := db.GetServer()
-		if err != nil {
-			log.Error("Cannot fetch server config from database: ", err)
-			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, err.Error()})
-		}
-
-		// return the list of suggestedIPs
-		// we take the first available ip address from
-		// each server's network addresses.
-		suggestedIPs := make([]string, 0)
-		allocatedIPs, err := util.GetAllocatedIPs("")
-		if err != nil {
-			log.Error("Cannot suggest ip allocation. Failed to get list of allocated ip addresses: ", err)
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{
-				false, "Cannot suggest ip allocation: failed to get list of allocated ip addresses",
-			})
-		}
-
 		sr := c.QueryParam("sr")
 		searchCIDRList := make([]string, 0)
-		found := false
 
 		// Use subnet range or default to interface addresses
 		if util.SubnetRanges[sr] != nil {
 			for _, cidr := range util.SubnetRanges[sr] {
 				searchCIDRList = append(searchCIDRList, cidr.String())
 			}
-		} else {
-			searchCIDRList = append(searchCIDRList, server.Interface.Addresses...)
 		}
 
-		// Save only unique IPs
-		ipSet := make(map[string]struct{})
-
-		for _, cidr := range searchCIDRList {
-			ip, err := util.GetAvailableIP(cidr, allocatedIPs, server.Interface.Addresses)
-			if err != nil {
-				log.Error("Failed to get available ip from a CIDR: ", err)
-				continue
-			}
-			found = true
-			if strings.Contains(ip, ":") {
-				ipSet[fmt.Sprintf("%s/128", ip)] = struct{}{}
-			} else {
-				ipSet[fmt.Sprintf("%s/32", ip)] = struct{}{}
-			}
-		}
-
-		if !found {
+		// return the list of suggestedIPs
+		// we take the first available ip address from
+		// each of the searched network addresses.
+		suggestedIPs, err := ipam.New(db).Suggest(c.QueryParam("interface"), searchCIDRList)
+		if err != nil {
+			log.Error("Cannot suggest ip allocation: ", err)
 			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{
 				false,
 				"Cannot suggest ip allocation: failed to get available ip. Try a different subnet or deallocate some ips.",
 			})
 		}
 
-		for ip := range ipSet {
-			suggestedIPs = append(suggestedIPs, ip)
-		}
-
 		return c.JSON(http.StatusOK, suggestedIPs)
 	}
 }
 
-// ApplyServerConfig handler to write config file and restart Wireguard server
+// ApplyServerConfig handler to write config file and/or configure the Wireguard interface, depending on the apply mode
 func ApplyServerConfig(db store.IStore, tmplDir fs.FS) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		server, err := db.GetServer()
+		result, err := apply.Apply(db, tmplDir)
 		if err != nil {
-			log.Error("Cannot get server config: ", err)
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get server config"})
+			log.Error("Cannot apply server config: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonApplyResponse{
+				false, fmt.Sprintf("Cannot apply server config: %v", err), result,
+			})
 		}
-
-		clients, err := db.GetClients(false)
-		if err != nil {
-			log.Error("Cannot get client config: ", err)
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get client config"})
+		for _, hook := range result.FailedPostApplyHooks() {
+			log.Warnf("Post-apply hook %s: %s", hook.Error, hook.Output)
 		}
 
-		users, err := db.GetUsers()
-		if err != nil {
-			log.Error("Cannot get users config: ", err)
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get users config"})
+		if err := auditLog(c, db, "config.apply", result.Target(), nil, result); err != nil {
+			log.Error(err)
 		}
+		log.Infof("Applied server config: %s", result)
 
-		settings, err := db.GetGlobalSettings()
-		if err != nil {
-			log.Error("Cannot get global settings: ", err)
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get global settings"})
-		}
+		return c.JSON(http.StatusOK, jsonApplyResponse{true, "Applied server config successfully: " + result.String(), result})
+	}
+}
 
-		// Write config file
-		err = util.WriteWireGuardServerConfig(tmplDir, server, clients, users, settings)
+// PreviewServerConfig handler to show what applying the config would change, without applying it. The live query
+// parameter compares the peers of the running interface even if the apply mode only writes the config file.
+func PreviewServerConfig(db store.IStore, tmplDir fs.FS) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		live, _ := strconv.ParseBool(c.QueryParam("live"))
+		preview, err := apply.PreviewChanges(db, tmplDir, live)
 		if err != nil {
-			log.Error("Cannot apply server config: ", err)
+			log.Error("Cannot preview server config: ", err)
 			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{
-				false, fmt.Sprintf("Cannot apply server config: %v", err),
+				false, fmt.Sprintf("Cannot preview server config: %v", err),
 			})
 		}
 
-		err = util.UpdateHashes(db)
-		if err != nil {
-			log.Error("Cannot update hashes: ", err)
-			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{
-				false, fmt.Sprintf("Cannot update hashes: %v", err),
-			})
-		}
+		return c.JSON(http.StatusOK, preview)
+	}
+}
 
-		return c.JSON(http.StatusOK, jsonHTTPResponse{true, "Applied server config successfully"})
+// GetAutoApplyStatus handler returns the state of the automatic apply, applier is nil if it is disabled
+func GetAutoApplyStatus(applier *apply.AutoApplier) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if applier == nil {
+			return c.JSON(http.StatusOK, apply.AutoApplyStatus{})
+		}
+		return c.JSON(http.StatusOK, applier.Status())
 	}
 }
 
-// GetHashesChanges handler returns if database hashes have changed
-func GetHashesChanges(db store.IStore) echo.HandlerFunc {
+// GetConfigRevisions handler returns the config revision and the revision last applied, pending is set if there are
+// changes to apply
+func GetConfigRevisions(db store.IStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		if util.HashesChanged(db) {
-			return c.JSON(http.StatusOK, jsonHTTPResponse{true, "Hashes changed"})
-		} else {
-			return c.JSON(http.StatusOK, jsonHTTPResponse{false, "Hashes not changed"})
+		revisions, err := db.GetConfigRevisions()
+		if err != nil {
+			log.Error("Cannot get config revisions: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get config revisions"})
 		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"current": revisions.Current,
+			"applied": revisions.Applied,
+			"pending": revisions.Pending(),
+		})
 	}
 }
 
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+const (
+	defaultAuditLogLimit = 100
+	maxAuditLogLimit     = 1000
+)
+
+// AuditLogPage handler
+func AuditLogPage() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.Render(http.StatusOK, "audit_log.html", map[string]interface{}{
+			"baseData": model.BaseData{Active: "audit-log", CurrentUser: currentUser(c), Admin: isAdmin(c)},
+		})
+	}
+}
+
+// GetAuditLogs handler to list the audit log entries, newest first. The entries can be filtered with the actor,
+// action, target, since and until query parameters, since and until take a date or an RFC 3339 time.
+func GetAuditLogs(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filter := model.AuditLogFilter{
+			Actor:  c.QueryParam("actor"),
+			Action: c.QueryParam("action"),
+			Target: c.QueryParam("target"),
+			Limit:  defaultAuditLogLimit,
+		}
+
+		var err error
+		if filter.Since, err = parseAuditTime(c.QueryParam("since"), false); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Invalid since parameter"})
+		}
+		if filter.Until, err = parseAuditTime(c.QueryParam("until"), true); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Invalid until parameter"})
+		}
+		if limit := c.QueryParam("limit"); limit != "" {
+			filter.Limit, err = strconv.Atoi(limit)
+			if err != nil || filter.Limit < 1 || filter.Limit > maxAuditLogLimit {
+				return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, fmt.Sprintf("Limit must be between 1 and %d", maxAuditLogLimit)})
+			}
+		}
+
+		entries, err := db.GetAuditLogs(filter)
+		if err != nil {
+			log.Error("Cannot get audit log: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get audit log"})
+		}
+		if entries == nil {
+			entries = make([]model.AuditLog, 0)
+		}
+
+		return c.JSON(http.StatusOK, entries)
+	}
+}
+
+// parseAuditTime to parse a date or an RFC 3339 time. A date given as the end of a range includes the whole day.
+func parseAuditTime(value string, endOfRange bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, err
+	}
+	if endOfRange {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// auditActor returns the user to record in the audit log for the request
+func auditActor(c echo.Context) string {
+	if util.DisableLogin {
+		return "anonymous"
+	}
+	return currentUser(c)
+}
+
+// auditLog to record an administrative change made by the current user in the audit log. Call it within the store
+// transaction which makes the change, so the change and its audit log entry are written together.
+func auditLog(c echo.Context, tx store.IStore, action, target string, before, after interface{}) error {
+	entry := util.NewAuditLog(auditActor(c), action, target, before, after)
+	if err := tx.SaveAuditLog(entry); err != nil {
+		return fmt.Errorf("cannot save audit log: %v", err)
+	}
+	return nil
+}
+
+func clientAuditTarget(client model.Client) string {
+	return fmt.Sprintf("%s (%s)", client.Name, client.ID)
+}
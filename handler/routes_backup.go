@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/ngoduykhanh/wireguard-ui/backup"
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+)
+
+// BackupPage handler
+func BackupPage() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.Render(http.StatusOK, "backup.html", map[string]interface{}{
+			"baseData": model.BaseData{Active: "backup", CurrentUser: currentUser(c), Admin: isAdmin(c)},
+		})
+	}
+}
+
+// DownloadBackup handler to download an archive of all data in the database
+func DownloadBackup(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		archive, err := backup.Create(db)
+		if err != nil {
+			log.Error("Cannot create backup: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, fmt.Sprintf("Cannot create backup: %v", err)})
+		}
+
+		filename := fmt.Sprintf("wireguard-ui-backup-%s.json", archive.CreatedAt.Format("20060102-150405"))
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+		c.Response().WriteHeader(http.StatusOK)
+		log.Infof("Created backup with %d clients", len(archive.Clients))
+		if err := auditLog(c, db, "backup.download", "database", nil, nil); err != nil {
+			log.Error(err)
+		}
+		return backup.Encode(c.Response(), archive)
+	}
+}
+
+// PreviewRestore handler to validate an uploaded archive and list what restoring it would change
+func PreviewRestore(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		archive, err := backup.Decode(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, err.Error()})
+		}
+
+		plan, err := backup.Preview(db, archive)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, plan)
+	}
+}
+
+// RestoreBackup handler to replace all data in the database with an uploaded archive
+func RestoreBackup(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		archive, err := backup.Decode(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, err.Error()})
+		}
+
+		plan, err := backup.Restore(db, archive)
+		if err != nil {
+			log.Error("Cannot restore backup: ", err)
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, err.Error()})
+		}
+		log.Infof("Restored backup created at %s: %s", archive.CreatedAt.Format(time.RFC3339), plan)
+		if err := auditLog(c, db, "backup.restore", "database", nil, plan); err != nil {
+			log.Error(err)
+		}
+
+		return c.JSON(http.StatusOK, plan)
+	}
+}
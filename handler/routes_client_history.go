@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/rs/xid"
+
+	"github.com/ngoduykhanh/wireguard-ui/ipam"
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// clientHistoryEntry is a saved version of a client, with the changes compared to the version before it
+type clientHistoryEntry struct {
+	Revision int64               `json:"revision"`
+	SavedAt  time.Time           `json:"saved_at"`
+	Current  bool                `json:"current"`
+	Changes  []model.AuditChange `json:"changes"`
+}
+
+// clientRevertPayload selects the version to revert to. CurrentRevision is the revision the caller last read, to
+// detect concurrent modifications.
+type clientRevertPayload struct {
+	Revision        int64 `json:"revision"`
+	CurrentRevision int64 `json:"current_revision"`
+}
+
+// GetClientHistory handler to list the saved versions of a client, newest first
+func GetClientHistory(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		clientID := c.Param("id")
+		if _, err := xid.FromString(clientID); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid client ID"})
+		}
+
+		clientData, err := db.GetClientByID(clientID, model.QRCodeSettings{Enabled: false})
+		if err != nil {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, "Client not found"})
+		}
+		versions, err := db.GetClientVersions(clientID)
+		if err != nil {
+			log.Error("Cannot get client history: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get client history"})
+		}
+
+		history := make([]clientHistoryEntry, 0, len(versions))
+		for i, version := range versions {
+			// the oldest version is listed with all its fields if it is the first one, and without changes if the
+			// versions before it have been removed
+			changes := make([]model.AuditChange, 0)
+			if i+1 < len(versions) {
+				changes = util.AuditChanges(versions[i+1].Client, version.Client)
+			} else if version.Revision <= 1 {
+				changes = util.AuditChanges(nil, version.Client)
+			}
+			history = append(history, clientHistoryEntry{
+				Revision: version.Revision,
+				SavedAt:  version.SavedAt,
+				Current:  version.Revision == clientData.Client.Revision,
+				Changes:  changes,
+			})
+		}
+
+		return c.JSON(http.StatusOK, history)
+	}
+}
+
+// GetClientVersionDiff handler to compare two versions of a client, given by the from and to query parameters. to
+// defaults to the current version.
+func GetClientVersionDiff(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		clientID := c.Param("id")
+		if _, err := xid.FromString(clientID); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid client ID"})
+		}
+
+		from, err := strconv.ParseInt(c.QueryParam("from"), 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid from revision"})
+		}
+		clientData, err := db.GetClientByID(clientID, model.QRCodeSettings{Enabled: false})
+		if err != nil {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, "Client not found"})
+		}
+		to := clientData.Client.Revision
+		if c.QueryParam("to") != "" {
+			if to, err = strconv.ParseInt(c.QueryParam("to"), 10, 64); err != nil {
+				return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid to revision"})
+			}
+		}
+
+		versions, err := db.GetClientVersions(clientID)
+		if err != nil {
+			log.Error("Cannot get client history: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get client history"})
+		}
+		fromVersion, found := findClientVersion(versions, from)
+		if !found {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, "Revision " + strconv.FormatInt(from, 10) + " not found"})
+		}
+		toVersion, found := findClientVersion(versions, to)
+		if !found {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, "Revision " + strconv.FormatInt(to, 10) + " not found"})
+		}
+
+		return c.JSON(http.StatusOK, util.AuditChanges(fromVersion.Client, toVersion.Client))
+	}
+}
+
+// RevertClient handler to restore a client to a saved version. The reverted client is saved as a new revision.
+func RevertClient(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		clientID := c.Param("id")
+		if _, err := xid.FromString(clientID); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid client ID"})
+		}
+
+		var payload clientRevertPayload
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Bad post data"})
+		}
+
+		var client model.Client
+		err := db.Update(func(tx store.IStore) error {
+			clientData, err := tx.GetClientByID(clientID, model.QRCodeSettings{Enabled: false})
+			if err != nil {
+				return &httpError{Code: http.StatusNotFound, Message: "Client not found"}
+			}
+			current := *clientData.Client
+			if err := checkRevision("client", payload.CurrentRevision, current.Revision, current); err != nil {
+				return err
+			}
+
+			versions, err := tx.GetClientVersions(clientID)
+			if err != nil {
+				return err
+			}
+			version, found := findClientVersion(versions, payload.Revision)
+			if !found {
+				return &httpError{Code: http.StatusNotFound, Message: "Revision " + strconv.FormatInt(payload.Revision, 10) + " not found"}
+			}
+			client = version.Client
+
+			// the interface may have been renamed or removed since, the client stays on its current one then
+			if _, err := tx.GetServer(client.Interface); err != nil || client.Interface == "" {
+				client.Interface = current.Interface
+			}
+
+			// the addresses and the public key may have been given to other clients in the meantime
+			if err := ipam.New(tx).Validate(client.Interface, clientID, client.AllocatedIPs); err != nil {
+				return &httpError{Code: http.StatusBadRequest, Message: "Cannot revert: " + err.Error()}
+			}
+			clients, err := tx.GetClients(false)
+			if err != nil {
+				return err
+			}
+			for _, other := range clients {
+				if other.Client.ID != clientID && other.Client.PublicKey == client.PublicKey {
+					return &httpError{Code: http.StatusBadRequest, Message: "Cannot revert: the public key is used by client " + other.Client.Name}
+				}
+			}
+
+			client.CreatedAt = current.CreatedAt
+			client.UpdatedAt = time.Now().UTC()
+			client.Revision = current.Revision + 1
+			if err := tx.SaveClient(client); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "client.revert", clientAuditTarget(client), current, client)
+		})
+		if err != nil {
+			return txErrorResponse(c, err)
+		}
+		log.Infof("Reverted client %s to revision %d", clientID, payload.Revision)
+
+		return c.JSON(http.StatusOK, client)
+	}
+}
+
+func findClientVersion(versions []model.ClientVersion, revision int64) (model.ClientVersion, bool) {
+	for _, version := range versions {
+		if version.Revision == revision {
+			return version, true
+		}
+	}
+	return model.ClientVersion{}, false
+}
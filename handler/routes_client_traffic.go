@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/rs/xid"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/traffic"
+)
+
+// defaultTrafficRange is the range of the traffic samples returned without since parameter
+const defaultTrafficRange = 24 * time.Hour
+
+// ClientPage handler to show the details of a client with the charts of its traffic history
+func ClientPage(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		clientID := c.Param("id")
+		if _, err := xid.FromString(clientID); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid client ID"})
+		}
+
+		clientData, err := db.GetClientByID(clientID, model.QRCodeSettings{Enabled: false})
+		if err != nil {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, "Client not found"})
+		}
+
+		return c.Render(http.StatusOK, "client.html", map[string]interface{}{
+			"baseData": model.BaseData{Active: "", CurrentUser: currentUser(c), Admin: isAdmin(c)},
+			"client":   clientData.Client,
+		})
+	}
+}
+
+// GetClientTraffic handler to list the traffic samples of a client, oldest first. The range is selected with the
+// since and until query parameters, which take a date or an RFC 3339 time, and is the last 24 hours by default. With
+// hourly=true the samples are merged into one sample per hour.
+func GetClientTraffic(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		clientID := c.Param("id")
+		if _, err := xid.FromString(clientID); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a valid client ID"})
+		}
+		if _, err := db.GetClientByID(clientID, model.QRCodeSettings{Enabled: false}); err != nil {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, "Client not found"})
+		}
+
+		filter := model.TrafficSampleFilter{ClientID: clientID}
+		var err error
+		if filter.Since, err = parseAuditTime(c.QueryParam("since"), false); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Invalid since parameter"})
+		}
+		if filter.Until, err = parseAuditTime(c.QueryParam("until"), true); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Invalid until parameter"})
+		}
+		if filter.Since.IsZero() {
+			until := filter.Until
+			if until.IsZero() {
+				until = time.Now()
+			}
+			filter.Since = until.Add(-defaultTrafficRange)
+		}
+
+		samples, err := db.GetTrafficSamples(filter)
+		if err != nil {
+			log.Error("Cannot get traffic samples: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot get traffic samples"})
+		}
+		if c.QueryParam("hourly") == "true" {
+			samples = traffic.MergeHourly(samples)
+		}
+		if samples == nil {
+			samples = make([]model.TrafficSample, 0)
+		}
+
+		// the endpoints are only shown to administrators, like on the status page
+		if !isAdmin(c) {
+			for i := range samples {
+				samples[i].Endpoint = ""
+			}
+		}
+
+		return c.JSON(http.StatusOK, samples)
+	}
+}
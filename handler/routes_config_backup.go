@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/ngoduykhanh/wireguard-ui/apply"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// configBackupFilePath returns the config file of the interface query parameter, the first interface by default
+func configBackupFilePath(db store.IStore, c echo.Context) (string, error) {
+	server, err := db.GetServer(c.QueryParam("interface"))
+	if err != nil {
+		return "", fmt.Errorf("interface %s not found", c.QueryParam("interface"))
+	}
+	return server.Interface.ConfigFilePath, nil
+}
+
+// GetConfigBackups handler to list the backups of the config file of an interface, newest first
+func GetConfigBackups(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		configFilePath, err := configBackupFilePath(db, c)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, err.Error()})
+		}
+
+		backups, err := util.ListConfigBackups(configFilePath)
+		if err != nil {
+			log.Error("Cannot list config backups: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, "Cannot list config backups"})
+		}
+
+		return c.JSON(http.StatusOK, backups)
+	}
+}
+
+// GetConfigBackupDiff handler to compare the config file of an interface with one of its backups
+func GetConfigBackupDiff(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		configFilePath, err := configBackupFilePath(db, c)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, err.Error()})
+		}
+
+		diff, err := apply.RollbackDiff(configFilePath, c.Param("name"))
+		if os.IsNotExist(err) {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, "Config backup not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"diff": diff})
+	}
+}
+
+// RollbackConfigBackup handler to replace the config file of an interface with one of its backups
+func RollbackConfigBackup(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		configFilePath, err := configBackupFilePath(db, c)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, err.Error()})
+		}
+
+		name := c.Param("name")
+		err = apply.Rollback(configFilePath, name)
+		if os.IsNotExist(err) {
+			return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, "Config backup not found"})
+		}
+		if errors.Is(err, util.ErrInvalidConfigBackupName) {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, err.Error()})
+		}
+		if err != nil {
+			log.Error("Cannot roll back config file: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, fmt.Sprintf("Cannot roll back config file: %v", err)})
+		}
+		log.Infof("Rolled back %s to %s", configFilePath, name)
+		if err := auditLog(c, db, "config.rollback", name, nil, nil); err != nil {
+			log.Error(err)
+		}
+
+		return c.JSON(http.StatusOK, jsonHTTPResponse{true, "Rolled back config file to " + name})
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/ngoduykhanh/wireguard-ui/apply"
+	"github.com/ngoduykhanh/wireguard-ui/importer"
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/wgconf"
+)
+
+// DriftPage handler
+func DriftPage() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.Render(http.StatusOK, "drift.html", map[string]interface{}{
+			"baseData": model.BaseData{Active: "drift", CurrentUser: currentUser(c), Admin: isAdmin(c)},
+		})
+	}
+}
+
+// GetDrift handler to compare the clients with the peers of the config file and the running interface
+func GetDrift(db store.IStore, tmplDir fs.FS) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		drift, err := apply.DetectDrift(db, tmplDir)
+		if err != nil {
+			log.Error("Cannot detect drift: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
+		}
+		return c.JSON(http.StatusOK, drift)
+	}
+}
+
+// driftPeerPayload names an unknown peer of the config file or the running interface
+type driftPeerPayload struct {
+	Interface string `json:"interface"`
+	PublicKey string `json:"public_key"`
+	// Name is the name of the adopted client, defaults to the peer's "# Name:" comment in the config file
+	Name string `json:"name"`
+}
+
+// AdoptDriftPeer handler to create a client from a peer of the config file or the running interface which has none
+func AdoptDriftPeer(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload driftPeerPayload
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Bad post data"})
+		}
+
+		peer, source, err := apply.UnknownPeer(db, payload.Interface, payload.PublicKey)
+		if err != nil {
+			return driftErrorResponse(c, err)
+		}
+		if name := strings.TrimSpace(payload.Name); name != "" {
+			peer.Comments["name"] = name
+		}
+
+		report, err := importer.AdoptPeers(db, payload.Interface, source, []*wgconf.Peer{peer}, false)
+		if err != nil {
+			log.Error("Cannot adopt peer: ", err)
+			return c.JSON(http.StatusInternalServerError, jsonHTTPResponse{false, err.Error()})
+		}
+		if len(report.Conflicts) > 0 {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, report.Conflicts[0].String()})
+		}
+		log.Infof("Adopted peer %s from %s as client %s", payload.PublicKey, source, report.Clients[0])
+		if err := auditLog(c, db, "drift.adopt", payload.PublicKey, nil, report); err != nil {
+			log.Error(err)
+		}
+
+		return c.JSON(http.StatusOK, jsonHTTPResponse{true, "Adopted the peer as client " + report.Clients[0]})
+	}
+}
+
+// RemoveDriftPeer handler to remove a peer without an enabled client from the running interface
+func RemoveDriftPeer(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload driftPeerPayload
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Bad post data"})
+		}
+
+		if err := apply.RemovePeer(db, payload.Interface, payload.PublicKey); err != nil {
+			return driftErrorResponse(c, err)
+		}
+		log.Infof("Removed peer %s from interface %s", payload.PublicKey, payload.Interface)
+		if err := auditLog(c, db, "drift.remove", payload.PublicKey, nil, nil); err != nil {
+			log.Error(err)
+		}
+
+		return c.JSON(http.StatusOK, jsonHTTPResponse{true, "Removed the peer from the interface"})
+	}
+}
+
+func driftErrorResponse(c echo.Context, err error) error {
+	if errors.Is(err, apply.ErrPeerNotFound) {
+		return c.JSON(http.StatusNotFound, jsonHTTPResponse{false, "Peer not found"})
+	}
+	log.Error("Cannot reconcile peer: ", err)
+	return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, err.Error()})
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/ngoduykhanh/wireguard-ui/importer"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+)
+
+// serverConfigImportPayload is an uploaded server config file with the import options
+type serverConfigImportPayload struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	importer.ServerOptions
+}
+
+// ImportServerConfig handler to create clients, and optionally the server settings, from an uploaded wg-quick
+// server config file
+func ImportServerConfig(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload serverConfigImportPayload
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Bad post data"})
+		}
+		if strings.TrimSpace(payload.Content) == "" {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide a config file"})
+		}
+		if payload.Name == "" {
+			payload.Name = "config file"
+		}
+
+		report, err := importer.ServerConfig(db, payload.Name, []byte(payload.Content), payload.ServerOptions)
+		if err != nil {
+			log.Error("Cannot import server config: ", err)
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, err.Error()})
+		}
+		if !payload.DryRun {
+			log.Infof("Imported %s: %d clients, %d conflicts", payload.Name, len(report.Clients), len(report.Conflicts))
+			if err := auditLog(c, db, "config.import", payload.Name, nil, report); err != nil {
+				log.Error(err)
+			}
+		}
+
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
+// clientConfigsImportPayload are uploaded client config files or zip archives, with base64 encoded content
+type clientConfigsImportPayload struct {
+	Files []importer.File `json:"files"`
+	importer.ClientOptions
+}
+
+// ImportClientConfigs handler to create clients from uploaded wg-quick client config files
+func ImportClientConfigs(db store.IStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload clientConfigsImportPayload
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Bad post data"})
+		}
+		if len(payload.Files) == 0 {
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, "Please provide at least one config file"})
+		}
+
+		report, err := importer.ClientConfigs(db, payload.Files, payload.ClientOptions)
+		if err != nil {
+			log.Error("Cannot import client configs: ", err)
+			return c.JSON(http.StatusBadRequest, jsonHTTPResponse{false, err.Error()})
+		}
+		if !payload.DryRun {
+			log.Infof("Imported client configs: %d clients, %d conflicts", len(report.Clients), len(report.Conflicts))
+			if err := auditLog(c, db, "client.import", "client configs", nil, report); err != nil {
+				log.Error(err)
+			}
+		}
+
+		return c.JSON(http.StatusOK, report)
+	}
+}
@@ -63,38 +63,47 @@ func SaveWakeOnLanHost(db store.IStore) echo.HandlerFunc {
 			MacAddress: payload.MacAddress,
 			Name:       payload.Name,
 		}
-		if len(payload.OldMacAddress) != 0 { // Edit
-			if payload.OldMacAddress != payload.MacAddress { // modified mac address
-				oldHost, err := db.GetWakeOnLanHost(payload.OldMacAddress)
-				if err != nil {
-					return createError(c, err, fmt.Sprintf("Wake On Host Update Err: %s", err))
-				}
+		// check for existing hosts and write within one transaction, so concurrent requests cannot save the same mac
+		// address twice
+		err = db.Update(func(tx store.IStore) error {
+			var before *model.WakeOnLanHost
+			action := "wol_host.create"
+			if len(payload.OldMacAddress) != 0 { // Edit
+				action = "wol_host.update"
+				if payload.OldMacAddress != payload.MacAddress { // modified mac address
+					oldHost, err := tx.GetWakeOnLanHost(payload.OldMacAddress)
+					if err != nil {
+						return &httpError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Wake On Host Update Err: %s", err)}
+					}
 
-				if payload.OldMacAddress != payload.MacAddress {
-					existHost, _ := db.GetWakeOnLanHost(payload.MacAddress)
+					existHost, _ := tx.GetWakeOnLanHost(payload.MacAddress)
 					if existHost != nil {
-						return createError(c, nil, "Mac Address already exists.")
+						return &httpError{Code: http.StatusInternalServerError, Message: "Mac Address already exists."}
 					}
-				}
 
-				err = db.DeleteWakeOnHostLanHost(payload.OldMacAddress)
-				if err != nil {
-					return createError(c, err, fmt.Sprintf("Wake On Host Update Err: %s", err))
+					err = tx.DeleteWakeOnHostLanHost(payload.OldMacAddress)
+					if err != nil {
+						return &httpError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Wake On Host Update Err: %s", err)}
+					}
+					host.LatestUsed = oldHost.LatestUsed
+					before = oldHost
+				} else {
+					before, _ = tx.GetWakeOnLanHost(payload.OldMacAddress)
+				}
+			} else { // new
+				existHost, _ := tx.GetWakeOnLanHost(payload.MacAddress)
+				if existHost != nil {
+					return &httpError{Code: http.StatusInternalServerError, Message: "Mac Address already exists."}
 				}
-				host.LatestUsed = oldHost.LatestUsed
-			}
-			err = db.SaveWakeOnLanHost(host)
-		} else { // new
-			existHost, _ := db.GetWakeOnLanHost(payload.MacAddress)
-			if existHost != nil {
-				return createError(c, nil, "Mac Address already exists.")
 			}
 
-			err = db.SaveWakeOnLanHost(host)
-		}
-
+			if err := tx.SaveWakeOnLanHost(host); err != nil {
+				return &httpError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Wake On Host Save Error: %s", err)}
+			}
+			return auditLog(c, tx, action, host.MacAddress, before, host)
+		})
 		if err != nil {
-			return createError(c, err, fmt.Sprintf("Wake On Host Save Error: %s", err))
+			return txErrorResponse(c, err)
 		}
 
 		return c.JSON(http.StatusOK, host)
@@ -111,7 +120,12 @@ func DeleteWakeOnHost(db store.IStore) echo.HandlerFunc {
 			return createError(c, err, fmt.Sprintf("Wake On Host Delete Error: %s", macAddress))
 		}
 
-		err = db.DeleteWakeOnHost(*host)
+		err = db.Update(func(tx store.IStore) error {
+			if err := tx.DeleteWakeOnHost(*host); err != nil {
+				return err
+			}
+			return auditLog(c, tx, "wol_host.delete", host.MacAddress, *host, nil)
+		})
 		if err != nil {
 			return createError(c, err, fmt.Sprintf("Wake On Host Delete Error: %s", macAddress))
 		}
@@ -123,11 +137,17 @@ func DeleteWakeOnHost(db store.IStore) echo.HandlerFunc {
 func WakeOnHost(db store.IStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		macAddress := c.Param("mac_address")
-		host, err := db.GetWakeOnLanHost(macAddress)
 
+		// the host is read within the update, so a concurrent edit of it is not overwritten
 		now := time.Now().UTC()
-		host.LatestUsed = &now
-		err = db.SaveWakeOnLanHost(*host)
+		err := db.Update(func(tx store.IStore) error {
+			host, err := tx.GetWakeOnLanHost(macAddress)
+			if err != nil {
+				return err
+			}
+			host.LatestUsed = &now
+			return tx.SaveWakeOnLanHost(*host)
+		})
 		if err != nil {
 			return createError(c, err, fmt.Sprintf("Latest Used Update Error: %s", macAddress))
 		}
@@ -167,6 +187,6 @@ func WakeOnHost(db store.IStore) echo.HandlerFunc {
 			return createError(c, err, fmt.Sprintf("Network Send Error: %s", macAddress))
 		}
 
-		return c.JSON(http.StatusOK, host.LatestUsed)
+		return c.JSON(http.StatusOK, &now)
 	}
 }
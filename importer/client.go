@@ -0,0 +1,219 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/ngoduykhanh/wireguard-ui/ipam"
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+	"github.com/ngoduykhanh/wireguard-ui/wgconf"
+)
+
+// limits for the config files in a zip archive
+const (
+	maxZipEntries     = 1000
+	maxConfigFileSize = 1 << 20
+)
+
+// File is an uploaded config file or zip archive of config files
+type File struct {
+	Name    string `json:"name"`
+	Content []byte `json:"content"`
+}
+
+// ClientOptions control the import of client config files
+type ClientOptions struct {
+	// DryRun reports what would be imported without writing anything
+	DryRun bool `json:"dry_run"`
+}
+
+// ClientConfigs to create a client from each wg-quick client config file, e.g. one downloaded from another
+// wireguard-ui instance. Zip archives are searched for .conf files. The client is named after the file, its
+// addresses, keys, allowed ips and DNS usage are taken from the file, it becomes a peer of the server interface whose
+// public key is the file's peer. Files which are invalid, are not for one of the server interfaces, or whose keys or
+// addresses are already used, are reported as conflicts and skipped.
+func ClientConfigs(db store.IStore, files []File, opts ClientOptions) (Report, error) {
+	report := newReport(opts.DryRun)
+
+	configFiles, err := expandZips(files)
+	if err != nil {
+		return report, err
+	}
+
+	err = db.Update(func(tx store.IStore) error {
+		servers, err := tx.GetServers()
+		if err != nil {
+			return fmt.Errorf("cannot get server config: %v", err)
+		}
+		clients, err := tx.GetClients(false)
+		if err != nil {
+			return fmt.Errorf("cannot get clients: %v", err)
+		}
+		publicKeys := make(map[string]string, len(clients))
+		for _, clientData := range clients {
+			publicKeys[clientData.Client.PublicKey] = clientData.Client.Name
+		}
+		allocatedIPs, err := ipam.New(tx).AllocatedIPs("")
+		if err != nil {
+			return err
+		}
+
+		defaults := util.ClientDefaultsFromEnv()
+		now := time.Now().UTC()
+		for _, file := range configFiles {
+			client, server, line, err := clientFromConfig(file, servers)
+			if err != nil {
+				report.conflict(file.Name, line, "%v", err)
+				continue
+			}
+			if existing, found := publicKeys[client.PublicKey]; found {
+				report.conflict(file.Name, 0, "the public key is used by client %s", existing)
+				continue
+			}
+			if _, err := ipam.ValidateIPAllocation(server.Interface.Addresses, allocatedIPs, client.AllocatedIPs); err != nil {
+				report.conflict(file.Name, 0, "%v", err)
+				continue
+			}
+
+			client.ID = xid.New().String()
+			client.ExtraAllowedIPs = defaults.ExtraAllowedIps
+			client.Enabled = true
+			client.CreatedAt = now
+			client.UpdatedAt = now
+			client.Revision = 1
+			if !opts.DryRun {
+				if err := tx.SaveClient(client); err != nil {
+					return err
+				}
+			}
+			publicKeys[client.PublicKey] = client.Name
+			for _, cidr := range client.AllocatedIPs {
+				if ip, err := util.GetIPFromCIDR(cidr); err == nil {
+					allocatedIPs = append(allocatedIPs, ip)
+				}
+			}
+			report.Clients = append(report.Clients, client.Name)
+		}
+		return nil
+	})
+	return report, err
+}
+
+// clientFromConfig to read the client settings from a client config file, and find the server interface it is for.
+// The returned line is the line of the problem in the file, if there is one.
+func clientFromConfig(file File, servers []model.Server) (model.Client, model.Server, int, error) {
+	var server model.Server
+	client := model.Client{Name: strings.TrimSuffix(path.Base(file.Name), ".conf")}
+
+	config, err := wgconf.Parse(file.Content)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		return client, server, 0, fmt.Errorf("invalid config file: %v", err)
+	}
+	iface := config.Interface
+
+	key, err := wgtypes.ParseKey(iface.PrivateKey)
+	if err != nil {
+		return client, server, iface.Line, err
+	}
+	client.PrivateKey = key.String()
+	client.PublicKey = key.PublicKey().String()
+
+	// the server knows the client by its host addresses
+	if len(iface.Address) == 0 {
+		return client, server, iface.Line, fmt.Errorf("the config has no Address")
+	}
+	for _, cidr := range iface.Address {
+		ip, _, _ := net.ParseCIDR(cidr)
+		if ip.To4() != nil {
+			client.AllocatedIPs = append(client.AllocatedIPs, ip.String()+"/32")
+		} else {
+			client.AllocatedIPs = append(client.AllocatedIPs, ip.String()+"/128")
+		}
+	}
+	client.UseServerDNS = len(iface.DNS) > 0
+
+	var peer *wgconf.Peer
+	for _, p := range config.Peers {
+		for _, candidate := range servers {
+			if p.PublicKey == candidate.KeyPair.PublicKey {
+				peer, server = p, candidate
+				break
+			}
+		}
+		if peer != nil {
+			break
+		}
+	}
+	if peer == nil {
+		return client, server, 0, fmt.Errorf("the config has no peer with the public key of a server interface")
+	}
+	client.Interface = server.Interface.Name
+	client.PresharedKey = peer.PresharedKey
+	client.AllowedIPs = peer.AllowedIPs
+	if len(client.AllowedIPs) == 0 {
+		return client, server, peer.Line, fmt.Errorf("the peer has no AllowedIPs")
+	}
+
+	return client, server, 0, nil
+}
+
+// expandZips to replace zip archives by the .conf files in them
+func expandZips(files []File) ([]File, error) {
+	var expanded []File
+	for _, file := range files {
+		if !strings.EqualFold(path.Ext(file.Name), ".zip") {
+			expanded = append(expanded, file)
+			continue
+		}
+
+		archive, err := zip.NewReader(bytes.NewReader(file.Content), int64(len(file.Content)))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %v", file.Name, err)
+		}
+		if len(archive.File) > maxZipEntries {
+			return nil, fmt.Errorf("%s has more than %d files", file.Name, maxZipEntries)
+		}
+		for _, entry := range archive.File {
+			if entry.FileInfo().IsDir() || !strings.EqualFold(path.Ext(entry.Name), ".conf") {
+				continue
+			}
+			content, err := readZipEntry(entry)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read %s in %s: %v", entry.Name, file.Name, err)
+			}
+			expanded = append(expanded, File{Name: file.Name + "/" + entry.Name, Content: content})
+		}
+	}
+	return expanded, nil
+}
+
+func readZipEntry(entry *zip.File) ([]byte, error) {
+	r, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(io.LimitReader(r, maxConfigFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxConfigFileSize {
+		return nil, fmt.Errorf("the file is larger than %d bytes", maxConfigFileSize)
+	}
+	return content, nil
+}
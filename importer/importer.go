@@ -0,0 +1,100 @@
+// Package importer creates database records from existing WireGuard config files
+package importer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Conflict is a part of a config file which was not imported
+type Conflict struct {
+	// Source is the file or peer the conflict is in, Line the line in that file, 0 if it is about the whole file
+	Source  string `json:"source"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (c Conflict) String() string {
+	if c.Line == 0 {
+		return fmt.Sprintf("%s: %s", c.Source, c.Message)
+	}
+	return fmt.Sprintf("%s, line %d: %s", c.Source, c.Line, c.Message)
+}
+
+// Report describes what an import changed, or would change for a dry run
+type Report struct {
+	DryRun bool `json:"dry_run"`
+	// Interface is the name of the server interface imported into, empty for client config files
+	Interface       string     `json:"interface,omitempty"`
+	ServerInterface bool       `json:"server_interface"`
+	ServerKeyPair   bool       `json:"server_keypair"`
+	GlobalSettings  bool       `json:"global_settings"`
+	Clients         []string   `json:"clients"`
+	Conflicts       []Conflict `json:"conflicts"`
+}
+
+func newReport(dryRun bool) Report {
+	return Report{DryRun: dryRun, Clients: []string{}, Conflicts: []Conflict{}}
+}
+
+// String returns a human readable summary of the report
+func (r Report) String() string {
+	var b strings.Builder
+	verb := "imported"
+	if r.DryRun {
+		verb = "to import"
+	}
+	if r.ServerInterface {
+		fmt.Fprintf(&b, "Server interface %s: %s\n", r.Interface, verb)
+	}
+	if r.ServerKeyPair {
+		fmt.Fprintf(&b, "Server key pair of %s: %s\n", r.Interface, verb)
+	}
+	if r.GlobalSettings {
+		fmt.Fprintf(&b, "Global settings: %s\n", verb)
+	}
+	for _, name := range r.Clients {
+		fmt.Fprintf(&b, "Client %s: %s\n", verb, name)
+	}
+	for _, conflict := range r.Conflicts {
+		fmt.Fprintf(&b, "Conflict: %s\n", conflict)
+	}
+	if b.Len() == 0 {
+		return "Nothing to import\n"
+	}
+	return b.String()
+}
+
+func (r *Report) conflict(source string, line int, format string, args ...interface{}) {
+	r.Conflicts = append(r.Conflicts, Conflict{Source: source, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// splitAllowedIPs to separate the addresses of a peer into the host addresses inside the networks of the server,
+// which are allocated to the client, and the other networks routed to the client
+func splitAllowedIPs(serverAddresses []string, allowedIPs []string) (allocated []string, extra []string) {
+	allocated, extra = []string{}, []string{}
+	for _, cidr := range allowedIPs {
+		ip, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			extra = append(extra, cidr)
+			continue
+		}
+		ones, bits := network.Mask.Size()
+		if ones == bits && inNetworks(serverAddresses, ip) {
+			allocated = append(allocated, cidr)
+		} else {
+			extra = append(extra, cidr)
+		}
+	}
+	return allocated, extra
+}
+
+func inNetworks(cidrs []string, ip net.IP) bool {
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
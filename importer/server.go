@@ -0,0 +1,271 @@
+package importer
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/ngoduykhanh/wireguard-ui/ipam"
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+	"github.com/ngoduykhanh/wireguard-ui/wgconf"
+)
+
+// ServerOptions control the import of a server config file
+type ServerOptions struct {
+	// Server imports the interface settings and the key pair, replacing those in the database. Otherwise differences
+	// to the database are reported as conflicts.
+	Server bool `json:"server"`
+	// Interface is the name of the server interface the config file is imported into, by default the one named after
+	// the file, e.g. wg1 for wg1.conf. A missing interface is created if the server settings are imported.
+	Interface string `json:"interface"`
+	// DryRun reports what would be imported without writing anything
+	DryRun bool `json:"dry_run"`
+}
+
+// ServerConfig to import a wg-quick server config file, e.g. /etc/wireguard/wg0.conf, into the database. Each peer
+// becomes a client, named after the "# Name:" comment in front of it if there is one. Peers whose public key or
+// addresses are already used by a client are reported as conflicts and skipped, existing clients are never changed.
+// source names the file in the report.
+func ServerConfig(db store.IStore, source string, content []byte, opts ServerOptions) (Report, error) {
+	report := newReport(opts.DryRun)
+
+	name := opts.Interface
+	if name == "" {
+		name = util.InterfaceNameFromPath(source)
+	}
+	if !util.ValidateInterfaceName(name) {
+		return report, fmt.Errorf("invalid interface name %q, please choose the interface to import into", name)
+	}
+	report.Interface = name
+
+	config, err := wgconf.Parse(content)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		return report, fmt.Errorf("invalid config file: %v", err)
+	}
+
+	err = db.Update(func(tx store.IStore) error {
+		server, err := importedServer(tx, name, opts.Server)
+		if err != nil {
+			return err
+		}
+		if err := importServer(tx, source, config.Interface, server, opts, &report); err != nil {
+			return err
+		}
+
+		serverAddresses := server.Interface.Addresses
+		serverPublicKey := server.KeyPair.PublicKey
+		if opts.Server {
+			serverAddresses = config.Interface.Address
+			if key, err := wgtypes.ParseKey(config.Interface.PrivateKey); err == nil {
+				serverPublicKey = key.PublicKey().String()
+			}
+		}
+		return importPeers(tx, source, config.Peers, name, serverAddresses, serverPublicKey, opts.DryRun, &report)
+	})
+	return report, err
+}
+
+// importedServer returns the server interface to import into. A missing interface is returned empty, with its
+// config file next to the default one, if it is going to be created.
+func importedServer(tx store.IStore, name string, create bool) (model.Server, error) {
+	servers, err := tx.GetServers()
+	if err != nil {
+		return model.Server{}, fmt.Errorf("cannot get server config: %v", err)
+	}
+	for _, server := range servers {
+		if server.Interface.Name == name {
+			return server, nil
+		}
+	}
+	if !create {
+		return model.Server{}, fmt.Errorf("interface %s does not exist, import the server settings to create it", name)
+	}
+	return model.Server{
+		Interface: &model.ServerInterface{
+			Name:           name,
+			ConfigFilePath: filepath.Join(filepath.Dir(util.DefaultConfigFilePath), name+".conf"),
+			Addresses:      []string{},
+		},
+		KeyPair: &model.ServerKeypair{},
+	}, nil
+}
+
+// importServer to import the interface settings and key pair if asked to, or report how they differ
+func importServer(tx store.IStore, source string, iface *wgconf.Interface, server model.Server, opts ServerOptions, report *Report) error {
+	now := time.Now().UTC()
+
+	keyPair := *server.KeyPair
+	keyPair.PrivateKey = iface.PrivateKey
+	if key, err := wgtypes.ParseKey(iface.PrivateKey); err == nil {
+		keyPair.PublicKey = key.PublicKey().String()
+	}
+
+	serverInterface := *server.Interface
+	serverInterface.Addresses = iface.Address
+	serverInterface.PostUp = strings.Join(iface.PostUp, "; ")
+	serverInterface.PreDown = strings.Join(iface.PreDown, "; ")
+	serverInterface.PostDown = strings.Join(iface.PostDown, "; ")
+	if iface.ListenPort != 0 {
+		serverInterface.ListenPort = iface.ListenPort
+	}
+
+	settings, err := tx.GetGlobalSettings()
+	if err != nil {
+		return fmt.Errorf("cannot get global settings: %v", err)
+	}
+	newSettings := settings
+	if iface.MTU != 0 {
+		newSettings.MTU = iface.MTU
+	}
+	if iface.Table != "" {
+		newSettings.Table = iface.Table
+	}
+	if iface.FwMark != "" {
+		newSettings.FirewallMark = iface.FwMark
+	}
+
+	report.ServerKeyPair = keyPair.PrivateKey != server.KeyPair.PrivateKey
+	report.ServerInterface = !reflect.DeepEqual(serverInterface, *server.Interface)
+	report.GlobalSettings = !reflect.DeepEqual(newSettings, settings)
+
+	if !opts.Server {
+		if report.ServerKeyPair {
+			report.conflict(source, iface.Line, "the private key differs from interface %s", serverInterface.Name)
+		}
+		if report.ServerInterface {
+			report.conflict(source, iface.Line, "the addresses, listen port or scripts differ from interface %s", serverInterface.Name)
+		}
+		if report.GlobalSettings {
+			report.conflict(source, iface.Line, "the MTU, routing table or firewall mark differ from the global settings")
+		}
+		report.ServerKeyPair, report.ServerInterface, report.GlobalSettings = false, false, false
+		return nil
+	}
+	if opts.DryRun {
+		return nil
+	}
+
+	// the interface has to exist before its key pair is saved
+	if report.ServerInterface {
+		serverInterface.UpdatedAt = now
+		if err := tx.SaveServerInterface(serverInterface); err != nil {
+			return err
+		}
+	}
+	if report.ServerKeyPair {
+		keyPair.UpdatedAt = now
+		if err := tx.SaveServerKeyPair(serverInterface.Name, keyPair); err != nil {
+			return err
+		}
+	}
+	if report.GlobalSettings {
+		newSettings.UpdatedAt = now
+		newSettings.Revision++
+		if err := tx.SaveGlobalSettings(newSettings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AdoptPeers to create a client of the named interface for each of the given peers of it, named after their "name"
+// comment. Peers whose public key or addresses are already used by a client are reported as conflicts and skipped.
+func AdoptPeers(db store.IStore, name string, source string, peers []*wgconf.Peer, dryRun bool) (Report, error) {
+	report := newReport(dryRun)
+	report.Interface = name
+	err := db.Update(func(tx store.IStore) error {
+		server, err := tx.GetServer(name)
+		if err != nil {
+			return fmt.Errorf("cannot get server config: %v", err)
+		}
+		return importPeers(tx, source, peers, name, server.Interface.Addresses, server.KeyPair.PublicKey, dryRun, &report)
+	})
+	return report, err
+}
+
+// importPeers to create a client of the named interface for each peer
+func importPeers(tx store.IStore, source string, peers []*wgconf.Peer, name string, serverAddresses []string, serverPublicKey string, dryRun bool, report *Report) error {
+	clients, err := tx.GetClients(false)
+	if err != nil {
+		return fmt.Errorf("cannot get clients: %v", err)
+	}
+	publicKeys := make(map[string]string, len(clients))
+	for _, clientData := range clients {
+		publicKeys[clientData.Client.PublicKey] = clientData.Client.Name
+	}
+	// the addresses of the server and the clients, including those imported before in a dry run
+	allocatedIPs, err := ipam.New(tx).AllocatedIPs("")
+	if err != nil {
+		return err
+	}
+	for _, cidr := range serverAddresses {
+		if ip, err := util.GetIPFromCIDR(cidr); err == nil {
+			allocatedIPs = append(allocatedIPs, ip)
+		}
+	}
+
+	defaults := util.ClientDefaultsFromEnv()
+	now := time.Now().UTC()
+	for _, peer := range peers {
+		clientName := peer.Comments["name"]
+		if clientName == "" {
+			clientName = "imported-" + strings.NewReplacer("/", "", "+", "").Replace(peer.PublicKey)[:8]
+		}
+		peerSource := fmt.Sprintf("%s, peer %s", source, clientName)
+
+		if existing, found := publicKeys[peer.PublicKey]; found {
+			report.conflict(peerSource, peer.Line, "the public key is used by client %s", existing)
+			continue
+		}
+		if peer.PublicKey == serverPublicKey {
+			report.conflict(peerSource, peer.Line, "the public key is the server's")
+			continue
+		}
+		allocated, extra := splitAllowedIPs(serverAddresses, peer.AllowedIPs)
+		if _, err := ipam.ValidateIPAllocation(serverAddresses, allocatedIPs, allocated); err != nil {
+			report.conflict(peerSource, peer.Line, "%v", err)
+			continue
+		}
+
+		client := model.Client{
+			ID:              xid.New().String(),
+			PublicKey:       peer.PublicKey,
+			PresharedKey:    peer.PresharedKey,
+			Name:            clientName,
+			Email:           peer.Comments["email"],
+			AllocatedIPs:    allocated,
+			AllowedIPs:      defaults.AllowedIps,
+			ExtraAllowedIPs: extra,
+			Endpoint:        peer.Endpoint,
+			UseServerDNS:    defaults.UseServerDNS,
+			Enabled:         true,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+			Revision:        1,
+			Interface:       name,
+		}
+		if !dryRun {
+			if err := tx.SaveClient(client); err != nil {
+				return err
+			}
+		}
+		publicKeys[client.PublicKey] = client.Name
+		for _, cidr := range allocated {
+			if ip, err := util.GetIPFromCIDR(cidr); err == nil {
+				allocatedIPs = append(allocatedIPs, ip)
+			}
+		}
+		report.Clients = append(report.Clients, client.Name)
+	}
+	return nil
+}
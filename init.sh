@@ -1,20 +1,38 @@
 #!/bin/bash
 
-# extract wg config file path, or use default
-conf="$(jq -r .config_file_path db/server/global_settings.json || echo /etc/wireguard/wg0.conf)"
+# extract the wg config file path of every interface, or use default
+confs=()
+for record in db/interfaces/*.json; do
+    [[ -f $record ]] && confs+=("$(jq -r .interface.config_file_path "$record")")
+done
+if [[ ${#confs[@]} -eq 0 ]]; then
+    # databases which were not migrated to multiple interfaces yet keep the path in the global settings
+    conf="$(jq -r '.config_file_path // empty' db/server/global_settings.json 2>/dev/null)"
+    confs=("${conf:-/etc/wireguard/wg0.conf}")
+fi
 
 # manage wireguard stop/start with the container
 case $WGUI_MANAGE_START in (1|t|T|true|True|TRUE)
-    wg-quick up "$conf"
-    trap 'wg-quick down "$conf"' SIGTERM # catches container stop
+    for conf in "${confs[@]}"; do
+        wg-quick up "$conf"
+    done
+    trap 'for conf in "${confs[@]}"; do wg-quick down "$conf"; done' SIGTERM # catches container stop
 esac
 
-# manage wireguard restarts
+# manage wireguard restarts, unless wireguard-ui updates the running interface itself
+case $WGUI_APPLY_MODE in (wgctrl|both) WGUI_MANAGE_RESTART=false ;; esac
 case $WGUI_MANAGE_RESTART in (1|t|T|true|True|TRUE)
-    [[ -f $conf ]] || touch "$conf" # inotifyd needs file to exist
-    inotifyd - "$conf":w | while read -r event file; do
-        wg-quick down "$file"
-        wg-quick up "$file"
+    # the config file is replaced by renaming a new file over it, so watch the directories for files moved into them
+    watches=()
+    for conf in "${confs[@]}"; do
+        watches+=("$(dirname "$conf")":y)
+    done
+    inotifyd - "${watches[@]}" | while read -r event dir file; do
+        for conf in "${confs[@]}"; do
+            [[ $dir/$file == "$conf" ]] || continue
+            wg-quick down "$conf"
+            wg-quick up "$conf"
+        done
     done &
 esac
 
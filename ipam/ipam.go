@@ -0,0 +1,236 @@
+package ipam
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// IPAM manages the ip addresses allocated to clients within the networks of the WireGuard server interfaces. The
+// store is the only source of truth for the interfaces' networks and the current allocations.
+type IPAM struct {
+	db store.IStore
+}
+
+// New returns a new pointer IPAM reading from the given store
+func New(db store.IStore) *IPAM {
+	return &IPAM{db: db}
+}
+
+// AllocatedIPs to get all ip addresses allocated to clients and server interfaces, excluding those of the client
+// with id ignoreClientID so a client can keep its own addresses when it is updated
+func (o *IPAM) AllocatedIPs(ignoreClientID string) ([]string, error) {
+	allocatedIPs := make([]string, 0)
+
+	// read server information
+	servers, err := o.db.GetServers()
+	if err != nil {
+		return nil, err
+	}
+
+	// append the addresses of every interface to the result
+	for _, server := range servers {
+		for _, cidr := range server.Interface.Addresses {
+			ip, err := util.GetIPFromCIDR(cidr)
+			if err != nil {
+				return nil, err
+			}
+			allocatedIPs = append(allocatedIPs, ip)
+		}
+	}
+
+	// read client information
+	clients, err := o.db.GetClients(false)
+	if err != nil {
+		return nil, err
+	}
+
+	// append client's addresses to the result
+	for _, clientData := range clients {
+		client := clientData.Client
+		if client.ID != ignoreClientID {
+			for _, cidr := range client.AllocatedIPs {
+				ip, err := util.GetIPFromCIDR(cidr)
+				if err != nil {
+					return nil, err
+				}
+				allocatedIPs = append(allocatedIPs, ip)
+			}
+		}
+	}
+
+	return allocatedIPs, nil
+}
+
+// Validate to check that the ip allocation of the client with id clientID (empty for a new client) is in CIDR
+// format, not in use by a server interface or another client, and inside the networks of the named interface
+func (o *IPAM) Validate(name string, clientID string, ipAllocationList []string) error {
+	server, err := o.db.GetServer(name)
+	if err != nil {
+		return fmt.Errorf("cannot fetch server config: %v", err)
+	}
+
+	allocatedIPs, err := o.AllocatedIPs(clientID)
+	if err != nil {
+		return fmt.Errorf("cannot get list of allocated ip addresses: %v", err)
+	}
+
+	_, err = ValidateIPAllocation(server.Interface.Addresses, allocatedIPs, ipAllocationList)
+	return err
+}
+
+// Suggest to get the first available ip address, as a host CIDR, from each of the searchCIDRList networks within the
+// named interface. The networks of the interface are searched when searchCIDRList is empty.
+func (o *IPAM) Suggest(name string, searchCIDRList []string) ([]string, error) {
+	server, err := o.db.GetServer(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch server config: %v", err)
+	}
+
+	allocatedIPs, err := o.AllocatedIPs("")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get list of allocated ip addresses: %v", err)
+	}
+
+	if len(searchCIDRList) == 0 {
+		searchCIDRList = server.Interface.Addresses
+	}
+
+	// save only unique IPs
+	suggestedIPs := make([]string, 0)
+	ipSet := make(map[string]struct{})
+
+	for _, cidr := range searchCIDRList {
+		ip, err := GetAvailableIP(cidr, allocatedIPs, server.Interface.Addresses)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(ip, ":") {
+			ip = fmt.Sprintf("%s/128", ip)
+		} else {
+			ip = fmt.Sprintf("%s/32", ip)
+		}
+		if _, ok := ipSet[ip]; !ok {
+			ipSet[ip] = struct{}{}
+			suggestedIPs = append(suggestedIPs, ip)
+		}
+	}
+
+	if len(suggestedIPs) == 0 {
+		return nil, errors.New("no more available ip address")
+	}
+
+	return suggestedIPs, nil
+}
+
+// inc from https://play.golang.org/p/m8TNTtygK0
+func inc(ip net.IP) {
+	for j := len(ip) - 1; j >= 0; j-- {
+		ip[j]++
+		if ip[j] > 0 {
+			break
+		}
+	}
+}
+
+// GetBroadcastIP func to get the broadcast ip address of a network
+func GetBroadcastIP(n *net.IPNet) net.IP {
+	var broadcast net.IP
+	if len(n.IP) == 4 {
+		broadcast = net.ParseIP("0.0.0.0").To4()
+	} else {
+		broadcast = net.ParseIP("::")
+	}
+	for i := 0; i < len(n.IP); i++ {
+		broadcast[i] = n.IP[i] | ^n.Mask[i]
+	}
+	return broadcast
+}
+
+// GetBroadcastAndNetworkAddrsLookup get the ip address that can't be used with current server interfaces
+func GetBroadcastAndNetworkAddrsLookup(interfaceAddresses []string) map[string]bool {
+	list := make(map[string]bool)
+	for _, ifa := range interfaceAddresses {
+		_, netAddr, err := net.ParseCIDR(ifa)
+		if err != nil {
+			continue
+		}
+
+		broadcastAddr := GetBroadcastIP(netAddr).String()
+		networkAddr := netAddr.IP.String()
+		list[broadcastAddr] = true
+		list[networkAddr] = true
+	}
+	return list
+}
+
+// GetAvailableIP get the ip address that can be allocated from an CIDR
+// We need interfaceAddresses to find real broadcast and network addresses
+func GetAvailableIP(cidr string, allocatedList, interfaceAddresses []string) (string, error) {
+	ip, netAddr, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+
+	unavailableIPs := GetBroadcastAndNetworkAddrsLookup(interfaceAddresses)
+
+	for ip := ip.Mask(netAddr.Mask); netAddr.Contains(ip); inc(ip) {
+		available := true
+		suggestedAddr := ip.String()
+		for _, allocatedAddr := range allocatedList {
+			if suggestedAddr == allocatedAddr {
+				available = false
+				break
+			}
+		}
+		if available && !unavailableIPs[suggestedAddr] {
+			return suggestedAddr, nil
+		}
+	}
+
+	return "", errors.New("no more available ip address")
+}
+
+// ValidateIPAllocation to validate the list of client's ip allocation
+// They must have a correct format and available in serverAddresses space
+func ValidateIPAllocation(serverAddresses []string, ipAllocatedList []string, ipAllocationList []string) (bool, error) {
+	for _, clientCIDR := range ipAllocationList {
+		ip, _, _ := net.ParseCIDR(clientCIDR)
+
+		// clientCIDR must be in CIDR format
+		if ip == nil {
+			return false, fmt.Errorf("invalid ip allocation input %s. Must be in CIDR format", clientCIDR)
+		}
+
+		// return false immediately if the ip is already in use (in ipAllocatedList)
+		for _, item := range ipAllocatedList {
+			if item == ip.String() {
+				return false, fmt.Errorf("IP %s already allocated", ip)
+			}
+		}
+
+		// even if it is not in use, we still need to check if it
+		// belongs to a network of the server.
+		var isValid = false
+		for _, serverCIDR := range serverAddresses {
+			_, serverNet, _ := net.ParseCIDR(serverCIDR)
+			if serverNet.Contains(ip) {
+				isValid = true
+				break
+			}
+		}
+
+		// current ip allocation is valid, check the next one
+		if isValid {
+			continue
+		} else {
+			return false, fmt.Errorf("IP %s does not belong to any network addresses of WireGuard server", ip)
+		}
+	}
+
+	return true, nil
+}
@@ -18,10 +18,15 @@ import (
 	"github.com/ngoduykhanh/wireguard-ui/store"
 	"github.com/ngoduykhanh/wireguard-ui/telegram"
 
+	"github.com/ngoduykhanh/wireguard-ui/apply"
 	"github.com/ngoduykhanh/wireguard-ui/emailer"
+	"github.com/ngoduykhanh/wireguard-ui/expiry"
 	"github.com/ngoduykhanh/wireguard-ui/handler"
+	"github.com/ngoduykhanh/wireguard-ui/metrics"
 	"github.com/ngoduykhanh/wireguard-ui/router"
 	"github.com/ngoduykhanh/wireguard-ui/store/jsondb"
+	"github.com/ngoduykhanh/wireguard-ui/store/sqlite"
+	"github.com/ngoduykhanh/wireguard-ui/traffic"
 	"github.com/ngoduykhanh/wireguard-ui/util"
 )
 
@@ -53,6 +58,28 @@ var (
 	flagWgConfTemplate           string
 	flagBasePath                 string
 	flagSubnetRanges             string
+	flagDBType                   = "jsondb"
+	flagDBPath                   = "./db"
+	flagSqlitePath               = "./db/wireguard-ui.db"
+	flagDBEncryptionKey          string
+	flagClientHistoryLimit       = 20
+	flagApplyMode                = "file"
+	flagConfigBackupDir          string
+	flagConfigBackupLimit        = 10
+	flagAutoApply                = false
+	flagAutoApplyDelay           = 5
+	flagPreApplyHook             string
+	flagPostApplyHook            string
+	flagApplyHookTimeout         = 30
+	flagExpiryCheckInterval      = 60
+	flagExpiryApply              = false
+	flagTrafficInterval          = 0
+	flagQuotaApply               = false
+	flagQuotaEmail               = false
+	flagTrafficRawRetention      = 24
+	flagTrafficRetention         = 0
+	flagMetrics                  = false
+	flagMetricsToken             string
 )
 
 const (
@@ -94,11 +121,33 @@ func init() {
 	flag.StringVar(&flagBasePath, "base-path", util.LookupEnvOrString("BASE_PATH", flagBasePath), "The base path of the URL")
 	flag.StringVar(&flagSubnetRanges, "subnet-ranges", util.LookupEnvOrString("SUBNET_RANGES", flagSubnetRanges), "IP ranges to choose from when assigning an IP for a client.")
 	flag.IntVar(&flagSessionMaxDuration, "session-max-duration", util.LookupEnvOrInt("SESSION_MAX_DURATION", flagSessionMaxDuration), "Max time in days a remembered session is refreshed and valid.")
+	flag.StringVar(&flagDBType, "db-type", util.LookupEnvOrString("WGUI_DB_TYPE", flagDBType), "Database backend: jsondb (by default) or sqlite.")
+	flag.StringVar(&flagDBPath, "db-path", util.LookupEnvOrString("WGUI_DB_PATH", flagDBPath), "Path to the JSON database directory, used when db-type is jsondb.")
+	flag.StringVar(&flagSqlitePath, "sqlite-path", util.LookupEnvOrString("WGUI_SQLITE_PATH", flagSqlitePath), "Path to the SQLite database file, used when db-type is sqlite.")
+	flag.IntVar(&flagClientHistoryLimit, "client-history-limit", util.LookupEnvOrInt("WGUI_CLIENT_HISTORY_LIMIT", flagClientHistoryLimit), "Number of versions kept per client, 0 keeps all of them.")
+	flag.StringVar(&flagApplyMode, "apply-mode", util.LookupEnvOrString("WGUI_APPLY_MODE", flagApplyMode), "How the configuration is applied: file (by default) writes the config file, wgctrl updates the peers of the running interface, both does both.")
+	flag.StringVar(&flagConfigBackupDir, "config-backup-dir", util.LookupEnvOrString("WGUI_CONFIG_BACKUP_DIR", flagConfigBackupDir), "Directory for backups of the written WireGuard config files. Defaults to a directory next to the config file.")
+	flag.IntVar(&flagConfigBackupLimit, "config-backup-limit", util.LookupEnvOrInt("WGUI_CONFIG_BACKUP_LIMIT", flagConfigBackupLimit), "Number of backups kept of the WireGuard config file, 0 keeps all of them.")
+	flag.BoolVar(&flagAutoApply, "auto-apply", util.LookupEnvOrBool("WGUI_AUTO_APPLY", flagAutoApply), "Apply the configuration automatically after changes made in the web UI.")
+	flag.IntVar(&flagAutoApplyDelay, "auto-apply-delay", util.LookupEnvOrInt("WGUI_AUTO_APPLY_DELAY", flagAutoApplyDelay), "Time in seconds without further changes before the configuration is applied automatically.")
+	flag.StringVar(&flagPreApplyHook, "pre-apply-hook", util.LookupEnvOrString("WGUI_PRE_APPLY_HOOK", flagPreApplyHook), "Shell command run before the configuration is applied. The configuration is not applied if it fails.")
+	flag.StringVar(&flagPostApplyHook, "post-apply-hook", util.LookupEnvOrString("WGUI_POST_APPLY_HOOK", flagPostApplyHook), "Shell command run after the configuration is applied, e.g. to reload firewall rules.")
+	flag.IntVar(&flagApplyHookTimeout, "apply-hook-timeout", util.LookupEnvOrInt("WGUI_APPLY_HOOK_TIMEOUT", flagApplyHookTimeout), "Time in seconds after which a pre-apply or post-apply hook command is killed.")
+	flag.IntVar(&flagExpiryCheckInterval, "expiry-check-interval", util.LookupEnvOrInt("WGUI_EXPIRY_CHECK_INTERVAL", flagExpiryCheckInterval), "Time in seconds between the checks for expired clients, which are disabled. 0 disables the checks.")
+	flag.BoolVar(&flagExpiryApply, "expiry-apply", util.LookupEnvOrBool("WGUI_EXPIRY_APPLY", flagExpiryApply), "Apply the configuration right after expired clients were disabled. Implied by auto-apply.")
+	flag.IntVar(&flagTrafficInterval, "traffic-interval", util.LookupEnvOrInt("WGUI_TRAFFIC_INTERVAL", flagTrafficInterval), "Time in seconds between the collections of the traffic of the clients, 0 disables the collection and the quotas.")
+	flag.BoolVar(&flagQuotaApply, "quota-apply", util.LookupEnvOrBool("WGUI_QUOTA_APPLY", flagQuotaApply), "Apply the configuration right after clients were disabled for exceeding their quota, or enabled again in a new period. Implied by auto-apply.")
+	flag.BoolVar(&flagQuotaEmail, "quota-email", util.LookupEnvOrBool("WGUI_QUOTA_EMAIL", flagQuotaEmail), "Email clients when they exceed their quota.")
+	flag.IntVar(&flagTrafficRawRetention, "traffic-raw-retention", util.LookupEnvOrInt("WGUI_TRAFFIC_RAW_RETENTION", flagTrafficRawRetention), "Time in hours the traffic samples of every collection are kept before they are merged into hourly samples.")
+	flag.IntVar(&flagTrafficRetention, "traffic-retention", util.LookupEnvOrInt("WGUI_TRAFFIC_RETENTION", flagTrafficRetention), "Time in days the traffic history of the clients is kept, 0 disables the history.")
+	flag.BoolVar(&flagMetrics, "metrics", util.LookupEnvOrBool("WGUI_METRICS", flagMetrics), "Export Prometheus metrics at /metrics.")
 
 	var (
-		smtpPasswordLookup   = util.LookupEnvOrString("SMTP_PASSWORD", flagSmtpPassword)
-		sendgridApiKeyLookup = util.LookupEnvOrString("SENDGRID_API_KEY", flagSendgridApiKey)
-		sessionSecretLookup  = util.LookupEnvOrString("SESSION_SECRET", flagSessionSecret)
+		smtpPasswordLookup    = util.LookupEnvOrString("SMTP_PASSWORD", flagSmtpPassword)
+		sendgridApiKeyLookup  = util.LookupEnvOrString("SENDGRID_API_KEY", flagSendgridApiKey)
+		sessionSecretLookup   = util.LookupEnvOrString("SESSION_SECRET", flagSessionSecret)
+		dbEncryptionKeyLookup = util.LookupEnvOrString("WGUI_DB_ENCRYPTION_KEY", flagDBEncryptionKey)
+		metricsTokenLookup    = util.LookupEnvOrString("WGUI_METRICS_TOKEN", flagMetricsToken)
 	)
 
 	// check empty smtpPassword env var
@@ -122,6 +171,20 @@ func init() {
 		flag.StringVar(&flagSessionSecret, "session-secret", util.LookupEnvOrFile("SESSION_SECRET_FILE", flagSessionSecret), "File containing the key used to encrypt session cookies.")
 	}
 
+	// check empty dbEncryptionKey env var
+	if dbEncryptionKeyLookup != "" {
+		flag.StringVar(&flagDBEncryptionKey, "db-encryption-key", dbEncryptionKeyLookup, "The key used to encrypt private keys stored in the database.")
+	} else {
+		flag.StringVar(&flagDBEncryptionKey, "db-encryption-key", util.LookupEnvOrFile("WGUI_DB_ENCRYPTION_KEY_FILE", flagDBEncryptionKey), "File containing the key used to encrypt private keys stored in the database.")
+	}
+
+	// check empty metricsToken env var
+	if metricsTokenLookup != "" {
+		flag.StringVar(&flagMetricsToken, "metrics-token", metricsTokenLookup, "The bearer token required to scrape the metrics.")
+	} else {
+		flag.StringVar(&flagMetricsToken, "metrics-token", util.LookupEnvOrFile("WGUI_METRICS_TOKEN_FILE", flagMetricsToken), "File containing the bearer token required to scrape the metrics.")
+	}
+
 	flag.Parse()
 
 	// update runtime config
@@ -143,6 +206,14 @@ func init() {
 	util.WgConfTemplate = flagWgConfTemplate
 	util.BasePath = util.ParseBasePath(flagBasePath)
 	util.SubnetRanges = util.ParseSubnetRanges(flagSubnetRanges)
+	util.DBEncryptionKey = util.NewEncryptionKey(flagDBEncryptionKey)
+	util.ClientHistoryLimit = flagClientHistoryLimit
+	util.ApplyMode = flagApplyMode
+	util.ConfigBackupDir = flagConfigBackupDir
+	util.ConfigBackupLimit = flagConfigBackupLimit
+	util.PreApplyHook = flagPreApplyHook
+	util.PostApplyHook = flagPostApplyHook
+	util.ApplyHookTimeout = time.Duration(flagApplyHookTimeout) * time.Second
 
 	lvl, _ := util.ParseLogLevel(util.LookupEnvOrString(util.LogLevel, "INFO"))
 
@@ -151,8 +222,8 @@ func init() {
 	telegram.FloodWait = flagTelegramFloodWait
 	telegram.LogLevel = lvl
 
-	// print only if log level is INFO or lower
-	if lvl <= log.INFO {
+	// print only if log level is INFO or lower, and not for maintenance commands which may write to stdout
+	if lvl <= log.INFO && flag.NArg() == 0 {
 		// print app information
 		fmt.Println("Wireguard UI")
 		fmt.Println("App Version\t:", appVersion)
@@ -169,11 +240,31 @@ func init() {
 		fmt.Println("Custom wg.conf\t:", util.WgConfTemplate)
 		fmt.Println("Base path\t:", util.BasePath+"/")
 		fmt.Println("Subnet ranges\t:", util.GetSubnetRangesString())
+		fmt.Println("Database type\t:", flagDBType)
+		fmt.Println("Key encryption\t:", util.DBEncryptionKey != nil)
+		fmt.Println("Apply mode\t:", util.ApplyMode)
+		fmt.Println("Auto apply\t:", flagAutoApply)
 	}
 }
 
 func main() {
-	db, err := jsondb.New("./db")
+	// run a maintenance command instead of the web server, e.g. "wireguard-ui migrate --from ... --to ..."
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			log.Fatalf("%s: %v", flag.Arg(0), err)
+		}
+		return
+	}
+
+	if err := apply.ValidateMode(util.ApplyMode); err != nil {
+		log.Fatal(err)
+	}
+	// the metrics reveal the peers and their traffic, so they are never exported without authentication
+	if flagMetrics && flagMetricsToken == "" {
+		log.Fatal("WGUI_METRICS requires a bearer token to scrape the metrics, set WGUI_METRICS_TOKEN or WGUI_METRICS_TOKEN_FILE")
+	}
+
+	db, err := openStore(flagDBType, "")
 	if err != nil {
 		panic(err)
 	}
@@ -186,6 +277,7 @@ func main() {
 	extraData["gitCommit"] = gitCommit
 	extraData["basePath"] = util.BasePath
 	extraData["loginDisabled"] = flagDisableLogin
+	extraData["autoApply"] = flagAutoApply
 
 	// strip the "templates/" prefix from the embedded directory so files can be read by their direct name (e.g.
 	// "base.html" instead of "templates/base.html")
@@ -208,6 +300,32 @@ func main() {
 	// register routes
 	app := router.New(tmplDir, extraData, util.SessionSecret)
 
+	// export Prometheus metrics, the middleware is added first so it measures the whole handling of the requests
+	if flagMetrics {
+		app.Use(metrics.Middleware())
+		metrics.MustRegister(db)
+		app.GET(util.BasePath+"/metrics", metrics.Handler(), handler.BearerToken(flagMetricsToken))
+	}
+
+	// apply the configuration in the background after changes
+	var autoApplier *apply.AutoApplier
+	if flagAutoApply {
+		autoApplier = apply.NewAutoApplier(db, tmplDir, time.Duration(flagAutoApplyDelay)*time.Second)
+		autoApplier.Start()
+		app.Use(handler.AutoApply(autoApplier))
+	}
+
+	// disable expired clients in the background
+	if flagExpiryCheckInterval > 0 {
+		var onExpire func()
+		if autoApplier != nil {
+			onExpire = autoApplier.Trigger
+		} else if flagExpiryApply {
+			onExpire = expiry.ApplyFunc(db, tmplDir)
+		}
+		expiry.NewScheduler(db, time.Duration(flagExpiryCheckInterval)*time.Second, onExpire).Start()
+	}
+
 	app.GET(util.BasePath, handler.WireGuardClients(db), handler.ValidSession, handler.RefreshSession)
 
 	// Important: Make sure that all non-GET routes check the request content type using handler.ContentTypeJson to
@@ -234,7 +352,27 @@ func main() {
 		sendmail = emailer.NewSmtpMail(util.SmtpHostname, util.SmtpPort, util.SmtpUsername, util.SmtpPassword, util.SmtpHelo, util.SmtpNoTLSCheck, util.SmtpAuthType, util.EmailFromName, util.EmailFrom, util.SmtpEncryption)
 	}
 
-	app.GET(util.BasePath+"/test-hash", handler.GetHashesChanges(db), handler.ValidSession)
+	// collect the traffic of the clients, keep its history and enforce the quotas in the background
+	if flagTrafficInterval > 0 {
+		var onQuotaChange func()
+		if autoApplier != nil {
+			onQuotaChange = autoApplier.Trigger
+		} else if flagQuotaApply {
+			onQuotaChange = traffic.ApplyFunc(db, tmplDir)
+		}
+		var notify traffic.Notifier
+		if flagQuotaEmail {
+			notify = traffic.EmailNotifier(sendmail)
+		}
+		history := traffic.History{
+			RawRetention: time.Duration(flagTrafficRawRetention) * time.Hour,
+			Retention:    time.Duration(flagTrafficRetention) * 24 * time.Hour,
+		}
+		traffic.NewCollector(db, time.Duration(flagTrafficInterval)*time.Second, history, onQuotaChange, notify).Start()
+	}
+
+	app.GET(util.BasePath+"/api/config-revisions", handler.GetConfigRevisions(db), handler.ValidSession)
+	app.GET(util.BasePath+"/api/auto-apply", handler.GetAutoApplyStatus(autoApplier), handler.ValidSession)
 	app.GET(util.BasePath+"/about", handler.AboutPage())
 	app.GET(util.BasePath+"/_health", handler.Health())
 	app.GET(util.BasePath+"/favicon", handler.Favicon())
@@ -246,21 +384,45 @@ func main() {
 	app.POST(util.BasePath+"/remove-client", handler.RemoveClient(db), handler.ValidSession, handler.ContentTypeJson)
 	app.GET(util.BasePath+"/download", handler.DownloadClient(db), handler.ValidSession)
 	app.GET(util.BasePath+"/wg-server", handler.WireGuardServer(db), handler.ValidSession, handler.RefreshSession, handler.NeedsAdmin)
-	app.POST(util.BasePath+"/wg-server/interfaces", handler.WireGuardServerInterfaces(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
-	app.POST(util.BasePath+"/wg-server/keypair", handler.WireGuardServerKeyPair(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.POST(util.BasePath+"/wg-server/interfaces", handler.NewServerInterface(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.POST(util.BasePath+"/wg-server/interfaces/:name", handler.WireGuardServerInterfaces(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.DELETE(util.BasePath+"/wg-server/interfaces/:name", handler.RemoveServerInterface(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.POST(util.BasePath+"/wg-server/interfaces/:name/keypair", handler.WireGuardServerKeyPair(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
 	app.GET(util.BasePath+"/global-settings", handler.GlobalSettings(db), handler.ValidSession, handler.RefreshSession, handler.NeedsAdmin)
 	app.POST(util.BasePath+"/global-settings", handler.GlobalSettingSubmit(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
 	app.GET(util.BasePath+"/status", handler.Status(db), handler.ValidSession, handler.RefreshSession)
+	app.GET(util.BasePath+"/drift", handler.DriftPage(), handler.ValidSession, handler.RefreshSession, handler.NeedsAdmin)
+	app.GET(util.BasePath+"/api/drift", handler.GetDrift(db, tmplDir), handler.ValidSession, handler.NeedsAdmin)
+	app.POST(util.BasePath+"/api/drift/adopt", handler.AdoptDriftPeer(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.POST(util.BasePath+"/api/drift/remove", handler.RemoveDriftPeer(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.GET(util.BasePath+"/api/interfaces", handler.GetInterfaces(db), handler.ValidSession)
 	app.GET(util.BasePath+"/api/clients", handler.GetClients(db), handler.ValidSession)
 	app.GET(util.BasePath+"/api/client/:id", handler.GetClient(db), handler.ValidSession)
+	app.GET(util.BasePath+"/client/:id", handler.ClientPage(db), handler.ValidSession, handler.RefreshSession)
+	app.GET(util.BasePath+"/api/client/:id/traffic", handler.GetClientTraffic(db), handler.ValidSession)
+	app.GET(util.BasePath+"/api/client/:id/history", handler.GetClientHistory(db), handler.ValidSession)
+	app.GET(util.BasePath+"/api/client/:id/diff", handler.GetClientVersionDiff(db), handler.ValidSession)
+	app.POST(util.BasePath+"/api/client/:id/revert", handler.RevertClient(db), handler.ValidSession, handler.ContentTypeJson)
 	app.GET(util.BasePath+"/api/machine-ips", handler.MachineIPAddresses(), handler.ValidSession)
 	app.GET(util.BasePath+"/api/subnet-ranges", handler.GetOrderedSubnetRanges(), handler.ValidSession)
 	app.GET(util.BasePath+"/api/suggest-client-ips", handler.SuggestIPAllocation(db), handler.ValidSession)
+	app.GET(util.BasePath+"/api/apply-wg-config/preview", handler.PreviewServerConfig(db, tmplDir), handler.ValidSession)
 	app.POST(util.BasePath+"/api/apply-wg-config", handler.ApplyServerConfig(db, tmplDir), handler.ValidSession, handler.ContentTypeJson)
 	app.GET(util.BasePath+"/wake_on_lan_hosts", handler.GetWakeOnLanHosts(db), handler.ValidSession, handler.RefreshSession)
 	app.POST(util.BasePath+"/wake_on_lan_host", handler.SaveWakeOnLanHost(db), handler.ValidSession, handler.ContentTypeJson)
 	app.DELETE(util.BasePath+"/wake_on_lan_host/:mac_address", handler.DeleteWakeOnHost(db), handler.ValidSession, handler.ContentTypeJson)
 	app.PUT(util.BasePath+"/wake_on_lan_host/:mac_address", handler.WakeOnHost(db), handler.ValidSession, handler.ContentTypeJson)
+	app.GET(util.BasePath+"/backup", handler.BackupPage(), handler.ValidSession, handler.RefreshSession, handler.NeedsAdmin)
+	app.GET(util.BasePath+"/api/backup", handler.DownloadBackup(db), handler.ValidSession, handler.NeedsAdmin)
+	app.POST(util.BasePath+"/api/restore/preview", handler.PreviewRestore(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.POST(util.BasePath+"/api/restore", handler.RestoreBackup(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.POST(util.BasePath+"/api/import/server-config", handler.ImportServerConfig(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.POST(util.BasePath+"/api/import/client-configs", handler.ImportClientConfigs(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.GET(util.BasePath+"/api/config-backups", handler.GetConfigBackups(db), handler.ValidSession, handler.NeedsAdmin)
+	app.GET(util.BasePath+"/api/config-backups/:name/diff", handler.GetConfigBackupDiff(db), handler.ValidSession, handler.NeedsAdmin)
+	app.POST(util.BasePath+"/api/config-backups/:name/rollback", handler.RollbackConfigBackup(db), handler.ValidSession, handler.ContentTypeJson, handler.NeedsAdmin)
+	app.GET(util.BasePath+"/audit-log", handler.AuditLogPage(), handler.ValidSession, handler.RefreshSession, handler.NeedsAdmin)
+	app.GET(util.BasePath+"/api/audit-logs", handler.GetAuditLogs(db), handler.ValidSession, handler.NeedsAdmin)
 
 	// strip the "assets/" prefix from the embedded directory so files can be called directly without the "assets/"
 	// prefix
@@ -295,18 +457,32 @@ func main() {
 	}
 }
 
+// openStore to create the store.IStore implementation selected by dbType, located at dbPath. An empty dbPath selects
+// the configured default location for that backend.
+func openStore(dbType, dbPath string) (store.IStore, error) {
+	switch dbType {
+	case "jsondb":
+		if dbPath == "" {
+			dbPath = flagDBPath
+		}
+		return jsondb.New(dbPath)
+	case "sqlite":
+		if dbPath == "" {
+			dbPath = flagSqlitePath
+		}
+		return sqlite.New(dbPath)
+	default:
+		return nil, fmt.Errorf("unknown database type %q, must be jsondb or sqlite", dbType)
+	}
+}
+
 func initServerConfig(db store.IStore, tmplDir fs.FS) {
 	settings, err := db.GetGlobalSettings()
 	if err != nil {
 		log.Fatalf("Cannot get global settings: %v", err)
 	}
 
-	if _, err := os.Stat(settings.ConfigFilePath); err == nil {
-		// file exists, don't overwrite it implicitly
-		return
-	}
-
-	server, err := db.GetServer()
+	servers, err := db.GetServers()
 	if err != nil {
 		log.Fatalf("Cannot get server config: %v", err)
 	}
@@ -321,10 +497,17 @@ func initServerConfig(db store.IStore, tmplDir fs.FS) {
 		log.Fatalf("Cannot get user config: %v", err)
 	}
 
-	// write config file
-	err = util.WriteWireGuardServerConfig(tmplDir, server, clients, users, settings)
-	if err != nil {
-		log.Fatalf("Cannot create server config: %v", err)
+	for _, server := range servers {
+		if _, err := os.Stat(server.Interface.ConfigFilePath); err == nil {
+			// file exists, don't overwrite it implicitly
+			continue
+		}
+
+		// write config file
+		err = util.WriteWireGuardServerConfig(tmplDir, server, clients, users, settings)
+		if err != nil {
+			log.Fatalf("Cannot create server config: %v", err)
+		}
 	}
 }
 
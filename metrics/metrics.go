@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/labstack/echo-contrib/echoprometheus"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/traffic"
+)
+
+// Namespace prefixes the names of the metrics of wireguard-ui
+const Namespace = "wgui"
+
+// connectedTimeout is the time since the last handshake a peer counts as connected, like on the status page
+const connectedTimeout = 3 * time.Minute
+
+var peerLabels = []string{"interface", "public_key", "client_id", "client_name"}
+
+// Collector exports the clients from the store and the peers of the running interfaces, which are read on every
+// scrape. Peers without a client are exported with empty client labels.
+type Collector struct {
+	db store.IStore
+
+	clients       *prometheus.Desc
+	pendingApply  *prometheus.Desc
+	interfaceUp   *prometheus.Desc
+	receivedBytes *prometheus.Desc
+	transmitBytes *prometheus.Desc
+	lastHandshake *prometheus.Desc
+	connected     *prometheus.Desc
+}
+
+// NewCollector returns a Collector reading from db, which has to be registered with a prometheus.Registerer
+func NewCollector(db store.IStore) *Collector {
+	return &Collector{
+		db: db,
+		clients: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", "clients"),
+			"Number of clients by interface and status.", []string{"interface", "status"}, nil),
+		pendingApply: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", "config_pending_apply"),
+			"Whether the configuration has changes which are not applied yet.", nil, nil),
+		interfaceUp: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", "interface_up"),
+			"Whether the interface is running.", []string{"interface"}, nil),
+		receivedBytes: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "peer", "received_bytes_total"),
+			"Bytes received from the peer since it was added to the interface.", peerLabels, nil),
+		transmitBytes: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "peer", "transmit_bytes_total"),
+			"Bytes transmitted to the peer since it was added to the interface.", peerLabels, nil),
+		lastHandshake: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "peer", "last_handshake_seconds"),
+			"Unix time of the last handshake of the peer, 0 if there was none.", peerLabels, nil),
+		connected: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "peer", "connected"),
+			"Whether the peer had a handshake within the last 3 minutes.", peerLabels, nil),
+	}
+}
+
+// MustRegister registers a Collector reading from db with the default registry, which Handler exports
+func MustRegister(db store.IStore) {
+	prometheus.MustRegister(NewCollector(db))
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.clients
+	ch <- c.pendingApply
+	ch <- c.interfaceUp
+	ch <- c.receivedBytes
+	ch <- c.transmitBytes
+	ch <- c.lastHandshake
+	ch <- c.connected
+}
+
+// Collect implements prometheus.Collector. Metrics which cannot be read are left out and the error is logged, so the
+// others are still exported.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	servers, err := c.db.GetServers()
+	if err != nil {
+		log.Error("Cannot get the servers for the metrics: ", err)
+		return
+	}
+
+	if revisions, err := c.db.GetConfigRevisions(); err != nil {
+		log.Error("Cannot get the config revisions for the metrics: ", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.pendingApply, prometheus.GaugeValue, boolValue(revisions.Pending()))
+	}
+
+	clients, err := c.db.GetClients(false)
+	if err != nil {
+		log.Error("Cannot get the clients for the metrics: ", err)
+		return
+	}
+	type peerKey struct {
+		iface     string
+		publicKey string
+	}
+	type peerClient struct {
+		id   string
+		name string
+	}
+	clientsByPeer := make(map[peerKey]peerClient, len(clients))
+	enabled := make(map[string]int, len(servers))
+	disabled := make(map[string]int, len(servers))
+	for _, clientData := range clients {
+		client := clientData.Client
+		if client.Enabled {
+			enabled[client.Interface]++
+		} else {
+			disabled[client.Interface]++
+		}
+		clientsByPeer[peerKey{client.Interface, client.PublicKey}] = peerClient{client.ID, client.Name}
+	}
+	for _, server := range servers {
+		name := server.Interface.Name
+		ch <- prometheus.MustNewConstMetric(c.clients, prometheus.GaugeValue, float64(enabled[name]), name, "enabled")
+		ch <- prometheus.MustNewConstMetric(c.clients, prometheus.GaugeValue, float64(disabled[name]), name, "disabled")
+	}
+
+	devices, err := traffic.ReadDevices(c.db)
+	if err != nil {
+		log.Warn("Cannot read the peers for the metrics: ", err)
+		return
+	}
+	now := time.Now()
+	for _, server := range servers {
+		name := server.Interface.Name
+		peers, up := devices[name]
+		ch <- prometheus.MustNewConstMetric(c.interfaceUp, prometheus.GaugeValue, boolValue(up), name)
+		for publicKey, peer := range peers {
+			client := clientsByPeer[peerKey{name, publicKey}]
+			labels := []string{name, publicKey, client.id, client.name}
+			handshake := 0.0
+			if !peer.LastHandshake.IsZero() {
+				handshake = float64(peer.LastHandshake.Unix())
+			}
+			connected := !peer.LastHandshake.IsZero() && now.Sub(peer.LastHandshake) < connectedTimeout
+			ch <- prometheus.MustNewConstMetric(c.receivedBytes, prometheus.CounterValue, float64(peer.ReceivedBytes), labels...)
+			ch <- prometheus.MustNewConstMetric(c.transmitBytes, prometheus.CounterValue, float64(peer.TransmitBytes), labels...)
+			ch <- prometheus.MustNewConstMetric(c.lastHandshake, prometheus.GaugeValue, handshake, labels...)
+			ch <- prometheus.MustNewConstMetric(c.connected, prometheus.GaugeValue, boolValue(connected), labels...)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Middleware returns a middleware recording the count, latency and sizes of the HTTP requests by route
+func Middleware() echo.MiddlewareFunc {
+	return echoprometheus.NewMiddlewareWithConfig(echoprometheus.MiddlewareConfig{
+		Namespace: Namespace,
+		Subsystem: "http",
+		LabelFuncs: map[string]echoprometheus.LabelValueFunc{
+			// the host is sent by the client, any value would add series. The label cannot be removed, an empty value
+			// is the same as no label to Prometheus.
+			"host": func(c echo.Context, err error) string {
+				return ""
+			},
+			// requests not matching a route are recorded together, so unknown paths do not add series
+			"url": func(c echo.Context, err error) string {
+				if c.Path() == "" {
+					return "unmatched"
+				}
+				return c.Path()
+			},
+		},
+	})
+}
+
+// Handler returns the handler exporting the metrics of the default registry, along with those of the Go runtime and
+// the process
+func Handler() echo.HandlerFunc {
+	return echoprometheus.NewHandler()
+}
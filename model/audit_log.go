@@ -0,0 +1,46 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// AuditLog is an administrative change, recorded with the user who made it
+type AuditLog struct {
+	ID      string        `json:"id"`
+	Time    time.Time     `json:"time"`
+	Actor   string        `json:"actor"`
+	Action  string        `json:"action"`
+	Target  string        `json:"target"`
+	Changes []AuditChange `json:"changes"`
+}
+
+// AuditChange is a field changed by an audited action. Secrets are redacted.
+type AuditChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// AuditLogFilter selects audit log entries. Empty fields match all entries.
+type AuditLogFilter struct {
+	Actor  string
+	Action string
+	// Target matches entries whose target contains it
+	Target string
+	Since  time.Time
+	Until  time.Time
+	// Limit is the maximum number of entries returned, newest first
+	Limit int
+}
+
+// Match to check whether the entry is selected by the filter, ignoring the limit
+func (f AuditLogFilter) Match(entry AuditLog) bool {
+	return (f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.Target == "" || strings.Contains(entry.Target, f.Target)) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.Time.Before(f.Until))
+}
+
+const AuditLogCollectionName = "audit_logs"
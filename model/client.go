@@ -23,12 +23,34 @@ type Client struct {
 	Enabled         bool      `json:"enabled"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
+	// Revision is incremented on every update and used to detect concurrent modifications
+	Revision int64 `json:"revision"`
+	// Interface is the name of the server interface the client is a peer of
+	Interface string `json:"interface"`
+	// ExpiresAt is the time after which the client is disabled automatically, nil if it does not expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Quota limits the data volume of the client, nil if it is unlimited
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// Expired returns whether the client has an expiry date which is not after now
+func (c Client) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !c.ExpiresAt.After(now)
+}
+
+// ClientVersion is a snapshot of a client, saved by the store each time the client is saved
+type ClientVersion struct {
+	Revision int64     `json:"revision"`
+	SavedAt  time.Time `json:"saved_at"`
+	Client   Client    `json:"client"`
 }
 
 // ClientData includes the Client and extra data
 type ClientData struct {
 	Client *Client
 	QRCode string
+	// Usage is the data volume of the client within the current period, if it was collected
+	Usage *TrafficUsage `json:",omitempty"`
 }
 
 type QRCodeSettings struct {
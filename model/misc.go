@@ -13,8 +13,15 @@ type BaseData struct {
 	Admin       bool
 }
 
-// ClientServerHashes struct, to save hashes to detect changes
-type ClientServerHashes struct {
-	Client string `json:"client"`
-	Server string `json:"server"`
+// ConfigRevisions struct, to detect changes which have not been applied yet
+type ConfigRevisions struct {
+	// Current is increased by every change to the users, clients, server or global settings
+	Current uint64 `json:"current"`
+	// Applied is the current revision at the time the config was last applied
+	Applied uint64 `json:"applied"`
+}
+
+// Pending reports whether there are changes which have not been applied
+func (r ConfigRevisions) Pending() bool {
+	return r.Applied < r.Current
 }
@@ -4,7 +4,7 @@ import (
 	"time"
 )
 
-// Server model
+// Server model, a WireGuard interface with its key pair. Every client is a peer of one server.
 type Server struct {
 	KeyPair   *ServerKeypair
 	Interface *ServerInterface
@@ -19,10 +19,16 @@ type ServerKeypair struct {
 
 // ServerInterface model
 type ServerInterface struct {
+	// Name is the name of the WireGuard interface, e.g. wg0, and identifies the server
+	Name       string    `json:"name"`
 	Addresses  []string  `json:"addresses"`
 	ListenPort int       `json:"listen_port,string"` // ,string to get listen_port string input as int
 	UpdatedAt  time.Time `json:"updated_at"`
 	PostUp     string    `json:"post_up"`
 	PreDown    string    `json:"pre_down"`
 	PostDown   string    `json:"post_down"`
+	// ConfigFilePath is the WireGuard config file written for the interface
+	ConfigFilePath string `json:"config_file_path"`
+	// EndpointAddress is the address clients connect to, empty to use the endpoint address of the global settings
+	EndpointAddress string `json:"endpoint_address"`
 }
@@ -6,12 +6,14 @@ import (
 
 // GlobalSetting model
 type GlobalSetting struct {
+	// EndpointAddress is the address clients connect to, unless their interface has its own
 	EndpointAddress     string    `json:"endpoint_address"`
 	DNSServers          []string  `json:"dns_servers"`
 	MTU                 int       `json:"mtu,string"`
 	PersistentKeepalive int       `json:"persistent_keepalive,string"`
 	FirewallMark        string    `json:"firewall_mark"`
 	Table               string    `json:"table"`
-	ConfigFilePath      string    `json:"config_file_path"`
 	UpdatedAt           time.Time `json:"updated_at"`
+	// Revision is incremented on every update and used to detect concurrent modifications
+	Revision int64 `json:"revision"`
 }
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"time"
+)
+
+// Quota periods
+const (
+	QuotaPeriodDay   = "day"
+	QuotaPeriodWeek  = "week"
+	QuotaPeriodMonth = "month"
+)
+
+// Quota actions
+const (
+	// QuotaActionDisable disables the client until the next period and notifies it
+	QuotaActionDisable = "disable"
+	// QuotaActionNotify only notifies the client
+	QuotaActionNotify = "notify"
+)
+
+// Quota limits the data volume of a client per period
+type Quota struct {
+	// Bytes is the data volume received and transmitted within a period
+	Bytes  int64  `json:"bytes"`
+	Period string `json:"period"`
+	// Action is taken once the client exceeded the quota within a period
+	Action string `json:"action"`
+}
+
+// PeriodStart returns the start of the period now is in, in the location of now. Weeks start on Monday, an unknown
+// period is counted as month.
+func (q Quota) PeriodStart(now time.Time) time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch q.Period {
+	case QuotaPeriodDay:
+		return day
+	case QuotaPeriodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	default:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+}
+
+// TrafficUsage is the data volume of a client within the current period, accumulated from the counters of its peer
+type TrafficUsage struct {
+	ClientID    string    `json:"client_id"`
+	PeriodStart time.Time `json:"period_start"`
+	// ReceivedBytes and TransmitBytes are counted from the server's side, like the counters of the peer
+	ReceivedBytes int64 `json:"received_bytes"`
+	TransmitBytes int64 `json:"transmit_bytes"`
+	// PublicKey, PeerReceivedBytes and PeerTransmitBytes are the peer and its counters at the last collection. The
+	// counters start at zero again when the interface is restarted or the peer is added again.
+	PublicKey         string `json:"public_key"`
+	PeerReceivedBytes int64  `json:"peer_received_bytes"`
+	PeerTransmitBytes int64  `json:"peer_transmit_bytes"`
+	// Exceeded is set once the client exceeded its quota within the period, so the action is taken only once
+	Exceeded bool `json:"exceeded"`
+	// Disabled is set if the client was disabled for exceeding its quota, to enable it again in the next period
+	Disabled  bool      `json:"disabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Total returns the data volume received and transmitted within the period
+func (u TrafficUsage) Total() int64 {
+	return u.ReceivedBytes + u.TransmitBytes
+}
+
+const TrafficUsageCollectionName = "traffic_usages"
+
+// TrafficSample is the traffic of a client within a sampled interval, and the state of its peer at the end of it
+type TrafficSample struct {
+	ClientID string `json:"client_id"`
+	// Time is the end of the interval of a raw sample, or the start of the hour of an hourly sample
+	Time time.Time `json:"time"`
+	// Hourly is set for samples merged from the raw samples of an hour, once they are older than the raw retention
+	Hourly        bool      `json:"hourly"`
+	ReceivedBytes int64     `json:"received_bytes"`
+	TransmitBytes int64     `json:"transmit_bytes"`
+	LastHandshake time.Time `json:"last_handshake"`
+	Endpoint      string    `json:"endpoint"`
+}
+
+// TrafficSampleFilter selects traffic samples. Empty fields match all samples.
+type TrafficSampleFilter struct {
+	ClientID string
+	Since    time.Time
+	Until    time.Time
+	// RawOnly leaves out the hourly samples
+	RawOnly bool
+}
+
+// Match to check whether the sample is selected by the filter
+func (f TrafficSampleFilter) Match(sample TrafficSample) bool {
+	return (f.ClientID == "" || sample.ClientID == f.ClientID) &&
+		(f.Since.IsZero() || !sample.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || sample.Time.Before(f.Until)) &&
+		(!f.RawOnly || !sample.Hourly)
+}
+
+const TrafficSampleCollectionName = "traffic_samples"
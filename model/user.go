@@ -7,4 +7,6 @@ type User struct {
 	// PasswordHash takes precedence over Password.
 	PasswordHash string `json:"password_hash"`
 	Admin        bool   `json:"admin"`
+	// Revision is incremented on every update and used to detect concurrent modifications
+	Revision int64 `json:"revision"`
 }
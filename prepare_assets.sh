@@ -25,5 +25,6 @@ mkdir -p "${DIR}/assets/plugins" && \
   "${DIR}/node_modules/admin-lte/plugins/toastr" \
   "${DIR}/node_modules/admin-lte/plugins/jquery-validation" \
   "${DIR}/node_modules/admin-lte/plugins/select2" \
+  "${DIR}/node_modules/admin-lte/plugins/chart.js" \
   "${DIR}/node_modules/jquery-tags-input" \
   "${DIR}/assets/plugins/"
@@ -101,11 +101,31 @@ func New(tmplDir fs.FS, extraData map[string]interface{}, secret [64]byte) *echo
 		log.Fatal(err)
 	}
 
+	tmplClientString, err := util.StringFromEmbedFile(tmplDir, "client.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tmplDriftString, err := util.StringFromEmbedFile(tmplDir, "drift.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	tmplWakeOnLanHostsString, err := util.StringFromEmbedFile(tmplDir, "wake_on_lan_hosts.html")
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	tmplBackupString, err := util.StringFromEmbedFile(tmplDir, "backup.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tmplAuditLogString, err := util.StringFromEmbedFile(tmplDir, "audit_log.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	aboutPageString, err := util.StringFromEmbedFile(tmplDir, "about.html")
 	if err != nil {
 		log.Fatal(err)
@@ -119,11 +139,15 @@ func New(tmplDir fs.FS, extraData map[string]interface{}, secret [64]byte) *echo
 	templates["login.html"] = template.Must(template.New("login").Funcs(funcs).Parse(tmplLoginString))
 	templates["profile.html"] = template.Must(template.New("profile").Funcs(funcs).Parse(tmplBaseString + tmplProfileString))
 	templates["clients.html"] = template.Must(template.New("clients").Funcs(funcs).Parse(tmplBaseString + tmplClientsString))
+	templates["client.html"] = template.Must(template.New("client").Funcs(funcs).Parse(tmplBaseString + tmplClientString))
 	templates["server.html"] = template.Must(template.New("server").Funcs(funcs).Parse(tmplBaseString + tmplServerString))
 	templates["global_settings.html"] = template.Must(template.New("global_settings").Funcs(funcs).Parse(tmplBaseString + tmplGlobalSettingsString))
 	templates["users_settings.html"] = template.Must(template.New("users_settings").Funcs(funcs).Parse(tmplBaseString + tmplUsersSettingsString))
 	templates["status.html"] = template.Must(template.New("status").Funcs(funcs).Parse(tmplBaseString + tmplStatusString))
+	templates["drift.html"] = template.Must(template.New("drift").Funcs(funcs).Parse(tmplBaseString + tmplDriftString))
 	templates["wake_on_lan_hosts.html"] = template.Must(template.New("wake_on_lan_hosts").Funcs(funcs).Parse(tmplBaseString + tmplWakeOnLanHostsString))
+	templates["backup.html"] = template.Must(template.New("backup").Funcs(funcs).Parse(tmplBaseString + tmplBackupString))
+	templates["audit_log.html"] = template.Must(template.New("audit_log").Funcs(funcs).Parse(tmplBaseString + tmplAuditLogString))
 	templates["about.html"] = template.Must(template.New("about").Funcs(funcs).Parse(tmplBaseString + aboutPageString))
 
 	lvl, err := util.ParseLogLevel(util.LookupEnvOrString(util.LogLevel, "INFO"))
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+)
+
+// CopyResult holds the number of records copied by Copy
+type CopyResult struct {
+	Users          int
+	Interfaces     int
+	Clients        int
+	WakeOnLanHosts int
+	AuditLogs      int
+}
+
+// Copy to copy all data from src to dst through the IStore interface, then verify that both stores hold the same
+// number of records. Both stores must be initialized, and dst must not contain any clients or wake on lan hosts yet.
+func Copy(src, dst IStore) (CopyResult, error) {
+	var result CopyResult
+
+	// refuse to merge into a store which is already in use
+	dstClients, err := dst.GetClients(false)
+	if err != nil {
+		return result, fmt.Errorf("cannot read target clients: %v", err)
+	}
+	dstHosts, err := dst.GetWakeOnLanHosts()
+	if err != nil {
+		return result, fmt.Errorf("cannot read target wake on lan hosts: %v", err)
+	}
+	if len(dstClients) > 0 || len(dstHosts) > 0 {
+		return result, fmt.Errorf("target store is not empty: %d clients, %d wake on lan hosts", len(dstClients), len(dstHosts))
+	}
+
+	// users, replacing the default user created by the target's Init
+	users, err := src.GetUsers()
+	if err != nil {
+		return result, fmt.Errorf("cannot read users: %v", err)
+	}
+	srcUsernames := make(map[string]bool, len(users))
+	for _, user := range users {
+		if err := dst.SaveUser(user); err != nil {
+			return result, fmt.Errorf("cannot save user %s: %v", user.Username, err)
+		}
+		srcUsernames[user.Username] = true
+	}
+	dstUsers, err := dst.GetUsers()
+	if err != nil {
+		return result, fmt.Errorf("cannot read target users: %v", err)
+	}
+	for _, user := range dstUsers {
+		if !srcUsernames[user.Username] {
+			if err := dst.DeleteUser(user.Username); err != nil {
+				return result, fmt.Errorf("cannot remove target user %s: %v", user.Username, err)
+			}
+		}
+	}
+
+	// server interfaces and key pairs, replacing the default interface created by the target's Init
+	servers, err := src.GetServers()
+	if err != nil {
+		return result, fmt.Errorf("cannot read servers: %v", err)
+	}
+	srcKeyPairs := make(map[string]model.ServerKeypair, len(servers))
+	for _, server := range servers {
+		if err := dst.SaveServerInterface(*server.Interface); err != nil {
+			return result, fmt.Errorf("cannot save server interface %s: %v", server.Interface.Name, err)
+		}
+		if err := dst.SaveServerKeyPair(server.Interface.Name, *server.KeyPair); err != nil {
+			return result, fmt.Errorf("cannot save key pair of server interface %s: %v", server.Interface.Name, err)
+		}
+		srcKeyPairs[server.Interface.Name] = *server.KeyPair
+	}
+	dstServers, err := dst.GetServers()
+	if err != nil {
+		return result, fmt.Errorf("cannot read target servers: %v", err)
+	}
+	for _, server := range dstServers {
+		if _, found := srcKeyPairs[server.Interface.Name]; !found {
+			if err := dst.DeleteServer(server.Interface.Name); err != nil {
+				return result, fmt.Errorf("cannot remove target server interface %s: %v", server.Interface.Name, err)
+			}
+		}
+	}
+
+	// global settings
+	globalSettings, err := src.GetGlobalSettings()
+	if err != nil {
+		return result, fmt.Errorf("cannot read global settings: %v", err)
+	}
+	if err := dst.SaveGlobalSettings(globalSettings); err != nil {
+		return result, fmt.Errorf("cannot save global settings: %v", err)
+	}
+
+	// clients
+	clients, err := src.GetClients(false)
+	if err != nil {
+		return result, fmt.Errorf("cannot read clients: %v", err)
+	}
+	for _, clientData := range clients {
+		if err := dst.SaveClient(*clientData.Client); err != nil {
+			return result, fmt.Errorf("cannot save client %s: %v", clientData.Client.ID, err)
+		}
+		versions, err := src.GetClientVersions(clientData.Client.ID)
+		if err != nil {
+			return result, fmt.Errorf("cannot read versions of client %s: %v", clientData.Client.ID, err)
+		}
+		for _, version := range versions {
+			if err := dst.SaveClientVersion(version); err != nil {
+				return result, fmt.Errorf("cannot save version %d of client %s: %v", version.Revision, clientData.Client.ID, err)
+			}
+		}
+	}
+
+	// traffic usages and samples, after the clients they belong to
+	usages, err := src.GetTrafficUsages()
+	if err != nil {
+		return result, fmt.Errorf("cannot read traffic usages: %v", err)
+	}
+	for _, usage := range usages {
+		if err := dst.SaveTrafficUsage(usage); err != nil {
+			return result, fmt.Errorf("cannot save traffic usage of client %s: %v", usage.ClientID, err)
+		}
+	}
+	samples, err := src.GetTrafficSamples(model.TrafficSampleFilter{})
+	if err != nil {
+		return result, fmt.Errorf("cannot read traffic samples: %v", err)
+	}
+	if err := dst.SaveTrafficSamples(samples); err != nil {
+		return result, fmt.Errorf("cannot save traffic samples: %v", err)
+	}
+
+	// wake on lan hosts
+	hosts, err := src.GetWakeOnLanHosts()
+	if err != nil {
+		return result, fmt.Errorf("cannot read wake on lan hosts: %v", err)
+	}
+	for _, host := range hosts {
+		if err := dst.SaveWakeOnLanHost(host); err != nil {
+			return result, fmt.Errorf("cannot save wake on lan host %s: %v", host.MacAddress, err)
+		}
+	}
+
+	// audit log
+	auditLogs, err := src.GetAuditLogs(model.AuditLogFilter{})
+	if err != nil {
+		return result, fmt.Errorf("cannot read audit log: %v", err)
+	}
+	for _, entry := range auditLogs {
+		if err := dst.SaveAuditLog(entry); err != nil {
+			return result, fmt.Errorf("cannot save audit log entry %s: %v", entry.ID, err)
+		}
+	}
+
+	// config revisions, the target counts its own changes, so only whether the source was applied is kept
+	srcRevisions, err := src.GetConfigRevisions()
+	if err != nil {
+		return result, fmt.Errorf("cannot read config revisions: %v", err)
+	}
+	if !srcRevisions.Pending() {
+		dstRevisions, err := dst.GetConfigRevisions()
+		if err != nil {
+			return result, fmt.Errorf("cannot read config revisions: %v", err)
+		}
+		if err := dst.SaveAppliedConfigRevision(dstRevisions.Current); err != nil {
+			return result, fmt.Errorf("cannot save config revisions: %v", err)
+		}
+	}
+
+	// verify
+	if dstUsers, err = dst.GetUsers(); err != nil {
+		return result, fmt.Errorf("cannot verify users: %v", err)
+	}
+	if dstClients, err = dst.GetClients(false); err != nil {
+		return result, fmt.Errorf("cannot verify clients: %v", err)
+	}
+	if dstHosts, err = dst.GetWakeOnLanHosts(); err != nil {
+		return result, fmt.Errorf("cannot verify wake on lan hosts: %v", err)
+	}
+	dstAuditLogs, err := dst.GetAuditLogs(model.AuditLogFilter{})
+	if err != nil {
+		return result, fmt.Errorf("cannot verify audit log: %v", err)
+	}
+	if dstServers, err = dst.GetServers(); err != nil {
+		return result, fmt.Errorf("cannot verify servers: %v", err)
+	}
+	result = CopyResult{Users: len(dstUsers), Interfaces: len(dstServers), Clients: len(dstClients), WakeOnLanHosts: len(dstHosts), AuditLogs: len(dstAuditLogs)}
+	if result.Users != len(users) {
+		return result, fmt.Errorf("user count mismatch: source %d, target %d", len(users), result.Users)
+	}
+	if result.Clients != len(clients) {
+		return result, fmt.Errorf("client count mismatch: source %d, target %d", len(clients), result.Clients)
+	}
+	if result.WakeOnLanHosts != len(hosts) {
+		return result, fmt.Errorf("wake on lan host count mismatch: source %d, target %d", len(hosts), result.WakeOnLanHosts)
+	}
+	if result.AuditLogs != len(auditLogs) {
+		return result, fmt.Errorf("audit log count mismatch: source %d, target %d", len(auditLogs), result.AuditLogs)
+	}
+	if result.Interfaces != len(servers) {
+		return result, fmt.Errorf("interface count mismatch: source %d, target %d", len(servers), result.Interfaces)
+	}
+	for _, server := range dstServers {
+		if server.KeyPair.PublicKey != srcKeyPairs[server.Interface.Name].PublicKey {
+			return result, fmt.Errorf("key pair mismatch of server interface %s after copy", server.Interface.Name)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,123 @@
+package jsondb
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// records is the part of scribble.Driver used by JsonDB, so Update can journal the writes made through it
+type records interface {
+	Read(collection, resource string, v interface{}) error
+	ReadAll(collection string) ([][]byte, error)
+	Write(collection, resource string, v interface{}) error
+	Delete(collection, resource string) error
+}
+
+// journal passes reads and writes on to the records, and keeps the content every file had before it was first
+// written or deleted, so Update can roll back the changes of a callback which fails
+type journal struct {
+	records
+	dbPath string
+	// originals holds the files changed so far by path, nil for files which did not exist
+	originals map[string]*originalFile
+	// createdDirs are the directories of new records which did not exist
+	createdDirs map[string]bool
+}
+
+type originalFile struct {
+	content []byte
+	mode    fs.FileMode
+}
+
+func newJournal(r records, dbPath string) *journal {
+	return &journal{records: r, dbPath: dbPath, originals: make(map[string]*originalFile), createdDirs: make(map[string]bool)}
+}
+
+// Write to remember the record before overwriting it. The config revisions are left out, as they are also written
+// outside of Update, and a revision counted for a change which was rolled back only causes an unneeded apply.
+func (j *journal) Write(collection, resource string, v interface{}) error {
+	if collection != "server" || resource != "config_revisions" {
+		if err := j.remember(filepath.Join(j.dbPath, collection, resource+".json")); err != nil {
+			return err
+		}
+	}
+	return j.records.Write(collection, resource, v)
+}
+
+// Delete to remember the record, or all records of the collection of that name, before deleting it
+func (j *journal) Delete(collection, resource string) error {
+	p := filepath.Join(j.dbPath, collection, resource)
+	if info, err := os.Stat(p); err == nil && info.IsDir() {
+		err := filepath.WalkDir(p, func(file string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			return j.remember(file)
+		})
+		if err != nil {
+			return err
+		}
+	} else if err := j.remember(p + ".json"); err != nil {
+		return err
+	}
+	return j.records.Delete(collection, resource)
+}
+
+func (j *journal) remember(file string) error {
+	if _, found := j.originals[file]; found {
+		return nil
+	}
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		j.originals[file] = nil
+		if _, err := os.Stat(filepath.Dir(file)); os.IsNotExist(err) {
+			j.createdDirs[filepath.Dir(file)] = true
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	j.originals[file] = &originalFile{content: content, mode: info.Mode().Perm()}
+	return nil
+}
+
+// rollback to restore the files changed since the journal was created, and remove the directories created for them
+func (j *journal) rollback() error {
+	var errs []error
+	for file, original := range j.originals {
+		if original == nil {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.WriteFile(file, original.content, original.mode); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.Chmod(file, original.mode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// a directory deleted before it was created again holds the restored files
+	for dir := range j.createdDirs {
+		if entries, err := os.ReadDir(dir); err != nil || len(entries) > 0 {
+			continue
+		}
+		if err := os.Remove(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -3,23 +3,41 @@ package jsondb
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/sdomino/scribble"
 	"github.com/skip2/go-qrcode"
-	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
 	"github.com/ngoduykhanh/wireguard-ui/util"
 )
 
 type JsonDB struct {
-	conn   *scribble.Driver
+	conn   records
 	dbPath string
+	// mu serializes Update calls
+	mu *sync.Mutex
+	// revisionMu serializes changes to the config revisions, which are also made outside of Update
+	revisionMu *sync.Mutex
+}
+
+// serverRecord is how a server is stored in the interfaces collection, one file per interface
+type serverRecord struct {
+	Interface model.ServerInterface `json:"interface"`
+	KeyPair   model.ServerKeypair   `json:"keypair"`
+}
+
+// jsonDBTx is the store handed to Update callbacks. It runs nested Update calls within the lock which is already
+// held instead of deadlocking.
+type jsonDBTx struct {
+	*JsonDB
 }
 
 // New returns a new pointer JsonDB
@@ -29,8 +47,10 @@ func New(dbPath string) (*JsonDB, error) {
 		return nil, err
 	}
 	ans := JsonDB{
-		conn:   conn,
-		dbPath: dbPath,
+		conn:       conn,
+		dbPath:     dbPath,
+		mu:         &sync.Mutex{},
+		revisionMu: &sync.Mutex{},
 	}
 	return &ans, nil
 }
@@ -38,12 +58,20 @@ func New(dbPath string) (*JsonDB, error) {
 func (o *JsonDB) Init() error {
 	var clientPath = path.Join(o.dbPath, "clients")
 	var serverPath = path.Join(o.dbPath, "server")
+	var interfacesPath = path.Join(o.dbPath, "interfaces")
 	var userPath = path.Join(o.dbPath, "users")
 	var wakeOnLanHostsPath = path.Join(o.dbPath, "wake_on_lan_hosts")
-	var serverInterfacePath = path.Join(serverPath, "interfaces.json")
-	var serverKeyPairPath = path.Join(serverPath, "keypair.json")
+	var auditLogsPath = path.Join(o.dbPath, model.AuditLogCollectionName)
+	var trafficUsagesPath = path.Join(o.dbPath, model.TrafficUsageCollectionName)
 	var globalSettingPath = path.Join(serverPath, "global_settings.json")
-	var hashesPath = path.Join(serverPath, "hashes.json")
+
+	// a database without a server interface has never been initialized and is created with the latest schema. Before
+	// the interfaces collection there was a single interface, stored in the server directory.
+	_, err := os.Stat(interfacesPath)
+	isNew := os.IsNotExist(err)
+	if _, err := os.Stat(path.Join(serverPath, "interfaces.json")); err == nil {
+		isNew = false
+	}
 
 	// create directories if they do not exist
 	if _, err := os.Stat(clientPath); os.IsNotExist(err) {
@@ -52,105 +80,67 @@ func (o *JsonDB) Init() error {
 	if _, err := os.Stat(serverPath); os.IsNotExist(err) {
 		os.MkdirAll(serverPath, os.ModePerm)
 	}
+	if _, err := os.Stat(interfacesPath); os.IsNotExist(err) {
+		os.MkdirAll(interfacesPath, os.ModePerm)
+	}
 	if _, err := os.Stat(userPath); os.IsNotExist(err) {
 		os.MkdirAll(userPath, os.ModePerm)
 	}
 	if _, err := os.Stat(wakeOnLanHostsPath); os.IsNotExist(err) {
 		os.MkdirAll(wakeOnLanHostsPath, os.ModePerm)
 	}
-
-	// server's interface
-	if _, err := os.Stat(serverInterfacePath); os.IsNotExist(err) {
-		serverInterface := new(model.ServerInterface)
-		serverInterface.Addresses = util.LookupEnvOrStrings(util.ServerAddressesEnvVar, []string{util.DefaultServerAddress})
-		serverInterface.ListenPort = util.LookupEnvOrInt(util.ServerListenPortEnvVar, util.DefaultServerPort)
-		serverInterface.PostUp = util.LookupEnvOrString(util.ServerPostUpScriptEnvVar, "")
-		serverInterface.PostDown = util.LookupEnvOrString(util.ServerPostDownScriptEnvVar, "")
-		serverInterface.UpdatedAt = time.Now().UTC()
-		o.conn.Write("server", "interfaces", serverInterface)
-		err := util.ManagePerms(serverInterfacePath)
-		if err != nil {
-			return err
-		}
+	if _, err := os.Stat(auditLogsPath); os.IsNotExist(err) {
+		os.MkdirAll(auditLogsPath, os.ModePerm)
+	}
+	if _, err := os.Stat(trafficUsagesPath); os.IsNotExist(err) {
+		os.MkdirAll(trafficUsagesPath, os.ModePerm)
 	}
 
-	// server's key pair
-	if _, err := os.Stat(serverKeyPairPath); os.IsNotExist(err) {
-		key, err := wgtypes.GeneratePrivateKey()
+	// global settings
+	if _, err := os.Stat(globalSettingPath); os.IsNotExist(err) {
+		globalSetting, err := util.GlobalSettingsDefaultsFromEnv()
 		if err != nil {
-			return scribble.ErrMissingCollection
+			return err
 		}
-		serverKeyPair := new(model.ServerKeypair)
-		serverKeyPair.PrivateKey = key.String()
-		serverKeyPair.PublicKey = key.PublicKey().String()
-		serverKeyPair.UpdatedAt = time.Now().UTC()
-		o.conn.Write("server", "keypair", serverKeyPair)
-		err = util.ManagePerms(serverKeyPairPath)
+		o.conn.Write("server", "global_settings", globalSetting)
+		err = util.ManagePerms(globalSettingPath)
 		if err != nil {
 			return err
 		}
 	}
 
-	// global settings
-	if _, err := os.Stat(globalSettingPath); os.IsNotExist(err) {
-		endpointAddress := util.LookupEnvOrString(util.EndpointAddressEnvVar, "")
-		if endpointAddress == "" {
-			// automatically find an external IP address
-			publicInterface, err := util.GetPublicIP()
-			if err != nil {
-				return err
-			}
-			endpointAddress = publicInterface.IPAddress
-		}
-
-		globalSetting := new(model.GlobalSetting)
-		globalSetting.EndpointAddress = endpointAddress
-		globalSetting.DNSServers = util.LookupEnvOrStrings(util.DNSEnvVar, []string{util.DefaultDNS})
-		globalSetting.MTU = util.LookupEnvOrInt(util.MTUEnvVar, util.DefaultMTU)
-		globalSetting.PersistentKeepalive = util.LookupEnvOrInt(util.PersistentKeepaliveEnvVar, util.DefaultPersistentKeepalive)
-		globalSetting.FirewallMark = util.LookupEnvOrString(util.FirewallMarkEnvVar, util.DefaultFirewallMark)
-		globalSetting.Table = util.LookupEnvOrString(util.TableEnvVar, util.DefaultTable)
-		globalSetting.ConfigFilePath = util.LookupEnvOrString(util.ConfigFilePathEnvVar, util.DefaultConfigFilePath)
-		globalSetting.UpdatedAt = time.Now().UTC()
-		o.conn.Write("server", "global_settings", globalSetting)
-		err := util.ManagePerms(globalSettingPath)
-		if err != nil {
+	// schema version
+	if isNew {
+		if err := o.saveSchemaVersion(len(migrations)); err != nil {
 			return err
 		}
+	} else if err := o.migrate(); err != nil {
+		return err
 	}
 
-	// hashes
-	if _, err := os.Stat(hashesPath); os.IsNotExist(err) {
-		clientServerHashes := new(model.ClientServerHashes)
-		clientServerHashes.Client = "none"
-		clientServerHashes.Server = "none"
-		o.conn.Write("server", "hashes", clientServerHashes)
-		err := util.ManagePerms(hashesPath)
+	// server's interface and key pair, after the migrations which move an existing one into the interfaces
+	if servers, err := o.GetServers(); err != nil {
+		return err
+	} else if len(servers) == 0 {
+		serverInterface := util.ServerInterfaceDefaultsFromEnv()
+		if err := o.SaveServerInterface(serverInterface); err != nil {
+			return err
+		}
+		serverKeyPair, err := util.GenerateServerKeyPair()
 		if err != nil {
 			return err
 		}
+		if err := o.SaveServerKeyPair(serverInterface.Name, serverKeyPair); err != nil {
+			return err
+		}
 	}
 
 	// user info
 	results, err := o.conn.ReadAll("users")
 	if err != nil || len(results) < 1 {
-		user := new(model.User)
-		user.Username = util.LookupEnvOrString(util.UsernameEnvVar, util.DefaultUsername)
-		user.Admin = util.DefaultIsAdmin
-		user.PasswordHash = util.LookupEnvOrString(util.PasswordHashEnvVar, "")
-		if user.PasswordHash == "" {
-			user.PasswordHash = util.LookupEnvOrFile(util.PasswordHashFileEnvVar, "")
-			if user.PasswordHash == "" {
-				plaintext := util.LookupEnvOrString(util.PasswordEnvVar, util.DefaultPassword)
-				if plaintext == util.DefaultPassword {
-					plaintext = util.LookupEnvOrFile(util.PasswordFileEnvVar, util.DefaultPassword)
-				}
-				hash, err := util.HashPassword(plaintext)
-				if err != nil {
-					return err
-				}
-				user.PasswordHash = hash
-			}
+		user, err := util.UserDefaultsFromEnv()
+		if err != nil {
+			return err
 		}
 
 		o.conn.Write("users", user.Username, user)
@@ -186,6 +176,29 @@ func (o *JsonDB) Init() error {
 	return nil
 }
 
+// Update func to run fn while holding the database write lock. Files are written one by one and journaled, so the
+// changes of fn are rolled back when it fails. They are not if the process dies while fn runs.
+func (o *JsonDB) Update(fn func(tx store.IStore) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	j := newJournal(o.conn, o.dbPath)
+	tx := *o
+	tx.conn = j
+	if err := fn(jsonDBTx{&tx}); err != nil {
+		if rollbackErr := j.rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rolling back the changes failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Update func to run fn within the lock held by the enclosing Update
+func (t jsonDBTx) Update(fn func(tx store.IStore) error) error {
+	return fn(t)
+}
+
 // GetUsers func to get all users from the database
 func (o *JsonDB) GetUsers() ([]model.User, error) {
 	var users []model.User
@@ -223,14 +236,20 @@ func (o *JsonDB) SaveUser(user model.User) error {
 	if err != nil {
 		return err
 	}
+	if output != nil {
+		return output
+	}
 	util.DBUsersToCRC32[user.Username] = util.GetDBUserCRC32(user)
-	return output
+	return o.bumpConfigRevision()
 }
 
 // DeleteUser func to remove user from the database
 func (o *JsonDB) DeleteUser(username string) error {
 	delete(util.DBUsersToCRC32, username)
-	return o.conn.Delete("users", username)
+	if err := o.conn.Delete("users", username); err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
 }
 
 // GetGlobalSettings func to query global settings from the database
@@ -239,25 +258,53 @@ func (o *JsonDB) GetGlobalSettings() (model.GlobalSetting, error) {
 	return settings, o.conn.Read("server", "global_settings", &settings)
 }
 
-// GetServer func to query Server settings from the database
-func (o *JsonDB) GetServer() (model.Server, error) {
-	server := model.Server{}
-	// read server interface information
-	serverInterface := model.ServerInterface{}
-	if err := o.conn.Read("server", "interfaces", &serverInterface); err != nil {
-		return server, err
+// GetServers func to query the servers of all interfaces from the database
+func (o *JsonDB) GetServers() ([]model.Server, error) {
+	var servers []model.Server
+	records, err := o.conn.ReadAll("interfaces")
+	if err != nil {
+		return servers, err
+	}
+	for _, f := range records {
+		record := serverRecord{}
+		if err := json.Unmarshal(f, &record); err != nil {
+			return servers, fmt.Errorf("cannot decode interface json structure: %v", err)
+		}
+		server, err := record.server()
+		if err != nil {
+			return servers, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// GetServer func to query the server of an interface from the database
+func (o *JsonDB) GetServer(name string) (model.Server, error) {
+	if name == "" {
+		servers, err := o.GetServers()
+		if err != nil {
+			return model.Server{}, err
+		}
+		if len(servers) == 0 {
+			return model.Server{}, errors.New("no interface found")
+		}
+		return servers[0], nil
 	}
 
-	// read server key pair information
-	serverKeyPair := model.ServerKeypair{}
-	if err := o.conn.Read("server", "keypair", &serverKeyPair); err != nil {
-		return server, err
+	record := serverRecord{}
+	if err := o.conn.Read("interfaces", name, &record); err != nil {
+		return model.Server{}, err
 	}
+	return record.server()
+}
 
-	// create Server object and return
-	server.Interface = &serverInterface
-	server.KeyPair = &serverKeyPair
-	return server, nil
+// server to decrypt the key pair and return the record as a Server
+func (r serverRecord) server() (model.Server, error) {
+	if err := util.DecryptServerKeyPair(&r.KeyPair); err != nil {
+		return model.Server{}, err
+	}
+	return model.Server{Interface: &r.Interface, KeyPair: &r.KeyPair}, nil
 }
 
 func (o *JsonDB) GetClients(hasQRCode bool) ([]model.ClientData, error) {
@@ -269,6 +316,16 @@ func (o *JsonDB) GetClients(hasQRCode bool) ([]model.ClientData, error) {
 		return clients, err
 	}
 
+	servers := make(map[string]model.Server)
+	var globalSettings model.GlobalSetting
+	if hasQRCode {
+		list, _ := o.GetServers()
+		for _, server := range list {
+			servers[server.Interface.Name] = server
+		}
+		globalSettings, _ = o.GetGlobalSettings()
+	}
+
 	// build the ClientData list
 	for _, f := range records {
 		client := model.Client{}
@@ -278,12 +335,12 @@ func (o *JsonDB) GetClients(hasQRCode bool) ([]model.ClientData, error) {
 		if err := json.Unmarshal(f, &client); err != nil {
 			return clients, fmt.Errorf("cannot decode client json structure: %v", err)
 		}
+		if err := util.DecryptClientKeys(&client); err != nil {
+			return clients, err
+		}
 
 		// generate client qrcode image in base64
-		if hasQRCode && client.PrivateKey != "" {
-			server, _ := o.GetServer()
-			globalSettings, _ := o.GetGlobalSettings()
-
+		if server, found := servers[client.Interface]; hasQRCode && found && client.PrivateKey != "" {
 			png, err := qrcode.Encode(util.BuildClientConfig(client, server, globalSettings), qrcode.Medium, 256)
 			if err == nil {
 				clientData.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
@@ -308,10 +365,12 @@ func (o *JsonDB) GetClientByID(clientID string, qrCodeSettings model.QRCodeSetti
 	if err := o.conn.Read("clients", clientID, &client); err != nil {
 		return clientData, err
 	}
+	if err := util.DecryptClientKeys(&client); err != nil {
+		return clientData, err
+	}
 
 	// generate client qrcode image in base64
-	if qrCodeSettings.Enabled && client.PrivateKey != "" {
-		server, _ := o.GetServer()
+	if server, err := o.GetServer(client.Interface); err == nil && qrCodeSettings.Enabled && client.PrivateKey != "" {
 		globalSettings, _ := o.GetGlobalSettings()
 		client := client
 		if !qrCodeSettings.IncludeDNS {
@@ -336,7 +395,11 @@ func (o *JsonDB) GetClientByID(clientID string, qrCodeSettings model.QRCodeSetti
 
 func (o *JsonDB) SaveClient(client model.Client) error {
 	clientPath := path.Join(path.Join(o.dbPath, "clients"), client.ID+".json")
-	output := o.conn.Write("clients", client.ID, client)
+	encrypted, err := util.EncryptClientKeys(client)
+	if err != nil {
+		return err
+	}
+	output := o.conn.Write("clients", client.ID, encrypted)
 	if output == nil {
 		if client.Enabled && len(client.TgUserid) > 0 {
 			if userid, err := strconv.ParseInt(client.TgUserid, 10, 64); err == nil {
@@ -348,36 +411,87 @@ func (o *JsonDB) SaveClient(client model.Client) error {
 	} else {
 		util.RemoveTgToClientID(client.ID)
 	}
-	err := util.ManagePerms(clientPath)
+	err = util.ManagePerms(clientPath)
 	if err != nil {
 		return err
 	}
-	return output
+	if output != nil {
+		return output
+	}
+	if err := o.SaveClientVersion(model.ClientVersion{Revision: client.Revision, SavedAt: time.Now().UTC(), Client: client}); err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
 }
 
 func (o *JsonDB) DeleteClient(clientID string) error {
 	util.RemoveTgToClientID(clientID)
-	return o.conn.Delete("clients", clientID)
+	if err := o.conn.Delete("clients", clientID); err != nil {
+		return err
+	}
+	if err := o.deleteClientVersions(clientID); err != nil {
+		return err
+	}
+	if err := o.deleteTrafficUsage(clientID); err != nil {
+		return err
+	}
+	if err := o.deleteTrafficSamples(clientID); err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
 }
 
 func (o *JsonDB) SaveServerInterface(serverInterface model.ServerInterface) error {
-	serverInterfacePath := path.Join(path.Join(o.dbPath, "server"), "interfaces.json")
-	output := o.conn.Write("server", "interfaces", serverInterface)
-	err := util.ManagePerms(serverInterfacePath)
-	if err != nil {
+	record, err := o.readServerRecord(serverInterface.Name)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	return output
+	record.Interface = serverInterface
+	if err := o.writeServerRecord(record); err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
 }
 
-func (o *JsonDB) SaveServerKeyPair(serverKeyPair model.ServerKeypair) error {
-	serverKeyPairPath := path.Join(path.Join(o.dbPath, "server"), "keypair.json")
-	output := o.conn.Write("server", "keypair", serverKeyPair)
-	err := util.ManagePerms(serverKeyPairPath)
+func (o *JsonDB) SaveServerKeyPair(name string, serverKeyPair model.ServerKeypair) error {
+	record, err := o.readServerRecord(name)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("unable to find interface %q", name)
+	}
 	if err != nil {
 		return err
 	}
-	return output
+	if record.KeyPair, err = util.EncryptServerKeyPair(serverKeyPair); err != nil {
+		return err
+	}
+	if err := o.writeServerRecord(record); err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
+}
+
+func (o *JsonDB) DeleteServer(name string) error {
+	if err := o.conn.Delete("interfaces", name); err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
+}
+
+// readServerRecord to read the stored record of an interface, with the key pair still encrypted. The error satisfies
+// os.IsNotExist if the interface does not exist.
+func (o *JsonDB) readServerRecord(name string) (serverRecord, error) {
+	record := serverRecord{}
+	if _, err := os.Stat(path.Join(o.dbPath, "interfaces", name+".json")); err != nil {
+		return record, err
+	}
+	return record, o.conn.Read("interfaces", name, &record)
+}
+
+func (o *JsonDB) writeServerRecord(record serverRecord) error {
+	if err := o.conn.Write("interfaces", record.Interface.Name, record); err != nil {
+		return err
+	}
+	return util.ManagePerms(path.Join(o.dbPath, "interfaces", record.Interface.Name+".json"))
 }
 
 func (o *JsonDB) SaveGlobalSettings(globalSettings model.GlobalSetting) error {
@@ -387,24 +501,48 @@ func (o *JsonDB) SaveGlobalSettings(globalSettings model.GlobalSetting) error {
 	if err != nil {
 		return err
 	}
-	return output
+	if output != nil {
+		return output
+	}
+	return o.bumpConfigRevision()
 }
 
 func (o *JsonDB) GetPath() string {
 	return o.dbPath
 }
 
-func (o *JsonDB) GetHashes() (model.ClientServerHashes, error) {
-	hashes := model.ClientServerHashes{}
-	return hashes, o.conn.Read("server", "hashes", &hashes)
+func (o *JsonDB) GetConfigRevisions() (model.ConfigRevisions, error) {
+	revisions := model.ConfigRevisions{}
+	if _, err := os.Stat(path.Join(o.dbPath, "server", "config_revisions.json")); os.IsNotExist(err) {
+		return revisions, nil
+	}
+	return revisions, o.conn.Read("server", "config_revisions", &revisions)
 }
 
-func (o *JsonDB) SaveHashes(hashes model.ClientServerHashes) error {
-	hashesPath := path.Join(path.Join(o.dbPath, "server"), "hashes.json")
-	output := o.conn.Write("server", "hashes", hashes)
-	err := util.ManagePerms(hashesPath)
+func (o *JsonDB) SaveAppliedConfigRevision(revision uint64) error {
+	return o.updateConfigRevisions(func(revisions *model.ConfigRevisions) {
+		revisions.Applied = revision
+	})
+}
+
+// bumpConfigRevision to count a change to the configuration
+func (o *JsonDB) bumpConfigRevision() error {
+	return o.updateConfigRevisions(func(revisions *model.ConfigRevisions) {
+		revisions.Current++
+	})
+}
+
+func (o *JsonDB) updateConfigRevisions(fn func(revisions *model.ConfigRevisions)) error {
+	o.revisionMu.Lock()
+	defer o.revisionMu.Unlock()
+
+	revisions, err := o.GetConfigRevisions()
 	if err != nil {
 		return err
 	}
-	return output
+	fn(&revisions)
+	if err := o.conn.Write("server", "config_revisions", revisions); err != nil {
+		return err
+	}
+	return util.ManagePerms(path.Join(o.dbPath, "server", "config_revisions.json"))
 }
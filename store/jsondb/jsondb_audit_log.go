@@ -0,0 +1,55 @@
+package jsondb
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// SaveAuditLog func to append an entry to the audit log
+func (o *JsonDB) SaveAuditLog(entry model.AuditLog) error {
+	auditLogPath := path.Join(path.Join(o.dbPath, model.AuditLogCollectionName), entry.ID+".json")
+	output := o.conn.Write(model.AuditLogCollectionName, entry.ID, entry)
+	err := util.ManagePerms(auditLogPath)
+	if err != nil {
+		return err
+	}
+
+	return output
+}
+
+// GetAuditLogs func to query the audit log entries selected by the filter, newest first
+func (o *JsonDB) GetAuditLogs(filter model.AuditLogFilter) ([]model.AuditLog, error) {
+	var entries []model.AuditLog
+
+	records, err := o.conn.ReadAll(model.AuditLogCollectionName)
+	if err != nil {
+		return entries, err
+	}
+
+	for _, f := range records {
+		entry := model.AuditLog{}
+		if err := json.Unmarshal(f, &entry); err != nil {
+			return entries, fmt.Errorf("cannot decode audit log json structure: %v", err)
+		}
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].ID > entries[j].ID
+		}
+		return entries[i].Time.After(entries[j].Time)
+	})
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	return entries, nil
+}
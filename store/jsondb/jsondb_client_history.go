@@ -0,0 +1,88 @@
+package jsondb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// clientVersionsCollection is the directory holding the versions of a client, one file per revision
+func clientVersionsCollection(clientID string) string {
+	return path.Join("client_versions", clientID)
+}
+
+// GetClientVersions func to query the saved versions of a client, newest first
+func (o *JsonDB) GetClientVersions(clientID string) ([]model.ClientVersion, error) {
+	var versions []model.ClientVersion
+
+	records, err := o.conn.ReadAll(clientVersionsCollection(clientID))
+	if os.IsNotExist(err) {
+		return versions, nil
+	}
+	if err != nil {
+		return versions, err
+	}
+
+	for _, f := range records {
+		version := model.ClientVersion{}
+		if err := json.Unmarshal(f, &version); err != nil {
+			return versions, fmt.Errorf("cannot decode client version json structure: %v", err)
+		}
+		if err := util.DecryptClientKeys(&version.Client); err != nil {
+			return versions, err
+		}
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Revision > versions[j].Revision
+	})
+	return versions, nil
+}
+
+// SaveClientVersion func to save a version of a client, replacing a saved version with the same revision, and remove
+// the oldest versions beyond util.ClientHistoryLimit
+func (o *JsonDB) SaveClientVersion(version model.ClientVersion) error {
+	collection := clientVersionsCollection(version.Client.ID)
+	resource := strconv.FormatInt(version.Revision, 10)
+
+	encrypted, err := util.EncryptClientKeys(version.Client)
+	if err != nil {
+		return err
+	}
+	version.Client = encrypted
+	if err := o.conn.Write(collection, resource, version); err != nil {
+		return err
+	}
+	if err := util.ManagePerms(path.Join(o.dbPath, collection, resource+".json")); err != nil {
+		return err
+	}
+
+	if util.ClientHistoryLimit <= 0 {
+		return nil
+	}
+	versions, err := o.GetClientVersions(version.Client.ID)
+	if err != nil {
+		return err
+	}
+	for i := util.ClientHistoryLimit; i < len(versions); i++ {
+		if err := o.conn.Delete(collection, strconv.FormatInt(versions[i].Revision, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteClientVersions func to remove all saved versions of a client
+func (o *JsonDB) deleteClientVersions(clientID string) error {
+	if _, err := os.Stat(path.Join(o.dbPath, clientVersionsCollection(clientID))); os.IsNotExist(err) {
+		return nil
+	}
+	return o.conn.Delete("client_versions", clientID)
+}
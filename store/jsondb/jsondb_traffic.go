@@ -0,0 +1,248 @@
+package jsondb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// GetTrafficUsages func to query the data volume collected of every client
+func (o *JsonDB) GetTrafficUsages() ([]model.TrafficUsage, error) {
+	var usages []model.TrafficUsage
+
+	records, err := o.conn.ReadAll(model.TrafficUsageCollectionName)
+	if err != nil {
+		return usages, err
+	}
+
+	for _, f := range records {
+		usage := model.TrafficUsage{}
+		if err := json.Unmarshal(f, &usage); err != nil {
+			return usages, fmt.Errorf("cannot decode traffic usage json structure: %v", err)
+		}
+		usages = append(usages, usage)
+	}
+
+	return usages, nil
+}
+
+// SaveTrafficUsage func to create or replace the data volume collected of a client
+func (o *JsonDB) SaveTrafficUsage(usage model.TrafficUsage) error {
+	if err := o.conn.Write(model.TrafficUsageCollectionName, usage.ClientID, usage); err != nil {
+		return err
+	}
+	return util.ManagePerms(path.Join(o.dbPath, model.TrafficUsageCollectionName, usage.ClientID+".json"))
+}
+
+// deleteTrafficUsage func to remove the data volume collected of a client
+func (o *JsonDB) deleteTrafficUsage(clientID string) error {
+	if _, err := os.Stat(path.Join(o.dbPath, model.TrafficUsageCollectionName, clientID+".json")); os.IsNotExist(err) {
+		return nil
+	}
+	return o.conn.Delete(model.TrafficUsageCollectionName, clientID)
+}
+
+// trafficSampleDayLayout names the files holding the samples of a client, one file per day
+const trafficSampleDayLayout = "2006-01-02"
+
+// trafficSamplesCollection is the directory holding the samples of a client
+func trafficSamplesCollection(clientID string) string {
+	return path.Join(model.TrafficSampleCollectionName, clientID)
+}
+
+// trafficSampleDays returns the days with samples of a client which may hold samples selected by the filter
+func (o *JsonDB) trafficSampleDays(clientID string, filter model.TrafficSampleFilter) ([]string, error) {
+	entries, err := os.ReadDir(path.Join(o.dbPath, trafficSamplesCollection(clientID)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var days []string
+	for _, entry := range entries {
+		day := strings.TrimSuffix(entry.Name(), ".json")
+		if !filter.Since.IsZero() && day < filter.Since.UTC().Format(trafficSampleDayLayout) {
+			continue
+		}
+		if !filter.Until.IsZero() && day > filter.Until.UTC().Format(trafficSampleDayLayout) {
+			continue
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// trafficSampleClients returns the clients with samples selected by the filter
+func (o *JsonDB) trafficSampleClients(filter model.TrafficSampleFilter) ([]string, error) {
+	if filter.ClientID != "" {
+		return []string{filter.ClientID}, nil
+	}
+	entries, err := os.ReadDir(path.Join(o.dbPath, model.TrafficSampleCollectionName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var clientIDs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			clientIDs = append(clientIDs, entry.Name())
+		}
+	}
+	return clientIDs, nil
+}
+
+// readTrafficSampleDay func to read the samples of a client on a day
+func (o *JsonDB) readTrafficSampleDay(clientID, day string) ([]model.TrafficSample, error) {
+	var samples []model.TrafficSample
+	err := o.conn.Read(trafficSamplesCollection(clientID), day, &samples)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode traffic samples json structure: %v", err)
+	}
+	return samples, nil
+}
+
+// writeTrafficSampleDay func to replace the samples of a client on a day, removing the file if there are none left
+func (o *JsonDB) writeTrafficSampleDay(clientID, day string, samples []model.TrafficSample) error {
+	collection := trafficSamplesCollection(clientID)
+	if len(samples) == 0 {
+		if _, err := os.Stat(path.Join(o.dbPath, collection, day+".json")); os.IsNotExist(err) {
+			return nil
+		}
+		return o.conn.Delete(collection, day)
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	if err := o.conn.Write(collection, day, samples); err != nil {
+		return err
+	}
+	return util.ManagePerms(path.Join(o.dbPath, collection, day+".json"))
+}
+
+// GetTrafficSamples func to query the traffic samples selected by the filter, oldest first
+func (o *JsonDB) GetTrafficSamples(filter model.TrafficSampleFilter) ([]model.TrafficSample, error) {
+	var samples []model.TrafficSample
+
+	clientIDs, err := o.trafficSampleClients(filter)
+	if err != nil {
+		return samples, err
+	}
+	for _, clientID := range clientIDs {
+		days, err := o.trafficSampleDays(clientID, filter)
+		if err != nil {
+			return samples, err
+		}
+		for _, day := range days {
+			daySamples, err := o.readTrafficSampleDay(clientID, day)
+			if err != nil {
+				return samples, err
+			}
+			for _, sample := range daySamples {
+				if filter.Match(sample) {
+					samples = append(samples, sample)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		if samples[i].Time.Equal(samples[j].Time) {
+			return samples[i].ClientID < samples[j].ClientID
+		}
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	return samples, nil
+}
+
+// SaveTrafficSamples func to add traffic samples, replacing those of the same client, time and resolution
+func (o *JsonDB) SaveTrafficSamples(samples []model.TrafficSample) error {
+	// group the samples by their file, to write each file once
+	type dayKey struct{ clientID, day string }
+	var keys []dayKey
+	byDay := make(map[dayKey][]model.TrafficSample)
+	for _, sample := range samples {
+		key := dayKey{sample.ClientID, sample.Time.UTC().Format(trafficSampleDayLayout)}
+		if _, found := byDay[key]; !found {
+			keys = append(keys, key)
+		}
+		byDay[key] = append(byDay[key], sample)
+	}
+
+	for _, key := range keys {
+		existing, err := o.readTrafficSampleDay(key.clientID, key.day)
+		if err != nil {
+			return err
+		}
+		for _, sample := range byDay[key] {
+			replaced := false
+			for i := range existing {
+				if existing[i].Time.Equal(sample.Time) && existing[i].Hourly == sample.Hourly {
+					existing[i] = sample
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				existing = append(existing, sample)
+			}
+		}
+		if err := o.writeTrafficSampleDay(key.clientID, key.day, existing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteTrafficSamples func to remove the traffic samples selected by the filter
+func (o *JsonDB) DeleteTrafficSamples(filter model.TrafficSampleFilter) error {
+	clientIDs, err := o.trafficSampleClients(filter)
+	if err != nil {
+		return err
+	}
+	for _, clientID := range clientIDs {
+		days, err := o.trafficSampleDays(clientID, filter)
+		if err != nil {
+			return err
+		}
+		for _, day := range days {
+			daySamples, err := o.readTrafficSampleDay(clientID, day)
+			if err != nil {
+				return err
+			}
+			kept := daySamples[:0]
+			for _, sample := range daySamples {
+				if !filter.Match(sample) {
+					kept = append(kept, sample)
+				}
+			}
+			if len(kept) == len(daySamples) {
+				continue
+			}
+			if err := o.writeTrafficSampleDay(clientID, day, kept); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteTrafficSamples func to remove all traffic samples of a client
+func (o *JsonDB) deleteTrafficSamples(clientID string) error {
+	if _, err := os.Stat(path.Join(o.dbPath, trafficSamplesCollection(clientID))); os.IsNotExist(err) {
+		return nil
+	}
+	return o.conn.Delete(model.TrafficSampleCollectionName, clientID)
+}
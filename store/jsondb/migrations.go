@@ -0,0 +1,582 @@
+package jsondb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// schemaVersion is stored in server/schema.json and records which migrations have been applied to the database
+type schemaVersion struct {
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// migration upgrades the database from the previous schema version to the next one
+type migration struct {
+	description string
+	apply       func(o *JsonDB) error
+}
+
+// migrations holds the ordered list of data migrations. The index of each entry plus one is the schema version it
+// brings the database to. Databases written before schema versioning was introduced are at version 0. Never edit or
+// reorder an existing entry, append a new one. Migrations work on the raw records rather than through the
+// store methods, which follow the latest schema.
+var migrations = []migration{
+	{"hash plaintext user passwords", migrateHashUserPasswords},
+	{"normalize client ip lists and drop stored subnet ranges", migrateNormalizeClients},
+	{"fill empty global settings with defaults", migrateGlobalSettingsDefaults},
+	{"save the first version of every client", migrateClientVersions},
+	{"replace the hashes of the clients and the server with config revisions", migrateConfigRevisions},
+	{"move the server interface and key pair into the interfaces collection", migrateInterfaces},
+	{"start the revisions of users, clients and global settings at 1", migrateFirstRevisions},
+}
+
+// backupDirName is the directory inside the database in which backups are taken before running migrations
+const backupDirName = "backups"
+
+// schemaVersionPath returns the path of the file holding the schema version
+func (o *JsonDB) schemaVersionPath() string {
+	return path.Join(o.dbPath, "server", "schema.json")
+}
+
+// getSchemaVersion to read the schema version of the database, which is 0 if it has never been recorded
+func (o *JsonDB) getSchemaVersion() (int, error) {
+	if _, err := os.Stat(o.schemaVersionPath()); os.IsNotExist(err) {
+		return 0, nil
+	}
+	version := schemaVersion{}
+	if err := o.conn.Read("server", "schema", &version); err != nil {
+		return 0, err
+	}
+	return version.Version, nil
+}
+
+// saveSchemaVersion to record the schema version of the database
+func (o *JsonDB) saveSchemaVersion(version int) error {
+	if err := o.conn.Write("server", "schema", schemaVersion{Version: version, UpdatedAt: time.Now().UTC()}); err != nil {
+		return err
+	}
+	return util.ManagePerms(o.schemaVersionPath())
+}
+
+// migrate to bring the database to the latest schema version. A backup of the database is taken before the first
+// migration runs.
+func (o *JsonDB) migrate() error {
+	current, err := o.getSchemaVersion()
+	if err != nil {
+		return fmt.Errorf("cannot read schema version: %v", err)
+	}
+	latest := len(migrations)
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than the latest supported version %d", current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	backupPath, err := o.Snapshot(fmt.Sprintf("schema-v%d", current))
+	if err != nil {
+		return fmt.Errorf("cannot back up database before migrating: %v", err)
+	}
+	log.Infof("Backed up database to %s", backupPath)
+
+	for i := current; i < latest; i++ {
+		log.Infof("Migrating database to schema version %d: %s", i+1, migrations[i].description)
+		if err := migrations[i].apply(o); err != nil {
+			return fmt.Errorf("migration to schema version %d failed, a backup is available in %s: %v", i+1, backupPath, err)
+		}
+		if err := o.saveSchemaVersion(i + 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Snapshot to copy all database files into a new directory among the backups, named after name and the current time
+func (o *JsonDB) Snapshot(name string) (string, error) {
+	base := path.Join(o.dbPath, backupDirName, fmt.Sprintf("%s-%s", name, time.Now().UTC().Format("20060102150405")))
+	if err := os.MkdirAll(path.Dir(base), 0700); err != nil {
+		return "", err
+	}
+	// snapshots taken within the same second are numbered, an existing one is never written to
+	dst := base
+	for i := 2; ; i++ {
+		err := os.Mkdir(dst, 0700)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		dst = fmt.Sprintf("%s-%d", base, i)
+	}
+	return dst, o.backup(dst)
+}
+
+// backup to copy all database files into dst, except for previous backups
+func (o *JsonDB) backup(dst string) error {
+	return filepath.WalkDir(o.dbPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(o.dbPath, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel == backupDirName {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0700)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(p, filepath.Join(dst, rel))
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// record is a database file as read and written by the migrations. A migration changes only the fields it is about
+// and keeps the others as they are stored, so it does the same whatever the model types look like when it runs.
+type record map[string]json.RawMessage
+
+// get to decode the field into v, which is left unchanged if the field is missing
+func (r record) get(field string, v interface{}) error {
+	raw, found := r[field]
+	if !found {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("cannot decode field %s: %v", field, err)
+	}
+	return nil
+}
+
+func (r record) set(field string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r[field] = raw
+	return nil
+}
+
+// isNull reports whether the field is missing or null
+func (r record) isNull(field string) bool {
+	raw, found := r[field]
+	return !found || string(raw) == "null"
+}
+
+// readRecord to read a record of a collection, found is false if it does not exist
+func (o *JsonDB) readRecord(collection, resource string) (r record, found bool, err error) {
+	if _, err := os.Stat(path.Join(o.dbPath, collection, resource+".json")); os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err := o.conn.Read(collection, resource, &r); err != nil {
+		return nil, false, fmt.Errorf("cannot read %s/%s: %v", collection, resource, err)
+	}
+	if r == nil {
+		r = record{}
+	}
+	return r, true, nil
+}
+
+// readRecords to read all records of a collection by resource name, none if the collection does not exist
+func (o *JsonDB) readRecords(collection string) (map[string]record, error) {
+	entries, err := os.ReadDir(path.Join(o.dbPath, collection))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]record, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		resource := strings.TrimSuffix(entry.Name(), ".json")
+		r, _, err := o.readRecord(collection, resource)
+		if err != nil {
+			return nil, err
+		}
+		records[resource] = r
+	}
+	return records, nil
+}
+
+func (o *JsonDB) writeRecord(collection, resource string, v interface{}) error {
+	if err := o.conn.Write(collection, resource, v); err != nil {
+		return err
+	}
+	return util.ManagePerms(path.Join(o.dbPath, collection, resource+".json"))
+}
+
+// migrateConfigRevision counts a change to apply in the config revisions record, like bumpConfigRevision
+func (o *JsonDB) migrateConfigRevision() error {
+	revisions, found, err := o.readRecord("server", "config_revisions")
+	if err != nil {
+		return err
+	}
+	if !found {
+		revisions = record{}
+	}
+	var current uint64
+	if err := revisions.get("current", &current); err != nil {
+		return err
+	}
+	if err := revisions.set("current", current+1); err != nil {
+		return err
+	}
+	return o.writeRecord("server", "config_revisions", revisions)
+}
+
+// migrateHashUserPasswords replaces plaintext passwords, which were stored before password hashing was introduced,
+// with their hash
+func migrateHashUserPasswords(o *JsonDB) error {
+	users, err := o.readRecords("users")
+	if err != nil {
+		return err
+	}
+	for resource, user := range users {
+		var password, passwordHash string
+		if err := user.get("password", &password); err != nil {
+			return err
+		}
+		if err := user.get("password_hash", &passwordHash); err != nil {
+			return err
+		}
+		if passwordHash != "" || password == "" {
+			continue
+		}
+		hash, err := util.HashPassword(password)
+		if err != nil {
+			return fmt.Errorf("cannot hash password of user %s: %v", resource, err)
+		}
+		if err := user.set("password_hash", hash); err != nil {
+			return err
+		}
+		if err := user.set("password", ""); err != nil {
+			return err
+		}
+		if err := o.writeRecord("users", resource, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateNormalizeClients replaces missing ip lists with empty ones and drops subnet ranges, which are derived from
+// the allocated ips at runtime but used to be saved along with the client
+func migrateNormalizeClients(o *JsonDB) error {
+	clients, err := o.readRecords("clients")
+	if err != nil {
+		return err
+	}
+	for resource, client := range clients {
+		for _, field := range []string{"allocated_ips", "allowed_ips", "extra_allowed_ips"} {
+			if !client.isNull(field) {
+				continue
+			}
+			if err := client.set(field, []string{}); err != nil {
+				return err
+			}
+		}
+		delete(client, "subnet_ranges")
+		if err := o.writeRecord("clients", resource, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateGlobalSettingsDefaults fills settings which did not exist in older versions with their defaults
+func migrateGlobalSettingsDefaults(o *JsonDB) error {
+	settings, found, err := o.readRecord("server", "global_settings")
+	if err != nil || !found {
+		return err
+	}
+	defaults := map[string]interface{}{
+		"table":            util.DefaultTable,
+		"config_file_path": util.DefaultConfigFilePath,
+		"dns_servers":      []string{},
+	}
+	changed := false
+	for field, value := range defaults {
+		var current interface{}
+		if err := settings.get(field, &current); err != nil {
+			return err
+		}
+		if current != nil && current != "" {
+			continue
+		}
+		if err := settings.set(field, value); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return o.writeRecord("server", "global_settings", settings)
+}
+
+// clientVersionRecord is a saved version of a client, which holds the client record as it is stored
+type clientVersionRecord struct {
+	Revision int64           `json:"revision"`
+	SavedAt  json.RawMessage `json:"saved_at"`
+	Client   record          `json:"client"`
+}
+
+// migrateClientVersions saves the current state of every client as its first version, so it can be reverted to after
+// the next change. The keys are copied as they are stored, encrypted or not.
+func migrateClientVersions(o *JsonDB) error {
+	clients, err := o.readRecords("clients")
+	if err != nil {
+		return err
+	}
+	for resource, client := range clients {
+		version := clientVersionRecord{SavedAt: client["updated_at"], Client: client}
+		if err := client.get("revision", &version.Revision); err != nil {
+			return err
+		}
+		if version.SavedAt == nil {
+			version.SavedAt = json.RawMessage("null")
+		}
+		collection := path.Join("client_versions", resource)
+		if err := o.writeRecord(collection, strconv.FormatInt(version.Revision, 10), version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateConfigRevisions drops the hashes which were compared to detect changes to apply. Whether the last changes
+// were applied is not known, so they are taken as pending until the config is applied again.
+func migrateConfigRevisions(o *JsonDB) error {
+	if err := o.migrateConfigRevision(); err != nil {
+		return err
+	}
+	if err := os.Remove(path.Join(o.dbPath, "server", "hashes.json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// interfaceRecord is a server interface with its key pair, as they are stored in the interfaces collection
+type interfaceRecord struct {
+	Interface record `json:"interface"`
+	KeyPair   record `json:"keypair"`
+}
+
+// migrateInterfaces moves the server interface and key pair, along with the config file path of the global settings,
+// into the interfaces collection. The interface is named after the config file, and every client is assigned to it.
+func migrateInterfaces(o *JsonDB) error {
+	serverInterface, found, err := o.readRecord("server", "interfaces")
+	if err != nil || !found {
+		// never initialized, Init creates the default interface
+		return err
+	}
+
+	settings, settingsFound, err := o.readRecord("server", "global_settings")
+	if err != nil {
+		return err
+	}
+	var configFilePath string
+	if err := settings.get("config_file_path", &configFilePath); err != nil {
+		return err
+	}
+	if configFilePath == "" {
+		configFilePath = util.DefaultConfigFilePath
+	}
+	name := util.InterfaceNameFromPath(configFilePath)
+
+	// the key pair is moved as it is stored, encrypted or not
+	keyPair, found, err := o.readRecord("server", "keypair")
+	if err != nil {
+		return err
+	}
+	if !found {
+		keyPair = record{}
+	}
+	if err := serverInterface.set("name", name); err != nil {
+		return err
+	}
+	if err := serverInterface.set("config_file_path", configFilePath); err != nil {
+		return err
+	}
+	if err := o.writeRecord("interfaces", name, interfaceRecord{Interface: serverInterface, KeyPair: keyPair}); err != nil {
+		return err
+	}
+
+	clients, err := o.readRecords("clients")
+	if err != nil {
+		return err
+	}
+	for clientID, client := range clients {
+		collection := path.Join("client_versions", clientID)
+		versions, err := o.readRecords(collection)
+		if err != nil {
+			return err
+		}
+		for resource, version := range versions {
+			versionClient := record{}
+			if err := version.get("client", &versionClient); err != nil {
+				return err
+			}
+			if err := versionClient.set("interface", name); err != nil {
+				return err
+			}
+			if err := version.set("client", versionClient); err != nil {
+				return err
+			}
+			if err := o.writeRecord(collection, resource, version); err != nil {
+				return err
+			}
+		}
+		if err := client.set("interface", name); err != nil {
+			return err
+		}
+		if err := o.writeRecord("clients", clientID, client); err != nil {
+			return err
+		}
+	}
+
+	// drop the config file path from the global settings
+	if settingsFound {
+		delete(settings, "config_file_path")
+		if err := o.writeRecord("server", "global_settings", settings); err != nil {
+			return err
+		}
+	}
+	for _, resource := range []string{"interfaces", "keypair"} {
+		if err := os.Remove(path.Join(o.dbPath, "server", resource+".json")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return o.migrateConfigRevision()
+}
+
+// migrateFirstRevisions moves the users, clients and global settings which were never changed from revision 0 to 1,
+// so every stored record has a revision the callers must send to change it. The first version of such a client is
+// saved again as revision 1.
+func migrateFirstRevisions(o *JsonDB) error {
+	users, err := o.readRecords("users")
+	if err != nil {
+		return err
+	}
+	for username, user := range users {
+		changed, err := startRevision(user)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		if err := o.writeRecord("users", username, user); err != nil {
+			return err
+		}
+	}
+
+	settings, found, err := o.readRecord("server", "global_settings")
+	if err != nil {
+		return err
+	}
+	if found {
+		changed, err := startRevision(settings)
+		if err != nil {
+			return err
+		}
+		if changed {
+			if err := o.writeRecord("server", "global_settings", settings); err != nil {
+				return err
+			}
+		}
+	}
+
+	clients, err := o.readRecords("clients")
+	if err != nil {
+		return err
+	}
+	for clientID, client := range clients {
+		changed, err := startRevision(client)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		collection := path.Join("client_versions", clientID)
+		version, found, err := o.readRecord(collection, "0")
+		if err != nil {
+			return err
+		}
+		if found {
+			versionClient := record{}
+			if err := version.get("client", &versionClient); err != nil {
+				return err
+			}
+			if _, err := startRevision(versionClient); err != nil {
+				return err
+			}
+			if err := version.set("client", versionClient); err != nil {
+				return err
+			}
+			if err := version.set("revision", 1); err != nil {
+				return err
+			}
+			if err := o.writeRecord(collection, "1", version); err != nil {
+				return err
+			}
+			if err := o.conn.Delete(collection, "0"); err != nil {
+				return err
+			}
+		}
+		if err := o.writeRecord("clients", clientID, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startRevision to set the revision of the record to 1 if it is missing or 0, changed reports whether it was
+func startRevision(r record) (changed bool, err error) {
+	var revision int64
+	if err := r.get("revision", &revision); err != nil {
+		return false, err
+	}
+	if revision != 0 {
+		return false, nil
+	}
+	return true, r.set("revision", 1)
+}
@@ -0,0 +1,218 @@
+package sqlite
+
+// migrations holds the ordered list of schema changes. The index of each entry plus one is the schema version it
+// brings the database to, which is tracked with "PRAGMA user_version". Never edit an existing entry, append a new one.
+var migrations = []string{
+	// 1: initial schema
+	`
+CREATE TABLE IF NOT EXISTS users (
+	username      TEXT PRIMARY KEY,
+	password      TEXT NOT NULL DEFAULT '',
+	password_hash TEXT NOT NULL DEFAULT '',
+	admin         INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS server_interface (
+	id          INTEGER PRIMARY KEY CHECK (id = 1),
+	addresses   TEXT NOT NULL DEFAULT '[]',
+	listen_port INTEGER NOT NULL DEFAULT 0,
+	post_up     TEXT NOT NULL DEFAULT '',
+	pre_down    TEXT NOT NULL DEFAULT '',
+	post_down   TEXT NOT NULL DEFAULT '',
+	updated_at  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS server_keypair (
+	id          INTEGER PRIMARY KEY CHECK (id = 1),
+	private_key TEXT NOT NULL DEFAULT '',
+	public_key  TEXT NOT NULL DEFAULT '',
+	updated_at  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS global_settings (
+	id                   INTEGER PRIMARY KEY CHECK (id = 1),
+	endpoint_address     TEXT NOT NULL DEFAULT '',
+	dns_servers          TEXT NOT NULL DEFAULT '[]',
+	mtu                  INTEGER NOT NULL DEFAULT 0,
+	persistent_keepalive INTEGER NOT NULL DEFAULT 0,
+	firewall_mark        TEXT NOT NULL DEFAULT '',
+	route_table          TEXT NOT NULL DEFAULT '',
+	config_file_path     TEXT NOT NULL DEFAULT '',
+	updated_at           TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS hashes (
+	id     INTEGER PRIMARY KEY CHECK (id = 1),
+	client TEXT NOT NULL DEFAULT '',
+	server TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS clients (
+	id                TEXT PRIMARY KEY,
+	private_key       TEXT NOT NULL DEFAULT '',
+	public_key        TEXT NOT NULL DEFAULT '',
+	preshared_key     TEXT NOT NULL DEFAULT '',
+	name              TEXT NOT NULL DEFAULT '',
+	telegram_userid   TEXT NOT NULL DEFAULT '',
+	email             TEXT NOT NULL DEFAULT '',
+	allocated_ips     TEXT NOT NULL DEFAULT '[]',
+	allowed_ips       TEXT NOT NULL DEFAULT '[]',
+	extra_allowed_ips TEXT NOT NULL DEFAULT '[]',
+	endpoint          TEXT NOT NULL DEFAULT '',
+	additional_notes  TEXT NOT NULL DEFAULT '',
+	use_server_dns    INTEGER NOT NULL DEFAULT 0,
+	enabled           INTEGER NOT NULL DEFAULT 0,
+	created_at        TEXT NOT NULL DEFAULT '',
+	updated_at        TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_clients_public_key ON clients (public_key);
+CREATE INDEX IF NOT EXISTS idx_clients_name ON clients (name);
+CREATE INDEX IF NOT EXISTS idx_clients_telegram_userid ON clients (telegram_userid) WHERE telegram_userid <> '';
+
+CREATE TABLE IF NOT EXISTS wake_on_lan_hosts (
+	id          TEXT PRIMARY KEY,
+	mac_address TEXT NOT NULL,
+	name        TEXT NOT NULL DEFAULT '',
+	latest_used TEXT
+);
+`,
+	// 2: revisions for optimistic concurrency
+	`
+ALTER TABLE clients ADD COLUMN revision INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN revision INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE global_settings ADD COLUMN revision INTEGER NOT NULL DEFAULT 0;
+`,
+	// 3: audit log
+	`
+CREATE TABLE IF NOT EXISTS audit_logs (
+	id      TEXT PRIMARY KEY,
+	time    TEXT NOT NULL,
+	actor   TEXT NOT NULL DEFAULT '',
+	action  TEXT NOT NULL DEFAULT '',
+	target  TEXT NOT NULL DEFAULT '',
+	changes TEXT NOT NULL DEFAULT '[]'
+);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_time ON audit_logs (time);
+`,
+	// 4: client versions, starting with the current state of every client
+	`
+CREATE TABLE IF NOT EXISTS client_versions (
+	client_id TEXT NOT NULL,
+	revision  INTEGER NOT NULL,
+	saved_at  TEXT NOT NULL,
+	client    TEXT NOT NULL,
+	PRIMARY KEY (client_id, revision)
+);
+
+INSERT OR IGNORE INTO client_versions (client_id, revision, saved_at, client)
+SELECT id, revision, updated_at, json_object(
+	'id', id, 'private_key', private_key, 'public_key', public_key, 'preshared_key', preshared_key,
+	'name', name, 'telegram_userid', telegram_userid, 'email', email,
+	'allocated_ips', json(allocated_ips), 'allowed_ips', json(allowed_ips),
+	'extra_allowed_ips', json(extra_allowed_ips), 'endpoint', endpoint, 'additional_notes', additional_notes,
+	'use_server_dns', json(CASE WHEN use_server_dns THEN 'true' ELSE 'false' END),
+	'enabled', json(CASE WHEN enabled THEN 'true' ELSE 'false' END),
+	'created_at', created_at, 'updated_at', updated_at, 'revision', revision)
+FROM clients;
+`,
+	// 5: config revisions, replacing the hashes of the clients and the server
+	`
+CREATE TABLE IF NOT EXISTS config_revisions (
+	id      INTEGER PRIMARY KEY CHECK (id = 1),
+	current INTEGER NOT NULL DEFAULT 0,
+	applied INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO config_revisions (id, current, applied) VALUES (1, 1, 0);
+
+DROP TABLE IF EXISTS hashes;
+`,
+	// 6: multiple interfaces, starting with the server interface and key pair named after the config file, which
+	// every client is assigned to
+	`
+CREATE TABLE IF NOT EXISTS interfaces (
+	name               TEXT PRIMARY KEY,
+	addresses          TEXT NOT NULL DEFAULT '[]',
+	listen_port        INTEGER NOT NULL DEFAULT 0,
+	post_up            TEXT NOT NULL DEFAULT '',
+	pre_down           TEXT NOT NULL DEFAULT '',
+	post_down          TEXT NOT NULL DEFAULT '',
+	config_file_path   TEXT NOT NULL DEFAULT '',
+	endpoint_address   TEXT NOT NULL DEFAULT '',
+	updated_at         TEXT NOT NULL DEFAULT '',
+	private_key        TEXT NOT NULL DEFAULT '',
+	public_key         TEXT NOT NULL DEFAULT '',
+	keypair_updated_at TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO interfaces (name, addresses, listen_port, post_up, pre_down, post_down, config_file_path, updated_at,
+	private_key, public_key, keypair_updated_at)
+SELECT CASE WHEN base LIKE '%.conf' THEN substr(base, 1, length(base) - 5) ELSE base END,
+	addresses, listen_port, post_up, pre_down, post_down, path, updated_at,
+	private_key, public_key, keypair_updated_at
+FROM (
+	SELECT i.addresses, i.listen_port, i.post_up, i.pre_down, i.post_down, i.updated_at,
+		coalesce(k.private_key, '') AS private_key, coalesce(k.public_key, '') AS public_key,
+		coalesce(k.updated_at, '') AS keypair_updated_at, p.path,
+		replace(p.path, rtrim(p.path, replace(p.path, '/', '')), '') AS base
+	FROM server_interface i
+	LEFT JOIN server_keypair k ON k.id = 1
+	JOIN (SELECT coalesce(nullif((SELECT config_file_path FROM global_settings WHERE id = 1), ''),
+		'/etc/wireguard/wg0.conf') AS path) p
+	WHERE i.id = 1
+);
+
+ALTER TABLE clients ADD COLUMN interface TEXT NOT NULL DEFAULT '';
+UPDATE clients SET interface = coalesce((SELECT name FROM interfaces), '');
+UPDATE client_versions SET client = json_set(client, '$.interface', coalesce((SELECT name FROM interfaces), ''));
+CREATE INDEX IF NOT EXISTS idx_clients_interface ON clients (interface);
+
+DROP TABLE IF EXISTS server_interface;
+DROP TABLE IF EXISTS server_keypair;
+ALTER TABLE global_settings DROP COLUMN config_file_path;
+`,
+	// 7: client expiry dates
+	`
+ALTER TABLE clients ADD COLUMN expires_at TEXT NOT NULL DEFAULT '';
+`,
+	// 8: client traffic quotas and the collected data volume
+	`
+ALTER TABLE clients ADD COLUMN quota_bytes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE clients ADD COLUMN quota_period TEXT NOT NULL DEFAULT '';
+ALTER TABLE clients ADD COLUMN quota_action TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS traffic_usages (
+	client_id           TEXT PRIMARY KEY,
+	period_start        TEXT NOT NULL DEFAULT '',
+	received_bytes      INTEGER NOT NULL DEFAULT 0,
+	transmit_bytes      INTEGER NOT NULL DEFAULT 0,
+	public_key          TEXT NOT NULL DEFAULT '',
+	peer_received_bytes INTEGER NOT NULL DEFAULT 0,
+	peer_transmit_bytes INTEGER NOT NULL DEFAULT 0,
+	exceeded            INTEGER NOT NULL DEFAULT 0,
+	disabled            INTEGER NOT NULL DEFAULT 0,
+	updated_at          TEXT NOT NULL DEFAULT ''
+);
+`,
+	// 9: traffic history
+	`
+CREATE TABLE IF NOT EXISTS traffic_samples (
+	client_id      TEXT NOT NULL,
+	time           TEXT NOT NULL,
+	hourly         INTEGER NOT NULL DEFAULT 0,
+	received_bytes INTEGER NOT NULL DEFAULT 0,
+	transmit_bytes INTEGER NOT NULL DEFAULT 0,
+	last_handshake TEXT NOT NULL DEFAULT '',
+	endpoint       TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (client_id, time, hourly)
+);
+CREATE INDEX IF NOT EXISTS idx_traffic_samples_time ON traffic_samples (time);
+`,
+	// 10: revisions start at 1, the first version of a client is saved again as revision 1
+	`
+UPDATE users SET revision = 1 WHERE revision = 0;
+UPDATE global_settings SET revision = 1 WHERE revision = 0;
+UPDATE client_versions SET revision = 1, client = json_set(client, '$.revision', 1)
+	WHERE revision = 0 AND client_id IN (SELECT id FROM clients WHERE revision = 0);
+UPDATE clients SET revision = 1 WHERE revision = 0;
+`,
+}
@@ -0,0 +1,644 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	_ "modernc.org/sqlite"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+const clientColumns = `id, private_key, public_key, preshared_key, name, telegram_userid, email, allocated_ips,
+	allowed_ips, extra_allowed_ips, endpoint, additional_notes, use_server_dns, enabled, created_at, updated_at,
+	revision, interface, expires_at, quota_bytes, quota_period, quota_action`
+
+const interfaceColumns = `name, addresses, listen_port, post_up, pre_down, post_down, config_file_path,
+	endpoint_address, updated_at, private_key, public_key, keypair_updated_at`
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type SqliteDB struct {
+	// conn runs the queries, either on the connection pool or within a transaction
+	conn querier
+	// db is the connection pool, nil when the SqliteDB is bound to a transaction
+	db     *sql.DB
+	dbPath string
+}
+
+// New returns a new pointer SqliteDB
+func New(dbPath string) (*SqliteDB, error) {
+	if dir := filepath.Dir(dbPath); dir != "" {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	// WAL lets readers proceed while a write is in progress, busy_timeout makes concurrent writers wait for
+	// each other instead of failing immediately
+	conn, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		return nil, err
+	}
+	ans := SqliteDB{
+		conn:   conn,
+		db:     conn,
+		dbPath: dbPath,
+	}
+	return &ans, nil
+}
+
+func (o *SqliteDB) Init() error {
+	if err := o.migrate(); err != nil {
+		return fmt.Errorf("cannot migrate database schema: %v", err)
+	}
+	if err := util.ManagePerms(o.dbPath); err != nil {
+		return err
+	}
+
+	// server's interface and key pair
+	if empty, err := o.isEmpty("interfaces"); err != nil {
+		return err
+	} else if empty {
+		serverInterface := util.ServerInterfaceDefaultsFromEnv()
+		if err := o.SaveServerInterface(serverInterface); err != nil {
+			return err
+		}
+		serverKeyPair, err := util.GenerateServerKeyPair()
+		if err != nil {
+			return err
+		}
+		if err := o.SaveServerKeyPair(serverInterface.Name, serverKeyPair); err != nil {
+			return err
+		}
+	}
+
+	// global settings
+	if empty, err := o.isEmpty("global_settings"); err != nil {
+		return err
+	} else if empty {
+		globalSetting, err := util.GlobalSettingsDefaultsFromEnv()
+		if err != nil {
+			return err
+		}
+		if err := o.SaveGlobalSettings(globalSetting); err != nil {
+			return err
+		}
+	}
+
+	// user info
+	if empty, err := o.isEmpty("users"); err != nil {
+		return err
+	} else if empty {
+		user, err := util.UserDefaultsFromEnv()
+		if err != nil {
+			return err
+		}
+		if err := o.SaveUser(user); err != nil {
+			return err
+		}
+	}
+
+	// init cache
+	users, err := o.GetUsers()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		util.DBUsersToCRC32[user.Username] = util.GetDBUserCRC32(user)
+	}
+
+	clients, err := o.GetClients(false)
+	if err != nil {
+		return nil
+	}
+	for _, cl := range clients {
+		client := cl.Client
+		if client.Enabled && len(client.TgUserid) > 0 {
+			if userid, err := strconv.ParseInt(client.TgUserid, 10, 64); err == nil {
+				util.UpdateTgToClientID(userid, client.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrate applies the pending schema migrations
+func (o *SqliteDB) migrate() error {
+	var version int
+	if err := o.conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := o.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("schema version %d: %v", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isEmpty checks whether the table has no rows
+func (o *SqliteDB) isEmpty(table string) (bool, error) {
+	var count int
+	if err := o.conn.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// Update func to run fn within a single transaction. The connection string makes every transaction take the write
+// lock when it begins, so concurrent Update calls are serialized. Nested calls join the enclosing transaction.
+func (o *SqliteDB) Update(fn func(tx store.IStore) error) error {
+	if o.db == nil {
+		return fn(o)
+	}
+
+	tx, err := o.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(&SqliteDB{conn: tx, dbPath: o.dbPath}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetUsers func to get all users from the database
+func (o *SqliteDB) GetUsers() ([]model.User, error) {
+	var users []model.User
+	rows, err := o.conn.Query("SELECT username, password, password_hash, admin, revision FROM users ORDER BY username")
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user := model.User{}
+		if err := rows.Scan(&user.Username, &user.Password, &user.PasswordHash, &user.Admin, &user.Revision); err != nil {
+			return users, fmt.Errorf("cannot decode user row: %v", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetUserByName func to get single user from the database
+func (o *SqliteDB) GetUserByName(username string) (model.User, error) {
+	user := model.User{}
+
+	row := o.conn.QueryRow("SELECT username, password, password_hash, admin, revision FROM users WHERE username = ?", username)
+	if err := row.Scan(&user.Username, &user.Password, &user.PasswordHash, &user.Admin, &user.Revision); err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// SaveUser func to save user in the database
+func (o *SqliteDB) SaveUser(user model.User) error {
+	_, err := o.conn.Exec(`INSERT INTO users (username, password, password_hash, admin, revision) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (username) DO UPDATE SET password = excluded.password, password_hash = excluded.password_hash,
+		admin = excluded.admin, revision = excluded.revision`,
+		user.Username, user.Password, user.PasswordHash, user.Admin, user.Revision)
+	if err == nil {
+		err = o.bumpConfigRevision()
+	}
+	if err != nil {
+		return err
+	}
+	util.DBUsersToCRC32[user.Username] = util.GetDBUserCRC32(user)
+	return nil
+}
+
+// DeleteUser func to remove user from the database
+func (o *SqliteDB) DeleteUser(username string) error {
+	delete(util.DBUsersToCRC32, username)
+	if err := o.deleteByKey("users", "username", username); err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
+}
+
+// GetGlobalSettings func to query global settings from the database
+func (o *SqliteDB) GetGlobalSettings() (model.GlobalSetting, error) {
+	settings := model.GlobalSetting{}
+	var dnsServers, updatedAt string
+
+	row := o.conn.QueryRow(`SELECT endpoint_address, dns_servers, mtu, persistent_keepalive, firewall_mark, route_table,
+		updated_at, revision FROM global_settings WHERE id = 1`)
+	err := row.Scan(&settings.EndpointAddress, &dnsServers, &settings.MTU, &settings.PersistentKeepalive,
+		&settings.FirewallMark, &settings.Table, &updatedAt, &settings.Revision)
+	if err != nil {
+		return settings, err
+	}
+	if settings.DNSServers, err = decodeList(dnsServers); err != nil {
+		return settings, err
+	}
+	settings.UpdatedAt = decodeTime(updatedAt)
+
+	return settings, nil
+}
+
+// GetServers func to query the servers of all interfaces from the database
+func (o *SqliteDB) GetServers() ([]model.Server, error) {
+	var servers []model.Server
+	rows, err := o.conn.Query("SELECT " + interfaceColumns + " FROM interfaces ORDER BY name")
+	if err != nil {
+		return servers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		server, err := scanServer(rows)
+		if err != nil {
+			return servers, fmt.Errorf("cannot decode interface row: %v", err)
+		}
+		servers = append(servers, server)
+	}
+	return servers, rows.Err()
+}
+
+// GetServer func to query the server of an interface from the database
+func (o *SqliteDB) GetServer(name string) (model.Server, error) {
+	if name == "" {
+		return scanServer(o.conn.QueryRow("SELECT " + interfaceColumns + " FROM interfaces ORDER BY name LIMIT 1"))
+	}
+	return scanServer(o.conn.QueryRow("SELECT "+interfaceColumns+" FROM interfaces WHERE name = ?", name))
+}
+
+func (o *SqliteDB) GetClients(hasQRCode bool) ([]model.ClientData, error) {
+	var clients []model.ClientData
+
+	rows, err := o.conn.Query("SELECT " + clientColumns + " FROM clients ORDER BY id")
+	if err != nil {
+		return clients, err
+	}
+	defer rows.Close()
+
+	servers := make(map[string]model.Server)
+	var globalSettings model.GlobalSetting
+	if hasQRCode {
+		list, _ := o.GetServers()
+		for _, server := range list {
+			servers[server.Interface.Name] = server
+		}
+		globalSettings, _ = o.GetGlobalSettings()
+	}
+
+	// build the ClientData list
+	for rows.Next() {
+		clientData := model.ClientData{}
+
+		// get client info
+		client, err := scanClient(rows)
+		if err != nil {
+			return clients, fmt.Errorf("cannot decode client row: %v", err)
+		}
+
+		// generate client qrcode image in base64
+		if server, found := servers[client.Interface]; hasQRCode && found && client.PrivateKey != "" {
+			png, err := qrcode.Encode(util.BuildClientConfig(client, server, globalSettings), qrcode.Medium, 256)
+			if err == nil {
+				clientData.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+			} else {
+				fmt.Print("Cannot generate QR code: ", err)
+			}
+		}
+
+		// create the list of clients and their qrcode data
+		clientData.Client = &client
+		clients = append(clients, clientData)
+	}
+
+	return clients, rows.Err()
+}
+
+func (o *SqliteDB) GetClientByID(clientID string, qrCodeSettings model.QRCodeSettings) (model.ClientData, error) {
+	clientData := model.ClientData{}
+
+	// read client information
+	client, err := scanClient(o.conn.QueryRow("SELECT "+clientColumns+" FROM clients WHERE id = ?", clientID))
+	if err != nil {
+		return clientData, err
+	}
+
+	// generate client qrcode image in base64
+	if server, err := o.GetServer(client.Interface); err == nil && qrCodeSettings.Enabled && client.PrivateKey != "" {
+		globalSettings, _ := o.GetGlobalSettings()
+		if !qrCodeSettings.IncludeDNS {
+			globalSettings.DNSServers = []string{}
+		}
+		if !qrCodeSettings.IncludeMTU {
+			globalSettings.MTU = 0
+		}
+
+		png, err := qrcode.Encode(util.BuildClientConfig(client, server, globalSettings), qrcode.Medium, 256)
+		if err == nil {
+			clientData.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+		} else {
+			fmt.Print("Cannot generate QR code: ", err)
+		}
+	}
+
+	clientData.Client = &client
+
+	return clientData, nil
+}
+
+func (o *SqliteDB) SaveClient(client model.Client) error {
+	encrypted, err := util.EncryptClientKeys(client)
+	if err != nil {
+		return err
+	}
+	// an unlimited client is stored with a quota of zero bytes
+	var quota model.Quota
+	if client.Quota != nil {
+		quota = *client.Quota
+	}
+	_, err = o.conn.Exec(`INSERT INTO clients (`+clientColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET private_key = excluded.private_key, public_key = excluded.public_key,
+		preshared_key = excluded.preshared_key, name = excluded.name, telegram_userid = excluded.telegram_userid,
+		email = excluded.email, allocated_ips = excluded.allocated_ips, allowed_ips = excluded.allowed_ips,
+		extra_allowed_ips = excluded.extra_allowed_ips, endpoint = excluded.endpoint,
+		additional_notes = excluded.additional_notes, use_server_dns = excluded.use_server_dns,
+		enabled = excluded.enabled, created_at = excluded.created_at, updated_at = excluded.updated_at,
+		revision = excluded.revision, interface = excluded.interface, expires_at = excluded.expires_at,
+		quota_bytes = excluded.quota_bytes, quota_period = excluded.quota_period, quota_action = excluded.quota_action`,
+		client.ID, encrypted.PrivateKey, client.PublicKey, encrypted.PresharedKey, client.Name, client.TgUserid,
+		client.Email, encodeList(client.AllocatedIPs), encodeList(client.AllowedIPs),
+		encodeList(client.ExtraAllowedIPs), client.Endpoint, client.AdditionalNotes, client.UseServerDNS,
+		client.Enabled, encodeTime(client.CreatedAt), encodeTime(client.UpdatedAt), client.Revision, client.Interface,
+		encodeOptionalTime(client.ExpiresAt), quota.Bytes, quota.Period, quota.Action)
+	if err == nil {
+		err = o.SaveClientVersion(model.ClientVersion{Revision: client.Revision, SavedAt: time.Now().UTC(), Client: client})
+	}
+	if err == nil {
+		err = o.bumpConfigRevision()
+	}
+	if err == nil {
+		if client.Enabled && len(client.TgUserid) > 0 {
+			if userid, err := strconv.ParseInt(client.TgUserid, 10, 64); err == nil {
+				util.UpdateTgToClientID(userid, client.ID)
+			}
+		} else {
+			util.RemoveTgToClientID(client.ID)
+		}
+	} else {
+		util.RemoveTgToClientID(client.ID)
+	}
+	return err
+}
+
+func (o *SqliteDB) DeleteClient(clientID string) error {
+	util.RemoveTgToClientID(clientID)
+	if err := o.deleteByKey("clients", "id", clientID); err != nil {
+		return err
+	}
+	if _, err := o.conn.Exec("DELETE FROM client_versions WHERE client_id = ?", clientID); err != nil {
+		return err
+	}
+	if _, err := o.conn.Exec("DELETE FROM traffic_usages WHERE client_id = ?", clientID); err != nil {
+		return err
+	}
+	if _, err := o.conn.Exec("DELETE FROM traffic_samples WHERE client_id = ?", clientID); err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
+}
+
+func (o *SqliteDB) SaveServerInterface(serverInterface model.ServerInterface) error {
+	_, err := o.conn.Exec(`INSERT INTO interfaces (name, addresses, listen_port, post_up, pre_down, post_down,
+		config_file_path, endpoint_address, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET addresses = excluded.addresses, listen_port = excluded.listen_port,
+		post_up = excluded.post_up, pre_down = excluded.pre_down, post_down = excluded.post_down,
+		config_file_path = excluded.config_file_path, endpoint_address = excluded.endpoint_address,
+		updated_at = excluded.updated_at`,
+		serverInterface.Name, encodeList(serverInterface.Addresses), serverInterface.ListenPort,
+		serverInterface.PostUp, serverInterface.PreDown, serverInterface.PostDown, serverInterface.ConfigFilePath,
+		serverInterface.EndpointAddress, encodeTime(serverInterface.UpdatedAt))
+	if err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
+}
+
+func (o *SqliteDB) SaveServerKeyPair(name string, serverKeyPair model.ServerKeypair) error {
+	serverKeyPair, err := util.EncryptServerKeyPair(serverKeyPair)
+	if err != nil {
+		return err
+	}
+	result, err := o.conn.Exec(`UPDATE interfaces SET private_key = ?, public_key = ?, keypair_updated_at = ?
+		WHERE name = ?`,
+		serverKeyPair.PrivateKey, serverKeyPair.PublicKey, encodeTime(serverKeyPair.UpdatedAt), name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("unable to find interface %q", name)
+	}
+	return o.bumpConfigRevision()
+}
+
+func (o *SqliteDB) DeleteServer(name string) error {
+	if err := o.deleteByKey("interfaces", "name", name); err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
+}
+
+func (o *SqliteDB) SaveGlobalSettings(globalSettings model.GlobalSetting) error {
+	_, err := o.conn.Exec(`INSERT INTO global_settings (id, endpoint_address, dns_servers, mtu, persistent_keepalive,
+		firewall_mark, route_table, updated_at, revision) VALUES (1, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET endpoint_address = excluded.endpoint_address,
+		dns_servers = excluded.dns_servers, mtu = excluded.mtu, persistent_keepalive = excluded.persistent_keepalive,
+		firewall_mark = excluded.firewall_mark, route_table = excluded.route_table, updated_at = excluded.updated_at,
+		revision = excluded.revision`,
+		globalSettings.EndpointAddress, encodeList(globalSettings.DNSServers), globalSettings.MTU,
+		globalSettings.PersistentKeepalive, globalSettings.FirewallMark, globalSettings.Table,
+		encodeTime(globalSettings.UpdatedAt), globalSettings.Revision)
+	if err != nil {
+		return err
+	}
+	return o.bumpConfigRevision()
+}
+
+func (o *SqliteDB) GetPath() string {
+	return o.dbPath
+}
+
+func (o *SqliteDB) GetConfigRevisions() (model.ConfigRevisions, error) {
+	revisions := model.ConfigRevisions{}
+	err := o.conn.QueryRow("SELECT current, applied FROM config_revisions WHERE id = 1").Scan(&revisions.Current, &revisions.Applied)
+	if errors.Is(err, sql.ErrNoRows) {
+		return revisions, nil
+	}
+	return revisions, err
+}
+
+func (o *SqliteDB) SaveAppliedConfigRevision(revision uint64) error {
+	_, err := o.conn.Exec(`INSERT INTO config_revisions (id, applied) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET applied = excluded.applied`, revision)
+	return err
+}
+
+// bumpConfigRevision to count a change to the configuration
+func (o *SqliteDB) bumpConfigRevision() error {
+	_, err := o.conn.Exec(`INSERT INTO config_revisions (id, current) VALUES (1, 1)
+		ON CONFLICT (id) DO UPDATE SET current = current + 1`)
+	return err
+}
+
+// deleteByKey removes a single row and reports an error if it did not exist
+func (o *SqliteDB) deleteByKey(table, column, key string) error {
+	result, err := o.conn.Exec("DELETE FROM "+table+" WHERE "+column+" = ?", key)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("unable to find %s %q in %s", column, key, table)
+	}
+	return err
+}
+
+func scanClient(row scanner) (model.Client, error) {
+	client := model.Client{}
+	var allocatedIPs, allowedIPs, extraAllowedIPs, createdAt, updatedAt, expiresAt string
+	var quota model.Quota
+
+	err := row.Scan(&client.ID, &client.PrivateKey, &client.PublicKey, &client.PresharedKey, &client.Name,
+		&client.TgUserid, &client.Email, &allocatedIPs, &allowedIPs, &extraAllowedIPs, &client.Endpoint,
+		&client.AdditionalNotes, &client.UseServerDNS, &client.Enabled, &createdAt, &updatedAt, &client.Revision,
+		&client.Interface, &expiresAt, &quota.Bytes, &quota.Period, &quota.Action)
+	if err != nil {
+		return client, err
+	}
+	if client.AllocatedIPs, err = decodeList(allocatedIPs); err != nil {
+		return client, err
+	}
+	if client.AllowedIPs, err = decodeList(allowedIPs); err != nil {
+		return client, err
+	}
+	if client.ExtraAllowedIPs, err = decodeList(extraAllowedIPs); err != nil {
+		return client, err
+	}
+	client.CreatedAt = decodeTime(createdAt)
+	client.UpdatedAt = decodeTime(updatedAt)
+	client.ExpiresAt = decodeOptionalTime(expiresAt)
+	if quota.Bytes > 0 {
+		client.Quota = &quota
+	}
+
+	return client, util.DecryptClientKeys(&client)
+}
+
+func scanServer(row scanner) (model.Server, error) {
+	server := model.Server{}
+	serverInterface := model.ServerInterface{}
+	serverKeyPair := model.ServerKeypair{}
+	var addresses, interfaceUpdatedAt, keyPairUpdatedAt string
+
+	err := row.Scan(&serverInterface.Name, &addresses, &serverInterface.ListenPort, &serverInterface.PostUp,
+		&serverInterface.PreDown, &serverInterface.PostDown, &serverInterface.ConfigFilePath,
+		&serverInterface.EndpointAddress, &interfaceUpdatedAt, &serverKeyPair.PrivateKey, &serverKeyPair.PublicKey,
+		&keyPairUpdatedAt)
+	if err != nil {
+		return server, err
+	}
+	if serverInterface.Addresses, err = decodeList(addresses); err != nil {
+		return server, err
+	}
+	serverInterface.UpdatedAt = decodeTime(interfaceUpdatedAt)
+	serverKeyPair.UpdatedAt = decodeTime(keyPairUpdatedAt)
+	if err := util.DecryptServerKeyPair(&serverKeyPair); err != nil {
+		return server, err
+	}
+
+	server.Interface = &serverInterface
+	server.KeyPair = &serverKeyPair
+	return server, nil
+}
+
+// encodeList stores a string slice as a JSON array, keeping nil and empty slices apart like the JSON database does
+func encodeList(list []string) string {
+	if list == nil {
+		return "null"
+	}
+	b, _ := json.Marshal(list)
+	return string(b)
+}
+
+func decodeList(s string) ([]string, error) {
+	var list []string
+	if err := json.Unmarshal([]byte(s), &list); err != nil {
+		return nil, errors.New("cannot decode list column: " + err.Error())
+	}
+	return list, nil
+}
+
+// sortableTimeLayout has a fixed width, so rows sort and filter by time as strings
+const sortableTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+func encodeTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func decodeTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+// encodeOptionalTime stores nil as an empty string
+func encodeOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return encodeTime(*t)
+}
+
+func decodeOptionalTime(s string) *time.Time {
+	if s == "" {
+		return nil
+	}
+	t := decodeTime(s)
+	return &t
+}
@@ -0,0 +1,80 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+)
+
+// SaveAuditLog func to append an entry to the audit log
+func (o *SqliteDB) SaveAuditLog(entry model.AuditLog) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.conn.Exec("INSERT INTO audit_logs (id, time, actor, action, target, changes) VALUES (?, ?, ?, ?, ?, ?)",
+		entry.ID, entry.Time.UTC().Format(sortableTimeLayout), entry.Actor, entry.Action, entry.Target, string(changes))
+	return err
+}
+
+// GetAuditLogs func to query the audit log entries selected by the filter, newest first
+func (o *SqliteDB) GetAuditLogs(filter model.AuditLogFilter) ([]model.AuditLog, error) {
+	var entries []model.AuditLog
+
+	var conditions []string
+	var args []interface{}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Target != "" {
+		conditions = append(conditions, "instr(target, ?) > 0")
+		args = append(args, filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "time >= ?")
+		args = append(args, filter.Since.UTC().Format(sortableTimeLayout))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "time < ?")
+		args = append(args, filter.Until.UTC().Format(sortableTimeLayout))
+	}
+
+	query := "SELECT id, time, actor, action, target, changes FROM audit_logs"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY time DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := o.conn.Query(query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := model.AuditLog{}
+		var entryTime, changes string
+		if err := rows.Scan(&entry.ID, &entryTime, &entry.Actor, &entry.Action, &entry.Target, &changes); err != nil {
+			return entries, fmt.Errorf("cannot decode audit log row: %v", err)
+		}
+		entry.Time = decodeTime(entryTime)
+		if err := json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			return entries, fmt.Errorf("cannot decode audit log changes: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
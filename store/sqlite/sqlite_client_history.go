@@ -0,0 +1,63 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// GetClientVersions func to query the saved versions of a client, newest first
+func (o *SqliteDB) GetClientVersions(clientID string) ([]model.ClientVersion, error) {
+	var versions []model.ClientVersion
+
+	rows, err := o.conn.Query("SELECT revision, saved_at, client FROM client_versions WHERE client_id = ? ORDER BY revision DESC", clientID)
+	if err != nil {
+		return versions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		version := model.ClientVersion{}
+		var savedAt, client string
+		if err := rows.Scan(&version.Revision, &savedAt, &client); err != nil {
+			return versions, fmt.Errorf("cannot decode client version row: %v", err)
+		}
+		version.SavedAt = decodeTime(savedAt)
+		if err := json.Unmarshal([]byte(client), &version.Client); err != nil {
+			return versions, fmt.Errorf("cannot decode client version: %v", err)
+		}
+		if err := util.DecryptClientKeys(&version.Client); err != nil {
+			return versions, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+// SaveClientVersion func to save a version of a client, replacing a saved version with the same revision, and remove
+// the oldest versions beyond util.ClientHistoryLimit
+func (o *SqliteDB) SaveClientVersion(version model.ClientVersion) error {
+	encrypted, err := util.EncryptClientKeys(version.Client)
+	if err != nil {
+		return err
+	}
+	client, err := json.Marshal(encrypted)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.conn.Exec(`INSERT INTO client_versions (client_id, revision, saved_at, client) VALUES (?, ?, ?, ?)
+		ON CONFLICT (client_id, revision) DO UPDATE SET saved_at = excluded.saved_at, client = excluded.client`,
+		version.Client.ID, version.Revision, encodeTime(version.SavedAt), string(client))
+	if err != nil || util.ClientHistoryLimit <= 0 {
+		return err
+	}
+
+	_, err = o.conn.Exec(`DELETE FROM client_versions WHERE client_id = ? AND revision NOT IN
+		(SELECT revision FROM client_versions WHERE client_id = ? ORDER BY revision DESC LIMIT ?)`,
+		version.Client.ID, version.Client.ID, util.ClientHistoryLimit)
+	return err
+}
@@ -0,0 +1,127 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+)
+
+// GetTrafficUsages func to query the data volume collected of every client
+func (o *SqliteDB) GetTrafficUsages() ([]model.TrafficUsage, error) {
+	var usages []model.TrafficUsage
+
+	rows, err := o.conn.Query(`SELECT client_id, period_start, received_bytes, transmit_bytes, public_key,
+		peer_received_bytes, peer_transmit_bytes, exceeded, disabled, updated_at FROM traffic_usages ORDER BY client_id`)
+	if err != nil {
+		return usages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		usage := model.TrafficUsage{}
+		var periodStart, updatedAt string
+		err := rows.Scan(&usage.ClientID, &periodStart, &usage.ReceivedBytes, &usage.TransmitBytes, &usage.PublicKey,
+			&usage.PeerReceivedBytes, &usage.PeerTransmitBytes, &usage.Exceeded, &usage.Disabled, &updatedAt)
+		if err != nil {
+			return usages, fmt.Errorf("cannot decode traffic usage row: %v", err)
+		}
+		usage.PeriodStart = decodeTime(periodStart)
+		usage.UpdatedAt = decodeTime(updatedAt)
+		usages = append(usages, usage)
+	}
+
+	return usages, rows.Err()
+}
+
+// SaveTrafficUsage func to create or replace the data volume collected of a client
+func (o *SqliteDB) SaveTrafficUsage(usage model.TrafficUsage) error {
+	_, err := o.conn.Exec(`INSERT INTO traffic_usages (client_id, period_start, received_bytes, transmit_bytes,
+		public_key, peer_received_bytes, peer_transmit_bytes, exceeded, disabled, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (client_id) DO UPDATE SET period_start = excluded.period_start,
+		received_bytes = excluded.received_bytes, transmit_bytes = excluded.transmit_bytes,
+		public_key = excluded.public_key, peer_received_bytes = excluded.peer_received_bytes,
+		peer_transmit_bytes = excluded.peer_transmit_bytes, exceeded = excluded.exceeded,
+		disabled = excluded.disabled, updated_at = excluded.updated_at`,
+		usage.ClientID, encodeTime(usage.PeriodStart), usage.ReceivedBytes, usage.TransmitBytes, usage.PublicKey,
+		usage.PeerReceivedBytes, usage.PeerTransmitBytes, usage.Exceeded, usage.Disabled, encodeTime(usage.UpdatedAt))
+	return err
+}
+
+// trafficSampleConditions returns the WHERE clause and its arguments selecting the samples of the filter
+func trafficSampleConditions(filter model.TrafficSampleFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if filter.ClientID != "" {
+		conditions = append(conditions, "client_id = ?")
+		args = append(args, filter.ClientID)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "time >= ?")
+		args = append(args, filter.Since.UTC().Format(sortableTimeLayout))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "time < ?")
+		args = append(args, filter.Until.UTC().Format(sortableTimeLayout))
+	}
+	if filter.RawOnly {
+		conditions = append(conditions, "hourly = 0")
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// GetTrafficSamples func to query the traffic samples selected by the filter, oldest first
+func (o *SqliteDB) GetTrafficSamples(filter model.TrafficSampleFilter) ([]model.TrafficSample, error) {
+	var samples []model.TrafficSample
+
+	where, args := trafficSampleConditions(filter)
+	rows, err := o.conn.Query(`SELECT client_id, time, hourly, received_bytes, transmit_bytes, last_handshake, endpoint
+		FROM traffic_samples`+where+" ORDER BY time, client_id", args...)
+	if err != nil {
+		return samples, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		sample := model.TrafficSample{}
+		var sampleTime, lastHandshake string
+		err := rows.Scan(&sample.ClientID, &sampleTime, &sample.Hourly, &sample.ReceivedBytes, &sample.TransmitBytes,
+			&lastHandshake, &sample.Endpoint)
+		if err != nil {
+			return samples, fmt.Errorf("cannot decode traffic sample row: %v", err)
+		}
+		sample.Time = decodeTime(sampleTime)
+		sample.LastHandshake = decodeTime(lastHandshake)
+		samples = append(samples, sample)
+	}
+
+	return samples, rows.Err()
+}
+
+// SaveTrafficSamples func to add traffic samples, replacing those of the same client, time and resolution
+func (o *SqliteDB) SaveTrafficSamples(samples []model.TrafficSample) error {
+	for _, sample := range samples {
+		_, err := o.conn.Exec(`INSERT INTO traffic_samples (client_id, time, hourly, received_bytes, transmit_bytes,
+			last_handshake, endpoint) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (client_id, time, hourly) DO UPDATE SET received_bytes = excluded.received_bytes,
+			transmit_bytes = excluded.transmit_bytes, last_handshake = excluded.last_handshake,
+			endpoint = excluded.endpoint`,
+			sample.ClientID, sample.Time.UTC().Format(sortableTimeLayout), sample.Hourly, sample.ReceivedBytes,
+			sample.TransmitBytes, encodeTime(sample.LastHandshake), sample.Endpoint)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteTrafficSamples func to remove the traffic samples selected by the filter
+func (o *SqliteDB) DeleteTrafficSamples(filter model.TrafficSampleFilter) error {
+	where, args := trafficSampleConditions(filter)
+	_, err := o.conn.Exec("DELETE FROM traffic_samples"+where, args...)
+	return err
+}
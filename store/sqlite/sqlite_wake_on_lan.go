@@ -0,0 +1,99 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+)
+
+func (o *SqliteDB) GetWakeOnLanHosts() ([]model.WakeOnLanHost, error) {
+	var hosts []model.WakeOnLanHost
+
+	rows, err := o.conn.Query("SELECT mac_address, name, latest_used FROM wake_on_lan_hosts ORDER BY id")
+	if err != nil {
+		return hosts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		host, err := scanWakeOnLanHost(rows)
+		if err != nil {
+			return hosts, fmt.Errorf("cannot decode wake on lan host row: %v", err)
+		}
+		hosts = append(hosts, host)
+	}
+
+	return hosts, rows.Err()
+}
+
+func (o *SqliteDB) GetWakeOnLanHost(macAddress string) (*model.WakeOnLanHost, error) {
+	host := &model.WakeOnLanHost{
+		MacAddress: macAddress,
+	}
+	resourceName, err := host.ResolveResourceName()
+	if err != nil {
+		return nil, err
+	}
+
+	row := o.conn.QueryRow("SELECT mac_address, name, latest_used FROM wake_on_lan_hosts WHERE id = ?", resourceName)
+	found, err := scanWakeOnLanHost(row)
+	if err != nil {
+		return nil, err
+	}
+	return &found, nil
+}
+
+func (o *SqliteDB) DeleteWakeOnHostLanHost(macAddress string) error {
+	host := &model.WakeOnLanHost{
+		MacAddress: macAddress,
+	}
+	resourceName, err := host.ResolveResourceName()
+	if err != nil {
+		return err
+	}
+
+	return o.deleteByKey("wake_on_lan_hosts", "id", resourceName)
+}
+
+func (o *SqliteDB) SaveWakeOnLanHost(host model.WakeOnLanHost) error {
+	resourceName, err := host.ResolveResourceName()
+	if err != nil {
+		return err
+	}
+
+	var latestUsed sql.NullString
+	if host.LatestUsed != nil {
+		latestUsed = sql.NullString{String: encodeTime(*host.LatestUsed), Valid: true}
+	}
+
+	_, err = o.conn.Exec(`INSERT INTO wake_on_lan_hosts (id, mac_address, name, latest_used) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET mac_address = excluded.mac_address, name = excluded.name,
+		latest_used = excluded.latest_used`,
+		resourceName, host.MacAddress, host.Name, latestUsed)
+	return err
+}
+
+func (o *SqliteDB) DeleteWakeOnHost(host model.WakeOnLanHost) error {
+	resourceName, err := host.ResolveResourceName()
+	if err != nil {
+		return err
+	}
+
+	return o.deleteByKey("wake_on_lan_hosts", "id", resourceName)
+}
+
+func scanWakeOnLanHost(row scanner) (model.WakeOnLanHost, error) {
+	host := model.WakeOnLanHost{}
+	var latestUsed sql.NullString
+
+	if err := row.Scan(&host.MacAddress, &host.Name, &latestUsed); err != nil {
+		return host, err
+	}
+	if latestUsed.Valid {
+		t := decodeTime(latestUsed.String)
+		host.LatestUsed = &t
+	}
+
+	return host, nil
+}
@@ -11,13 +11,24 @@ type IStore interface {
 	SaveUser(user model.User) error
 	DeleteUser(username string) error
 	GetGlobalSettings() (model.GlobalSetting, error)
-	GetServer() (model.Server, error)
+	// GetServers returns the server of every interface, ordered by name
+	GetServers() ([]model.Server, error)
+	// GetServer returns the server of the interface with the given name, or the first one if name is empty
+	GetServer(name string) (model.Server, error)
 	GetClients(hasQRCode bool) ([]model.ClientData, error)
 	GetClientByID(clientID string, qrCode model.QRCodeSettings) (model.ClientData, error)
 	SaveClient(client model.Client) error
 	DeleteClient(clientID string) error
+	// GetClientVersions returns the saved versions of a client, newest first. SaveClient saves a version of every
+	// revision, DeleteClient removes them.
+	GetClientVersions(clientID string) ([]model.ClientVersion, error)
+	SaveClientVersion(version model.ClientVersion) error
+	// SaveServerInterface creates or updates the interface with the name of serverInterface. A new interface needs a
+	// key pair as well before it is complete.
 	SaveServerInterface(serverInterface model.ServerInterface) error
-	SaveServerKeyPair(serverKeyPair model.ServerKeypair) error
+	SaveServerKeyPair(name string, serverKeyPair model.ServerKeypair) error
+	// DeleteServer removes the interface and its key pair, its clients have to be moved or removed first
+	DeleteServer(name string) error
 	SaveGlobalSettings(globalSettings model.GlobalSetting) error
 	GetWakeOnLanHosts() ([]model.WakeOnLanHost, error)
 	GetWakeOnLanHost(macAddress string) (*model.WakeOnLanHost, error)
@@ -25,6 +36,32 @@ type IStore interface {
 	SaveWakeOnLanHost(host model.WakeOnLanHost) error
 	DeleteWakeOnHost(host model.WakeOnLanHost) error
 	GetPath() string
-	SaveHashes(hashes model.ClientServerHashes) error
-	GetHashes() (model.ClientServerHashes, error)
+	// GetConfigRevisions returns the revision of the configuration and the revision last applied. Every method
+	// changing users, clients, the server interfaces and key pairs or the global settings increases the current
+	// revision. Wake on lan hosts, client versions and the audit log are not part of the WireGuard config.
+	GetConfigRevisions() (model.ConfigRevisions, error)
+	// SaveAppliedConfigRevision records that the configuration of the given revision has been applied
+	SaveAppliedConfigRevision(revision uint64) error
+	// GetTrafficUsages returns the data volume collected of every client. DeleteClient removes the usage of the
+	// client, which is not part of the WireGuard config either.
+	GetTrafficUsages() ([]model.TrafficUsage, error)
+	SaveTrafficUsage(usage model.TrafficUsage) error
+	// GetTrafficSamples returns the traffic samples selected by the filter, oldest first. DeleteClient removes the
+	// samples of the client.
+	GetTrafficSamples(filter model.TrafficSampleFilter) ([]model.TrafficSample, error)
+	// SaveTrafficSamples adds the samples, replacing those of the same client, time and resolution
+	SaveTrafficSamples(samples []model.TrafficSample) error
+	DeleteTrafficSamples(filter model.TrafficSampleFilter) error
+	SaveAuditLog(entry model.AuditLog) error
+	GetAuditLogs(filter model.AuditLogFilter) ([]model.AuditLog, error)
+	// Update runs fn with exclusive write access to the store, so data read through tx cannot be changed by another
+	// Update before fn returns. The writes of fn are discarded if it returns an error.
+	Update(fn func(tx IStore) error) error
+}
+
+// Snapshotter is implemented by stores which cannot roll back changes interrupted by a crash, to copy the database
+// before changes which would leave it unusable if interrupted
+type Snapshotter interface {
+	// Snapshot copies the database and returns the path of the copy
+	Snapshot(name string) (string, error)
 }
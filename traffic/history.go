@@ -0,0 +1,93 @@
+package traffic
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+)
+
+// History configures the traffic samples the Collector keeps
+type History struct {
+	// RawRetention is the time the samples of every collection are kept, before they are merged into hourly samples
+	RawRetention time.Duration
+	// Retention is the time samples are kept at all, zero disables the history
+	Retention time.Duration
+}
+
+// Enabled returns whether samples are kept
+func (h History) Enabled() bool {
+	return h.Retention > 0
+}
+
+// MergeHourly returns the samples merged into one sample per client and hour, oldest first. Hourly samples are merged
+// along with the raw ones.
+func MergeHourly(samples []model.TrafficSample) []model.TrafficSample {
+	type hourKey struct {
+		clientID string
+		hour     time.Time
+	}
+	var keys []hourKey
+	merged := make(map[hourKey]*model.TrafficSample)
+	latest := make(map[hourKey]time.Time)
+	for _, sample := range samples {
+		key := hourKey{sample.ClientID, sample.Time.UTC().Truncate(time.Hour)}
+		hourly, found := merged[key]
+		if !found {
+			hourly = &model.TrafficSample{ClientID: sample.ClientID, Time: key.hour, Hourly: true}
+			merged[key] = hourly
+			keys = append(keys, key)
+		}
+		hourly.ReceivedBytes += sample.ReceivedBytes
+		hourly.TransmitBytes += sample.TransmitBytes
+		if sample.LastHandshake.After(hourly.LastHandshake) {
+			hourly.LastHandshake = sample.LastHandshake
+		}
+		// the endpoint is the one of the latest sample within the hour
+		if !sample.Time.Before(latest[key]) {
+			hourly.Endpoint = sample.Endpoint
+			latest[key] = sample.Time
+		}
+	}
+
+	result := make([]model.TrafficSample, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *merged[key])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Time.Equal(result[j].Time) {
+			return result[i].ClientID < result[j].ClientID
+		}
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result
+}
+
+// Downsample merges the raw samples older than the raw retention into hourly samples, and removes the samples older
+// than the retention
+func Downsample(db store.IStore, history History, now time.Time) error {
+	return db.Update(func(tx store.IStore) error {
+		// only complete hours are merged, together with hourly samples already merged of them
+		until := now.Add(-history.RawRetention).Truncate(time.Hour)
+		raw, err := tx.GetTrafficSamples(model.TrafficSampleFilter{Until: until, RawOnly: true})
+		if err != nil {
+			return err
+		}
+		if len(raw) > 0 {
+			filter := model.TrafficSampleFilter{Since: raw[0].Time.Truncate(time.Hour), Until: until}
+			samples, err := tx.GetTrafficSamples(filter)
+			if err != nil {
+				return err
+			}
+			if err := tx.DeleteTrafficSamples(filter); err != nil {
+				return err
+			}
+			if err := tx.SaveTrafficSamples(MergeHourly(samples)); err != nil {
+				return err
+			}
+		}
+
+		return tx.DeleteTrafficSamples(model.TrafficSampleFilter{Until: now.Add(-history.Retention)})
+	})
+}
@@ -0,0 +1,25 @@
+package traffic
+
+import (
+	"fmt"
+	"html"
+
+	"github.com/ngoduykhanh/wireguard-ui/emailer"
+)
+
+// EmailNotifier returns a Notifier which emails the clients having an email address
+func EmailNotifier(mailer emailer.Emailer) Notifier {
+	return func(exceeded Exceeded) error {
+		client := exceeded.Client
+		if client.Email == "" {
+			return nil
+		}
+
+		content := fmt.Sprintf("<p>Your WireGuard client %s has used %s this %s, exceeding its quota of %s.</p>",
+			html.EscapeString(client.Name), formatBytes(exceeded.Usage.Total()), client.Quota.Period, formatBytes(client.Quota.Bytes))
+		if exceeded.Disabled {
+			content += fmt.Sprintf("<p>It has been disabled until the next %s begins.</p>", client.Quota.Period)
+		}
+		return mailer.Send(client.Name, client.Email, "WireGuard data quota exceeded", content, nil)
+	}
+}
@@ -0,0 +1,344 @@
+package traffic
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"golang.zx2c4.com/wireguard/wgctrl"
+
+	"github.com/ngoduykhanh/wireguard-ui/apply"
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store"
+	"github.com/ngoduykhanh/wireguard-ui/util"
+)
+
+// auditActor is recorded in the audit log for changes made by the Collector
+const auditActor = "quota"
+
+// Peer is the state of a peer of a running interface
+type Peer struct {
+	ReceivedBytes int64
+	TransmitBytes int64
+	LastHandshake time.Time
+	Endpoint      string
+}
+
+// Exceeded is a client which exceeded its quota, with its usage at that time
+type Exceeded struct {
+	Client model.Client
+	Usage  model.TrafficUsage
+	// Disabled is set if the client was disabled for it
+	Disabled bool
+}
+
+// Result describes what Collect changed
+type Result struct {
+	// Exceeded are the clients which exceeded their quota since the last collection
+	Exceeded []Exceeded
+	// Enabled are the clients enabled again in a new period, after they were disabled for exceeding their quota
+	Enabled []model.Client
+	// Samples are the traffic of the clients since the last collection, of those which had any
+	Samples []model.TrafficSample
+}
+
+// Changed returns whether clients were disabled or enabled, which changes the configuration
+func (r Result) Changed() bool {
+	if len(r.Enabled) > 0 {
+		return true
+	}
+	for _, exceeded := range r.Exceeded {
+		if exceeded.Disabled {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifier informs a client that it exceeded its quota
+type Notifier func(exceeded Exceeded) error
+
+// Collector accumulates the data volume of the clients from the counters of their peers in the background, and takes
+// the action of their quota once they exceed it
+type Collector struct {
+	db       store.IStore
+	interval time.Duration
+	history  History
+	// onChange is called after clients were disabled or enabled, to get the change into the running interfaces
+	onChange func()
+	notify   Notifier
+	// lastError is the last error reading the counters, which is logged only when it changes
+	lastError string
+	// lastDownsample is the time the samples were downsampled last, which is done once an hour
+	lastDownsample time.Time
+}
+
+// NewCollector returns a Collector which reads the counters every interval and keeps samples of them according to
+// history. onChange and notify may be nil. It has to be started with Start.
+func NewCollector(db store.IStore, interval time.Duration, history History, onChange func(), notify Notifier) *Collector {
+	return &Collector{
+		db:       db,
+		interval: interval,
+		history:  history,
+		onChange: onChange,
+		notify:   notify,
+	}
+}
+
+// Start to run the worker
+func (c *Collector) Start() {
+	go c.run()
+}
+
+func (c *Collector) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.collect()
+		<-ticker.C
+	}
+}
+
+func (c *Collector) collect() {
+	devices, err := ReadDevices(c.db)
+	if err != nil {
+		if err.Error() != c.lastError {
+			log.Warn("Cannot read the traffic counters of the peers: ", err)
+		}
+		c.lastError = err.Error()
+		return
+	}
+	c.lastError = ""
+
+	now := time.Now()
+	result, err := Collect(c.db, devices, now)
+	if err != nil {
+		log.Error("Cannot collect the traffic of the clients: ", err)
+		return
+	}
+	if c.history.Enabled() {
+		c.keepSamples(result.Samples, now)
+	}
+	for _, exceeded := range result.Exceeded {
+		log.Warnf("Client %s (%s) exceeded its quota of %s with %s", exceeded.Client.Name, exceeded.Client.ID,
+			formatBytes(exceeded.Client.Quota.Bytes), formatBytes(exceeded.Usage.Total()))
+		if c.notify == nil {
+			continue
+		}
+		if err := c.notify(exceeded); err != nil {
+			log.Errorf("Cannot notify client %s (%s) about its quota: %v", exceeded.Client.Name, exceeded.Client.ID, err)
+		}
+	}
+	for _, client := range result.Enabled {
+		log.Infof("Enabled client %s (%s) again in a new quota period", client.Name, client.ID)
+	}
+	if result.Changed() && c.onChange != nil {
+		c.onChange()
+	}
+}
+
+// keepSamples to save the samples of a collection, and downsample the saved ones once an hour
+func (c *Collector) keepSamples(samples []model.TrafficSample, now time.Time) {
+	err := c.db.Update(func(tx store.IStore) error {
+		return tx.SaveTrafficSamples(samples)
+	})
+	if err != nil {
+		log.Error("Cannot save the traffic samples: ", err)
+	}
+
+	if now.Sub(c.lastDownsample) < time.Hour {
+		return
+	}
+	if err := Downsample(c.db, c.history, now); err != nil {
+		log.Error("Cannot downsample the traffic samples: ", err)
+		return
+	}
+	c.lastDownsample = now
+}
+
+// ReadDevices returns the peers of the running server interfaces by interface name and public key.
+// Interfaces which are not up are left out.
+func ReadDevices(db store.IStore) (map[string]map[string]Peer, error) {
+	servers, err := db.GetServers()
+	if err != nil {
+		return nil, err
+	}
+
+	wgClient, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	defer wgClient.Close()
+
+	devices := make(map[string]map[string]Peer, len(servers))
+	for _, server := range servers {
+		device, err := wgClient.Device(server.Interface.Name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		peers := make(map[string]Peer, len(device.Peers))
+		for _, peer := range device.Peers {
+			state := Peer{
+				ReceivedBytes: peer.ReceiveBytes,
+				TransmitBytes: peer.TransmitBytes,
+				LastHandshake: peer.LastHandshakeTime,
+			}
+			if peer.Endpoint != nil {
+				state.Endpoint = peer.Endpoint.String()
+			}
+			peers[peer.PublicKey.String()] = state
+		}
+		devices[server.Interface.Name] = peers
+	}
+	return devices, nil
+}
+
+// Collect adds the traffic since the last collection to the usage of every client, given the counters of the peers of
+// the running interfaces as returned by ReadDevices, and takes the action of the quota of the clients exceeding it
+func Collect(db store.IStore, devices map[string]map[string]Peer, now time.Time) (Result, error) {
+	var result Result
+	err := db.Update(func(tx store.IStore) error {
+		result = Result{}
+
+		clients, err := tx.GetClients(false)
+		if err != nil {
+			return err
+		}
+		usageList, err := tx.GetTrafficUsages()
+		if err != nil {
+			return err
+		}
+		usages := make(map[string]model.TrafficUsage, len(usageList))
+		for _, usage := range usageList {
+			usages[usage.ClientID] = usage
+		}
+
+		for _, clientData := range clients {
+			client := *clientData.Client
+			usage, found := usages[client.ID]
+			if !found {
+				usage = model.TrafficUsage{ClientID: client.ID}
+			}
+			before := usage
+
+			// the usage of clients without quota is counted per month
+			quota := model.Quota{Period: model.QuotaPeriodMonth}
+			if client.Quota != nil {
+				quota = *client.Quota
+			}
+
+			// a new period is counted from zero, and clients disabled for exceeding their quota are enabled again
+			if periodStart := quota.PeriodStart(now); !usage.PeriodStart.Equal(periodStart) {
+				usage.PeriodStart = periodStart
+				usage.ReceivedBytes = 0
+				usage.TransmitBytes = 0
+				usage.Exceeded = false
+				if usage.Disabled && !client.Enabled && !client.Expired(now) {
+					if client, err = saveEnabled(tx, client, true, now, "client.quota_reset"); err != nil {
+						return err
+					}
+					result.Enabled = append(result.Enabled, client)
+				}
+				usage.Disabled = false
+			}
+
+			if peers, up := devices[client.Interface]; up {
+				peer := peers[client.PublicKey]
+				// the counters start at zero again when the interface is restarted or the peer is added again, a
+				// missing peer is counted as zero so its counters are taken in full once it is added
+				if usage.PublicKey != client.PublicKey || peer.ReceivedBytes < usage.PeerReceivedBytes ||
+					peer.TransmitBytes < usage.PeerTransmitBytes {
+					usage.PeerReceivedBytes = 0
+					usage.PeerTransmitBytes = 0
+				}
+				sample := model.TrafficSample{
+					ClientID:      client.ID,
+					Time:          now.UTC(),
+					ReceivedBytes: peer.ReceivedBytes - usage.PeerReceivedBytes,
+					TransmitBytes: peer.TransmitBytes - usage.PeerTransmitBytes,
+					LastHandshake: peer.LastHandshake.UTC(),
+					Endpoint:      peer.Endpoint,
+				}
+				if sample.ReceivedBytes > 0 || sample.TransmitBytes > 0 {
+					result.Samples = append(result.Samples, sample)
+				}
+				usage.ReceivedBytes += sample.ReceivedBytes
+				usage.TransmitBytes += sample.TransmitBytes
+				usage.PublicKey = client.PublicKey
+				usage.PeerReceivedBytes = peer.ReceivedBytes
+				usage.PeerTransmitBytes = peer.TransmitBytes
+			}
+
+			// the action is taken once per period, so a client enabled again by hand stays enabled until its end
+			if client.Quota == nil || usage.Total() < client.Quota.Bytes {
+				usage.Exceeded = false
+			} else if !usage.Exceeded {
+				usage.Exceeded = true
+				exceeded := Exceeded{Client: client, Usage: usage}
+				if client.Quota.Action == model.QuotaActionDisable && client.Enabled {
+					if exceeded.Client, err = saveEnabled(tx, client, false, now, "client.quota_exceed"); err != nil {
+						return err
+					}
+					exceeded.Disabled = true
+					usage.Disabled = true
+				} else {
+					entry := util.NewAuditLog(auditActor, "client.quota_exceed", auditTarget(client), nil, usage)
+					if err := tx.SaveAuditLog(entry); err != nil {
+						return err
+					}
+				}
+				result.Exceeded = append(result.Exceeded, exceeded)
+			}
+
+			if usage != before {
+				usage.UpdatedAt = now.UTC()
+				if err := tx.SaveTrafficUsage(usage); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// ApplyFunc returns an onChange function which applies the configuration right away, for when it is not applied
+// automatically after changes anyway
+func ApplyFunc(db store.IStore, tmplDir fs.FS) func() {
+	return apply.Background(db, tmplDir, auditActor)
+}
+
+// saveEnabled to enable or disable the client and record it in the audit log with the given action
+func saveEnabled(tx store.IStore, client model.Client, enabled bool, now time.Time, action string) (model.Client, error) {
+	before := client
+	client.Enabled = enabled
+	client.UpdatedAt = now.UTC()
+	client.Revision++
+	if err := tx.SaveClient(client); err != nil {
+		return client, err
+	}
+	return client, tx.SaveAuditLog(util.NewAuditLog(auditActor, action, auditTarget(client), before, client))
+}
+
+func auditTarget(client model.Client) string {
+	return fmt.Sprintf("%s (%s)", client.Name, client.ID)
+}
+
+// formatBytes returns n in binary units, like the status page
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,242 @@
+package traffic
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+	"github.com/ngoduykhanh/wireguard-ui/store/jsondb"
+)
+
+func TestCollect(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 5, 0, 0, time.UTC)
+	thisMonth := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	lastMonth := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	disableQuota := &model.Quota{Bytes: 1000, Period: model.QuotaPeriodMonth, Action: model.QuotaActionDisable}
+	notifyQuota := &model.Quota{Bytes: 1000, Period: model.QuotaPeriodMonth, Action: model.QuotaActionNotify}
+
+	tests := []struct {
+		name      string
+		quota     *model.Quota
+		enabled   bool
+		expiresAt *time.Time
+		// usage is the usage before the collection, nil for a client collected for the first time
+		usage *model.TrafficUsage
+		// peer is the state of the client's peer, nil if the interface is down
+		peer *Peer
+
+		wantReceived  int64
+		wantTransmit  int64
+		wantEnabled   bool
+		wantReenabled bool
+		wantExceeded  bool
+		wantDisabled  bool
+	}{
+		{
+			name:         "first collection counts the counters in full",
+			enabled:      true,
+			peer:         &Peer{ReceivedBytes: 100, TransmitBytes: 50},
+			wantReceived: 100,
+			wantTransmit: 50,
+			wantEnabled:  true,
+		},
+		{
+			name:    "growing counters add the difference",
+			enabled: true,
+			usage: &model.TrafficUsage{PeriodStart: thisMonth, ReceivedBytes: 150, TransmitBytes: 60, PublicKey: "key",
+				PeerReceivedBytes: 100, PeerTransmitBytes: 50},
+			peer:         &Peer{ReceivedBytes: 300, TransmitBytes: 80},
+			wantReceived: 350,
+			wantTransmit: 90,
+			wantEnabled:  true,
+		},
+		{
+			name:    "received counter reset counts the new counters in full",
+			enabled: true,
+			usage: &model.TrafficUsage{PeriodStart: thisMonth, ReceivedBytes: 1000, TransmitBytes: 1000, PublicKey: "key",
+				PeerReceivedBytes: 1000, PeerTransmitBytes: 10},
+			peer:         &Peer{ReceivedBytes: 200, TransmitBytes: 100},
+			wantReceived: 1200,
+			wantTransmit: 1100,
+			wantEnabled:  true,
+		},
+		{
+			name:    "transmit counter reset counts the new counters in full",
+			enabled: true,
+			usage: &model.TrafficUsage{PeriodStart: thisMonth, ReceivedBytes: 1000, TransmitBytes: 1000, PublicKey: "key",
+				PeerReceivedBytes: 10, PeerTransmitBytes: 1000},
+			peer:         &Peer{ReceivedBytes: 200, TransmitBytes: 100},
+			wantReceived: 1200,
+			wantTransmit: 1100,
+			wantEnabled:  true,
+		},
+		{
+			name:    "new public key counts the new counters in full",
+			enabled: true,
+			usage: &model.TrafficUsage{PeriodStart: thisMonth, ReceivedBytes: 10, TransmitBytes: 10, PublicKey: "old",
+				PeerReceivedBytes: 100, PeerTransmitBytes: 100},
+			peer:         &Peer{ReceivedBytes: 200, TransmitBytes: 100},
+			wantReceived: 210,
+			wantTransmit: 110,
+			wantEnabled:  true,
+		},
+		{
+			name:    "interface down keeps the usage",
+			enabled: true,
+			usage: &model.TrafficUsage{PeriodStart: thisMonth, ReceivedBytes: 10, TransmitBytes: 20, PublicKey: "key",
+				PeerReceivedBytes: 100, PeerTransmitBytes: 100},
+			wantReceived: 10,
+			wantTransmit: 20,
+			wantEnabled:  true,
+		},
+		{
+			name:    "new period counts from zero",
+			enabled: true,
+			usage: &model.TrafficUsage{PeriodStart: lastMonth, ReceivedBytes: 500, TransmitBytes: 500, PublicKey: "key",
+				PeerReceivedBytes: 100, PeerTransmitBytes: 100},
+			peer:         &Peer{ReceivedBytes: 110, TransmitBytes: 120},
+			wantReceived: 10,
+			wantTransmit: 20,
+			wantEnabled:  true,
+		},
+		{
+			name:    "new period enables a client disabled for its quota",
+			quota:   disableQuota,
+			enabled: false,
+			usage: &model.TrafficUsage{PeriodStart: lastMonth, ReceivedBytes: 800, TransmitBytes: 800, PublicKey: "key",
+				PeerReceivedBytes: 100, PeerTransmitBytes: 100, Exceeded: true, Disabled: true},
+			peer:          &Peer{ReceivedBytes: 100, TransmitBytes: 100},
+			wantEnabled:   true,
+			wantReenabled: true,
+		},
+		{
+			name:    "new period keeps a client disabled by hand",
+			quota:   disableQuota,
+			enabled: false,
+			usage: &model.TrafficUsage{PeriodStart: lastMonth, ReceivedBytes: 800, TransmitBytes: 800, PublicKey: "key",
+				PeerReceivedBytes: 100, PeerTransmitBytes: 100, Exceeded: true},
+			peer: &Peer{ReceivedBytes: 100, TransmitBytes: 100},
+		},
+		{
+			name:      "new period keeps an expired client disabled",
+			quota:     disableQuota,
+			enabled:   false,
+			expiresAt: &yesterday,
+			usage: &model.TrafficUsage{PeriodStart: lastMonth, ReceivedBytes: 800, TransmitBytes: 800, PublicKey: "key",
+				PeerReceivedBytes: 100, PeerTransmitBytes: 100, Exceeded: true, Disabled: true},
+			peer: &Peer{ReceivedBytes: 100, TransmitBytes: 100},
+		},
+		{
+			name:    "exceeding a quota disables the client",
+			quota:   disableQuota,
+			enabled: true,
+			usage: &model.TrafficUsage{PeriodStart: thisMonth, ReceivedBytes: 400, TransmitBytes: 400, PublicKey: "key",
+				PeerReceivedBytes: 100, PeerTransmitBytes: 100},
+			peer:         &Peer{ReceivedBytes: 300, TransmitBytes: 100},
+			wantReceived: 600,
+			wantTransmit: 400,
+			wantExceeded: true,
+			wantDisabled: true,
+		},
+		{
+			name:    "exceeding a quota to notify keeps the client enabled",
+			quota:   notifyQuota,
+			enabled: true,
+			usage: &model.TrafficUsage{PeriodStart: thisMonth, ReceivedBytes: 400, TransmitBytes: 400, PublicKey: "key",
+				PeerReceivedBytes: 100, PeerTransmitBytes: 100},
+			peer:         &Peer{ReceivedBytes: 300, TransmitBytes: 100},
+			wantReceived: 600,
+			wantTransmit: 400,
+			wantEnabled:  true,
+			wantExceeded: true,
+		},
+		{
+			name:    "quota exceeded before is not acted on again",
+			quota:   disableQuota,
+			enabled: true,
+			usage: &model.TrafficUsage{PeriodStart: thisMonth, ReceivedBytes: 600, TransmitBytes: 600, PublicKey: "key",
+				PeerReceivedBytes: 100, PeerTransmitBytes: 100, Exceeded: true},
+			peer:         &Peer{ReceivedBytes: 200, TransmitBytes: 100},
+			wantReceived: 700,
+			wantTransmit: 600,
+			wantEnabled:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the database is not initialized, which takes long to hash the password of the default user
+			dbPath := t.TempDir()
+			if err := os.Mkdir(filepath.Join(dbPath, model.TrafficUsageCollectionName), 0700); err != nil {
+				t.Fatal(err)
+			}
+			db, err := jsondb.New(dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := model.Client{ID: "client", Name: "client", PublicKey: "key", Interface: "wg0",
+				Enabled: tt.enabled, ExpiresAt: tt.expiresAt, Quota: tt.quota}
+			if err := db.SaveClient(client); err != nil {
+				t.Fatal(err)
+			}
+			if tt.usage != nil {
+				usage := *tt.usage
+				usage.ClientID = client.ID
+				if err := db.SaveTrafficUsage(usage); err != nil {
+					t.Fatal(err)
+				}
+			}
+			devices := map[string]map[string]Peer{}
+			if tt.peer != nil {
+				devices["wg0"] = map[string]Peer{"key": *tt.peer}
+			}
+
+			result, err := Collect(db, devices, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			usages, err := db.GetTrafficUsages()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var usage model.TrafficUsage
+			if len(usages) == 1 {
+				usage = usages[0]
+			}
+			if usage.ReceivedBytes != tt.wantReceived || usage.TransmitBytes != tt.wantTransmit {
+				t.Errorf("usage = %d received, %d transmitted, want %d, %d",
+					usage.ReceivedBytes, usage.TransmitBytes, tt.wantReceived, tt.wantTransmit)
+			}
+			if tt.peer != nil && (usage.PeerReceivedBytes != tt.peer.ReceivedBytes || usage.PeerTransmitBytes != tt.peer.TransmitBytes) {
+				t.Errorf("peer counters = %d, %d, want %d, %d", usage.PeerReceivedBytes, usage.PeerTransmitBytes,
+					tt.peer.ReceivedBytes, tt.peer.TransmitBytes)
+			}
+			if usage.Disabled != tt.wantDisabled {
+				t.Errorf("usage disabled = %v, want %v", usage.Disabled, tt.wantDisabled)
+			}
+
+			clientData, err := db.GetClientByID(client.ID, model.QRCodeSettings{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if clientData.Client.Enabled != tt.wantEnabled {
+				t.Errorf("client enabled = %v, want %v", clientData.Client.Enabled, tt.wantEnabled)
+			}
+			if reenabled := len(result.Enabled) == 1; reenabled != tt.wantReenabled {
+				t.Errorf("client enabled again = %v, want %v", reenabled, tt.wantReenabled)
+			}
+			if exceeded := len(result.Exceeded) == 1; exceeded != tt.wantExceeded {
+				t.Errorf("quota exceeded = %v, want %v", exceeded, tt.wantExceeded)
+			} else if exceeded && result.Exceeded[0].Disabled != tt.wantDisabled {
+				t.Errorf("disabled for the quota = %v, want %v", result.Exceeded[0].Disabled, tt.wantDisabled)
+			}
+			if result.Changed() != (tt.wantReenabled || tt.wantDisabled) {
+				t.Errorf("changed = %v, want %v", result.Changed(), tt.wantReenabled || tt.wantDisabled)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+)
+
+// AuditRedacted replaces the value of secret fields in audit log changes
+const AuditRedacted = "[redacted]"
+
+// auditSecretFields are never written to the audit log, only whether they were changed
+var auditSecretFields = map[string]bool{
+	"private_key":   true,
+	"preshared_key": true,
+	"password":      true,
+	"password_hash": true,
+}
+
+// auditIgnoredFields change with every update and would only add noise to the audit log
+var auditIgnoredFields = map[string]bool{
+	"updated_at": true,
+}
+
+// NewAuditLog to create an audit log entry for a change of a record. before and after are the record before and after
+// the change, nil for a record which is created or removed.
+func NewAuditLog(actor, action, target string, before, after interface{}) model.AuditLog {
+	return model.AuditLog{
+		ID:      xid.New().String(),
+		Time:    time.Now().UTC(),
+		Actor:   actor,
+		Action:  action,
+		Target:  target,
+		Changes: AuditChanges(before, after),
+	}
+}
+
+// AuditChanges to compare two records by the fields of their JSON encoding and list the changed ones, with the values
+// of secret fields redacted
+func AuditChanges(before, after interface{}) []model.AuditChange {
+	oldFields := auditFields(before)
+	newFields := auditFields(after)
+
+	names := make([]string, 0, len(oldFields)+len(newFields))
+	for name := range oldFields {
+		names = append(names, name)
+	}
+	for name := range newFields {
+		if _, found := oldFields[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := make([]model.AuditChange, 0)
+	for _, name := range names {
+		oldValue, newValue := oldFields[name], newFields[name]
+		if auditIgnoredFields[name] || reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		if auditSecretFields[name] {
+			oldValue, newValue = redactAuditValue(oldValue), redactAuditValue(newValue)
+		}
+		changes = append(changes, model.AuditChange{Field: name, Old: oldValue, New: newValue})
+	}
+	return changes
+}
+
+func auditFields(record interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if record == nil {
+		return fields
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return fields
+	}
+	// records which are not JSON objects are kept as a single field
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		var value interface{}
+		json.Unmarshal(encoded, &value)
+		return map[string]interface{}{"value": value}
+	}
+	return fields
+}
+
+// redactAuditValue keeps empty values, so the audit log still shows whether a secret was set or removed
+func redactAuditValue(value interface{}) interface{} {
+	if value == nil || value == "" {
+		return value
+	}
+	return AuditRedacted
+}
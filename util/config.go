@@ -3,6 +3,7 @@ package util
 import (
 	"net"
 	"strings"
+	"time"
 
 	"github.com/labstack/gommon/log"
 )
@@ -28,6 +29,23 @@ var (
 	BasePath           string
 	SubnetRanges       map[string]([]*net.IPNet)
 	SubnetRangesOrder  []string
+	// DBEncryptionKey encrypts private and preshared keys stored in the database, nil if encryption is disabled
+	DBEncryptionKey *EncryptionKey
+	// ClientHistoryLimit is the number of versions kept per client, 0 keeps all of them
+	ClientHistoryLimit int
+	// ApplyMode selects how the configuration is applied: file, wgctrl or both
+	ApplyMode string
+	// ConfigBackupDir is the directory for backups of the written config files, empty to use a directory next to the
+	// config file
+	ConfigBackupDir string
+	// ConfigBackupLimit is the number of backups kept of the config file, 0 keeps all of them
+	ConfigBackupLimit int
+	// PreApplyHook and PostApplyHook are shell commands run before and after the configuration is applied, empty
+	// to run none
+	PreApplyHook  string
+	PostApplyHook string
+	// ApplyHookTimeout is the time after which a hook command is killed
+	ApplyHookTimeout time.Duration
 )
 
 const (
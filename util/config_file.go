@@ -0,0 +1,167 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ngoduykhanh/wireguard-ui/wgconf"
+)
+
+// configBackupTimeLayout is used in the names of config backups, it sorts in chronological order
+const configBackupTimeLayout = "20060102T150405.000000000Z"
+
+// ErrInvalidConfigBackupName is returned for names which are not names of config backups
+var ErrInvalidConfigBackupName = errors.New("invalid config backup name")
+
+// ConfigBackup is a config file written earlier, kept to roll back to
+type ConfigBackup struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// ConfigBackupPath returns the directory holding the backups of the config file, ConfigBackupDir if set, otherwise
+// a directory next to the config file, e.g. /etc/wireguard/wg0.conf.backups
+func ConfigBackupPath(configPath string) string {
+	if ConfigBackupDir != "" {
+		return ConfigBackupDir
+	}
+	return configPath + ".backups"
+}
+
+// writeConfigFile to replace the config file atomically with content, keeping a backup of it. The content is
+// validated first, so a broken config never replaces a working one.
+func writeConfigFile(configPath string, content []byte) error {
+	if err := wgconf.Check(content); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	if err := writeFileAtomic(configPath, content); err != nil {
+		return err
+	}
+	return saveConfigBackup(configPath, content)
+}
+
+// writeFileAtomic to write content to a temporary file in the same directory and rename it to path, so the file is
+// either replaced completely or not at all. The mode of an existing file is kept, new files are only readable by
+// the owner.
+func writeFileAtomic(path string, content []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// saveConfigBackup to keep a copy of a written config, unless it is the same as the newest backup, and remove the
+// oldest backups beyond ConfigBackupLimit
+func saveConfigBackup(configPath string, content []byte) error {
+	dir := ConfigBackupPath(configPath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("cannot create config backup directory: %v", err)
+	}
+
+	backups, err := ListConfigBackups(configPath)
+	if err != nil {
+		return err
+	}
+	if len(backups) > 0 {
+		newest, err := ReadConfigBackup(configPath, backups[0].Name)
+		if err == nil && bytes.Equal(newest, content) {
+			return nil
+		}
+	}
+
+	name := filepath.Base(configPath) + "." + time.Now().UTC().Format(configBackupTimeLayout)
+	if err := os.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+		return fmt.Errorf("cannot write config backup: %v", err)
+	}
+
+	if ConfigBackupLimit <= 0 {
+		return nil
+	}
+	// the new backup is not in the list yet
+	for i := ConfigBackupLimit - 1; i < len(backups); i++ {
+		if err := os.Remove(filepath.Join(dir, backups[i].Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListConfigBackups to list the backups of the config file, newest first
+func ListConfigBackups(configPath string) ([]ConfigBackup, error) {
+	backups := []ConfigBackup{}
+	prefix := filepath.Base(configPath) + "."
+
+	entries, err := os.ReadDir(ConfigBackupPath(configPath))
+	if os.IsNotExist(err) {
+		return backups, nil
+	}
+	if err != nil {
+		return backups, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		t, err := time.Parse(configBackupTimeLayout, strings.TrimPrefix(entry.Name(), prefix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return backups, err
+		}
+		backups = append(backups, ConfigBackup{Name: entry.Name(), Time: t, Size: info.Size()})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// ReadConfigBackup to read the content of a backup of the config file
+func ReadConfigBackup(configPath string, name string) ([]byte, error) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, filepath.Base(configPath)+".") {
+		return nil, fmt.Errorf("%w %q", ErrInvalidConfigBackupName, name)
+	}
+	return os.ReadFile(filepath.Join(ConfigBackupPath(configPath), name))
+}
+
+// RollbackConfigFile to replace the config file with one of its backups
+func RollbackConfigFile(configPath string, name string) error {
+	content, err := ReadConfigBackup(configPath, name)
+	if err != nil {
+		return err
+	}
+	return writeConfigFile(configPath, content)
+}
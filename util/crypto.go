@@ -0,0 +1,214 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/ngoduykhanh/wireguard-ui/model"
+)
+
+// encryptedSecretPrefix marks values encrypted by EncryptSecret, and the version of the format. Values of version 1
+// were encrypted with the SHA-256 hash of the master key, they are still read.
+const (
+	encryptedSecretPrefix   = "enc:v2:"
+	encryptedSecretPrefixV1 = "enc:v1:"
+)
+
+// scrypt parameters to derive the keys from the master key, and the length of the salt stored in every value
+const (
+	scryptN    = 1 << 15
+	scryptR    = 8
+	scryptP    = 1
+	saltLength = 16
+)
+
+// ErrMissingEncryptionKey is returned when reading an encrypted value while no encryption key is configured
+var ErrMissingEncryptionKey = errors.New("the database contains encrypted keys, but no database encryption key is configured")
+
+// EncryptionKey derives the AES-256 keys of the encrypted values from the configured master key with scrypt. The salt
+// is stored in every value, and the keys are cached by salt as deriving them is slow on purpose.
+type EncryptionKey struct {
+	master string
+
+	mu      sync.Mutex
+	derived map[string][]byte
+	// salt encrypts new values. It is taken from the first value decrypted, so a database keeps a single salt and its
+	// key is derived once, or generated if no value was decrypted before.
+	salt []byte
+}
+
+// NewEncryptionKey returns the key for the configured master key, or nil if encryption is disabled
+func NewEncryptionKey(masterKey string) *EncryptionKey {
+	if masterKey == "" {
+		return nil
+	}
+	return &EncryptionKey{master: masterKey, derived: make(map[string][]byte)}
+}
+
+// deriveKey returns the AES-256 key for the salt, a new salt is generated and kept for the next values if salt is nil
+func (k *EncryptionKey) deriveKey(salt []byte) ([]byte, []byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if salt == nil {
+		if k.salt == nil {
+			k.salt = make([]byte, saltLength)
+			if _, err := rand.Read(k.salt); err != nil {
+				k.salt = nil
+				return nil, nil, err
+			}
+		}
+		salt = k.salt
+	} else if k.salt == nil {
+		k.salt = salt
+	}
+
+	if key, found := k.derived[string(salt)]; found {
+		return key, salt, nil
+	}
+	key, err := scrypt.Key([]byte(k.master), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	k.derived[string(salt)] = key
+	return key, salt, nil
+}
+
+// legacyKey returns the key values of version 1 were encrypted with
+func (k *EncryptionKey) legacyKey() []byte {
+	key := sha256.Sum256([]byte(k.master))
+	return key[:]
+}
+
+// IsEncryptedSecret to check whether a value was encrypted by EncryptSecret
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, encryptedSecretPrefix) || strings.HasPrefix(value, encryptedSecretPrefixV1)
+}
+
+// EncryptSecret to encrypt a value with AES-256-GCM. Empty values, values which are already encrypted, and all values
+// when key is nil are returned unchanged.
+func EncryptSecret(key *EncryptionKey, plaintext string) (string, error) {
+	if key == nil || plaintext == "" || IsEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+
+	aesKey, salt, err := key.deriveKey(nil)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(salt) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret to decrypt a value encrypted by EncryptSecret. Values which are not encrypted are returned unchanged,
+// so databases written before encryption was enabled remain readable.
+func DecryptSecret(key *EncryptionKey, value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	if key == nil {
+		return "", ErrMissingEncryptionKey
+	}
+
+	var aesKey []byte
+	var encoded string
+	if strings.HasPrefix(value, encryptedSecretPrefixV1) {
+		aesKey = key.legacyKey()
+		encoded = strings.TrimPrefix(value, encryptedSecretPrefixV1)
+	} else {
+		encodedSalt, rest, found := strings.Cut(strings.TrimPrefix(value, encryptedSecretPrefix), ":")
+		if !found {
+			return "", errors.New("cannot decode encrypted value: missing salt")
+		}
+		salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+		if err != nil || len(salt) == 0 {
+			return "", errors.New("cannot decode encrypted value: invalid salt")
+		}
+		if aesKey, _, err = key.deriveKey(salt); err != nil {
+			return "", err
+		}
+		encoded = rest
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode encrypted value: %v", err)
+	}
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("cannot decrypt value: ciphertext too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt value: wrong database encryption key or corrupted data")
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptClientKeys to encrypt the private and preshared key of a client with DBEncryptionKey before it is stored
+func EncryptClientKeys(client model.Client) (model.Client, error) {
+	var err error
+	if client.PrivateKey, err = EncryptSecret(DBEncryptionKey, client.PrivateKey); err != nil {
+		return client, err
+	}
+	if client.PresharedKey, err = EncryptSecret(DBEncryptionKey, client.PresharedKey); err != nil {
+		return client, err
+	}
+	return client, nil
+}
+
+// DecryptClientKeys to decrypt the private and preshared key of a client read from the database
+func DecryptClientKeys(client *model.Client) error {
+	var err error
+	if client.PrivateKey, err = DecryptSecret(DBEncryptionKey, client.PrivateKey); err != nil {
+		return fmt.Errorf("client %s: %v", client.ID, err)
+	}
+	if client.PresharedKey, err = DecryptSecret(DBEncryptionKey, client.PresharedKey); err != nil {
+		return fmt.Errorf("client %s: %v", client.ID, err)
+	}
+	return nil
+}
+
+// EncryptServerKeyPair to encrypt the private key of the server with DBEncryptionKey before it is stored
+func EncryptServerKeyPair(keyPair model.ServerKeypair) (model.ServerKeypair, error) {
+	var err error
+	keyPair.PrivateKey, err = EncryptSecret(DBEncryptionKey, keyPair.PrivateKey)
+	return keyPair, err
+}
+
+// DecryptServerKeyPair to decrypt the private key of the server read from the database
+func DecryptServerKeyPair(keyPair *model.ServerKeypair) error {
+	var err error
+	if keyPair.PrivateKey, err = DecryptSecret(DBEncryptionKey, keyPair.PrivateKey); err != nil {
+		return fmt.Errorf("server key pair: %v", err)
+	}
+	return nil
+}
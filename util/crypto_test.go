@@ -0,0 +1,158 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// encryptV1 encrypts a value the way version 1 did, with the SHA-256 hash of the master key
+func encryptV1(t *testing.T, key *EncryptionKey, plaintext string) string {
+	gcm, err := newGCM(key.legacyKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	return encryptedSecretPrefixV1 + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil))
+}
+
+// saltOf returns the encoded salt of a value of version 2
+func saltOf(value string) string {
+	salt, _, _ := strings.Cut(strings.TrimPrefix(value, encryptedSecretPrefix), ":")
+	return salt
+}
+
+func TestEncryptSecret(t *testing.T) {
+	key := NewEncryptionKey("correct horse battery staple")
+	encrypted, err := EncryptSecret(key, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		key       *EncryptionKey
+		plaintext string
+		// unchanged is set if the value is returned as it is
+		unchanged bool
+	}{
+		{name: "value", key: key, plaintext: "secret"},
+		{name: "without key", key: nil, plaintext: "secret", unchanged: true},
+		{name: "empty value", key: key, plaintext: "", unchanged: true},
+		{name: "encrypted value", key: key, plaintext: encrypted, unchanged: true},
+		{name: "encrypted value of version 1", key: key, plaintext: encryptV1(t, key, "secret"), unchanged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EncryptSecret(tt.key, tt.plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.unchanged {
+				if got != tt.plaintext {
+					t.Errorf("EncryptSecret = %q, want the value unchanged", got)
+				}
+				return
+			}
+			if !strings.HasPrefix(got, encryptedSecretPrefix) || strings.Contains(got, tt.plaintext) {
+				t.Errorf("EncryptSecret = %q, want a value of version 2", got)
+			}
+		})
+	}
+
+	// every value has its own nonce, but the values of a key share the salt so the key is derived once
+	again, err := EncryptSecret(key, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encrypted {
+		t.Error("the same value was encrypted twice to the same ciphertext")
+	}
+	if saltOf(again) != saltOf(encrypted) {
+		t.Errorf("salts %s and %s differ, want the salt of the key", saltOf(again), saltOf(encrypted))
+	}
+}
+
+func TestDecryptSecret(t *testing.T) {
+	key := NewEncryptionKey("correct horse battery staple")
+	wrongKey := NewEncryptionKey("wrong")
+	encrypted, err := EncryptSecret(key, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedV1 := encryptV1(t, key, "secret")
+	salt := saltOf(encrypted)
+
+	tests := []struct {
+		name    string
+		key     *EncryptionKey
+		value   string
+		want    string
+		wantErr string
+	}{
+		{name: "round trip", key: key, value: encrypted, want: "secret"},
+		{name: "round trip with a new instance of the key", key: NewEncryptionKey("correct horse battery staple"),
+			value: encrypted, want: "secret"},
+		{name: "version 1", key: key, value: encryptedV1, want: "secret"},
+		{name: "plaintext", key: key, value: "secret", want: "secret"},
+		{name: "plaintext without key", key: nil, value: "secret", want: "secret"},
+		{name: "empty value", key: key, value: "", want: ""},
+		{name: "wrong key", key: wrongKey, value: encrypted, wantErr: "wrong database encryption key"},
+		{name: "wrong key for version 1", key: wrongKey, value: encryptedV1, wantErr: "wrong database encryption key"},
+		{name: "without key", key: nil, value: encrypted, wantErr: ErrMissingEncryptionKey.Error()},
+		{name: "missing salt", key: key, value: encryptedSecretPrefix + "AAAA", wantErr: "missing salt"},
+		{name: "invalid salt", key: key, value: encryptedSecretPrefix + "!:AAAA", wantErr: "invalid salt"},
+		{name: "invalid ciphertext", key: key, value: encryptedSecretPrefix + salt + ":!", wantErr: "cannot decode"},
+		{name: "short ciphertext", key: key, value: encryptedSecretPrefix + salt + ":AAAA", wantErr: "too short"},
+		{name: "tampered ciphertext", key: key, value: encrypted[:len(encrypted)-4] + "AAA=",
+			wantErr: "wrong database encryption key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecryptSecret(tt.key, tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DecryptSecret error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("DecryptSecret = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := DecryptSecret(nil, encrypted); !errors.Is(err, ErrMissingEncryptionKey) {
+		t.Errorf("DecryptSecret without key error = %v, want ErrMissingEncryptionKey", err)
+	}
+}
+
+func TestEncryptionKeyAdoptsSalt(t *testing.T) {
+	encrypted, err := EncryptSecret(NewEncryptionKey("master"), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a key which read a value encrypts new values with its salt, so a database keeps a single salt
+	key := NewEncryptionKey("master")
+	if _, err := DecryptSecret(key, encrypted); err != nil {
+		t.Fatal(err)
+	}
+	again, err := EncryptSecret(key, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saltOf(again) != saltOf(encrypted) {
+		t.Errorf("salt = %s, want the salt of the value read %s", saltOf(again), saltOf(encrypted))
+	}
+}
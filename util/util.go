@@ -4,8 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/gob"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
@@ -13,8 +11,8 @@ import (
 	"math/rand"
 	"net"
 	"os"
-	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
@@ -23,14 +21,16 @@ import (
 	"github.com/ngoduykhanh/wireguard-ui/store"
 	"github.com/ngoduykhanh/wireguard-ui/telegram"
 	"github.com/skip2/go-qrcode"
-	"golang.org/x/mod/sumdb/dirhash"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	externalip "github.com/glendc/go-external-ip"
 	"github.com/labstack/gommon/log"
 	"github.com/ngoduykhanh/wireguard-ui/model"
-	"github.com/sdomino/scribble"
 )
 
+// interfaceNameRegexp matches the names Linux accepts for network interfaces, which are at most 15 bytes long
+var interfaceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_=+.-]{1,15}$`)
+
 var qrCodeSettings = model.QRCodeSettings{
 	Enabled:    true,
 	IncludeDNS: true,
@@ -60,7 +60,10 @@ func BuildClientConfig(client model.Client, server model.Server, setting model.G
 
 	peerAllowedIPs := fmt.Sprintf("AllowedIPs = %s\n", strings.Join(client.AllowedIPs, ","))
 
-	desiredHost := setting.EndpointAddress
+	desiredHost := server.Interface.EndpointAddress
+	if desiredHost == "" {
+		desiredHost = setting.EndpointAddress
+	}
 	desiredPort := server.Interface.ListenPort
 	if strings.Contains(desiredHost, ":") {
 		split := strings.Split(desiredHost, ":")
@@ -105,6 +108,90 @@ func ClientDefaultsFromEnv() model.ClientDefaults {
 	return clientDefaults
 }
 
+// ServerInterfaceDefaultsFromEnv to read the initial server interface from the environment or use sane defaults
+func ServerInterfaceDefaultsFromEnv() model.ServerInterface {
+	serverInterface := model.ServerInterface{}
+	serverInterface.ConfigFilePath = LookupEnvOrString(ConfigFilePathEnvVar, DefaultConfigFilePath)
+	serverInterface.Name = InterfaceNameFromPath(serverInterface.ConfigFilePath)
+	serverInterface.Addresses = LookupEnvOrStrings(ServerAddressesEnvVar, []string{DefaultServerAddress})
+	serverInterface.ListenPort = LookupEnvOrInt(ServerListenPortEnvVar, DefaultServerPort)
+	serverInterface.PostUp = LookupEnvOrString(ServerPostUpScriptEnvVar, "")
+	serverInterface.PostDown = LookupEnvOrString(ServerPostDownScriptEnvVar, "")
+	serverInterface.UpdatedAt = time.Now().UTC()
+
+	return serverInterface
+}
+
+// InterfaceNameFromPath returns the name of the config file without extension, which wg-quick uses as the name of
+// the interface
+func InterfaceNameFromPath(configFilePath string) string {
+	return strings.TrimSuffix(filepath.Base(configFilePath), ".conf")
+}
+
+// GenerateServerKeyPair to generate a new key pair for the server interface
+func GenerateServerKeyPair() (model.ServerKeypair, error) {
+	serverKeyPair := model.ServerKeypair{}
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return serverKeyPair, err
+	}
+	serverKeyPair.PrivateKey = key.String()
+	serverKeyPair.PublicKey = key.PublicKey().String()
+	serverKeyPair.UpdatedAt = time.Now().UTC()
+
+	return serverKeyPair, nil
+}
+
+// GlobalSettingsDefaultsFromEnv to read the initial global settings from the environment or use sane defaults
+func GlobalSettingsDefaultsFromEnv() (model.GlobalSetting, error) {
+	globalSetting := model.GlobalSetting{}
+	endpointAddress := LookupEnvOrString(EndpointAddressEnvVar, "")
+	if endpointAddress == "" {
+		// automatically find an external IP address
+		publicInterface, err := GetPublicIP()
+		if err != nil {
+			return globalSetting, err
+		}
+		endpointAddress = publicInterface.IPAddress
+	}
+
+	globalSetting.EndpointAddress = endpointAddress
+	globalSetting.DNSServers = LookupEnvOrStrings(DNSEnvVar, []string{DefaultDNS})
+	globalSetting.MTU = LookupEnvOrInt(MTUEnvVar, DefaultMTU)
+	globalSetting.PersistentKeepalive = LookupEnvOrInt(PersistentKeepaliveEnvVar, DefaultPersistentKeepalive)
+	globalSetting.FirewallMark = LookupEnvOrString(FirewallMarkEnvVar, DefaultFirewallMark)
+	globalSetting.Table = LookupEnvOrString(TableEnvVar, DefaultTable)
+	globalSetting.UpdatedAt = time.Now().UTC()
+	globalSetting.Revision = 1
+
+	return globalSetting, nil
+}
+
+// UserDefaultsFromEnv to read the initial admin user from the environment or use sane defaults
+func UserDefaultsFromEnv() (model.User, error) {
+	user := model.User{}
+	user.Username = LookupEnvOrString(UsernameEnvVar, DefaultUsername)
+	user.Admin = DefaultIsAdmin
+	user.Revision = 1
+	user.PasswordHash = LookupEnvOrString(PasswordHashEnvVar, "")
+	if user.PasswordHash == "" {
+		user.PasswordHash = LookupEnvOrFile(PasswordHashFileEnvVar, "")
+		if user.PasswordHash == "" {
+			plaintext := LookupEnvOrString(PasswordEnvVar, DefaultPassword)
+			if plaintext == DefaultPassword {
+				plaintext = LookupEnvOrFile(PasswordFileEnvVar, DefaultPassword)
+			}
+			hash, err := HashPassword(plaintext)
+			if err != nil {
+				return user, err
+			}
+			user.PasswordHash = hash
+		}
+	}
+
+	return user, nil
+}
+
 // ContainsCIDR to check if ipnet1 contains ipnet2
 // https://stackoverflow.com/a/40406619/6111641
 // https://go.dev/play/p/Q4J-JEN3sF
@@ -157,6 +244,24 @@ func ValidateExtraAllowedIPs(cidrs []string) bool {
 	return true
 }
 
+// ValidateQuota to validate the quota of a client, nil being unlimited
+func ValidateQuota(quota *model.Quota) bool {
+	if quota == nil {
+		return true
+	}
+	switch quota.Period {
+	case model.QuotaPeriodDay, model.QuotaPeriodWeek, model.QuotaPeriodMonth:
+	default:
+		return false
+	}
+	switch quota.Action {
+	case model.QuotaActionDisable, model.QuotaActionNotify:
+	default:
+		return false
+	}
+	return quota.Bytes > 0
+}
+
 // ValidateServerAddresses to validate allowed ip addresses in CIDR format
 func ValidateServerAddresses(cidrs []string) bool {
 	if ValidateCIDRList(cidrs, false) == false {
@@ -165,6 +270,11 @@ func ValidateServerAddresses(cidrs []string) bool {
 	return true
 }
 
+// ValidateInterfaceName to check that name can be used as the name of a network interface
+func ValidateInterfaceName(name string) bool {
+	return interfaceNameRegexp.MatchString(name) && name != "." && name != ".."
+}
+
 // ValidateIPAddress to validate the IPv4 and IPv6 address
 func ValidateIPAddress(ip string) bool {
 	if net.ParseIP(ip) == nil {
@@ -259,167 +369,6 @@ func GetIPFromCIDR(cidr string) (string, error) {
 	return ip.String(), nil
 }
 
-// GetAllocatedIPs to get all ip addresses allocated to clients and server
-func GetAllocatedIPs(ignoreClientID string) ([]string, error) {
-	allocatedIPs := make([]string, 0)
-
-	// initialize database directory
-	dir := "./db"
-	db, err := scribble.New(dir, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	// read server information
-	serverInterface := model.ServerInterface{}
-	if err := db.Read("server", "interfaces", &serverInterface); err != nil {
-		return nil, err
-	}
-
-	// append server's addresses to the result
-	for _, cidr := range serverInterface.Addresses {
-		ip, err := GetIPFromCIDR(cidr)
-		if err != nil {
-			return nil, err
-		}
-		allocatedIPs = append(allocatedIPs, ip)
-	}
-
-	// read client information
-	records, err := db.ReadAll("clients")
-	if err != nil {
-		return nil, err
-	}
-
-	// append client's addresses to the result
-	for _, f := range records {
-		client := model.Client{}
-		if err := json.Unmarshal(f, &client); err != nil {
-			return nil, err
-		}
-
-		if client.ID != ignoreClientID {
-			for _, cidr := range client.AllocatedIPs {
-				ip, err := GetIPFromCIDR(cidr)
-				if err != nil {
-					return nil, err
-				}
-				allocatedIPs = append(allocatedIPs, ip)
-			}
-		}
-	}
-
-	return allocatedIPs, nil
-}
-
-// inc from https://play.golang.org/p/m8TNTtygK0
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
-	}
-}
-
-// GetBroadcastIP func to get the broadcast ip address of a network
-func GetBroadcastIP(n *net.IPNet) net.IP {
-	var broadcast net.IP
-	if len(n.IP) == 4 {
-		broadcast = net.ParseIP("0.0.0.0").To4()
-	} else {
-		broadcast = net.ParseIP("::")
-	}
-	for i := 0; i < len(n.IP); i++ {
-		broadcast[i] = n.IP[i] | ^n.Mask[i]
-	}
-	return broadcast
-}
-
-// GetBroadcastAndNetworkAddrsLookup get the ip address that can't be used with current server interfaces
-func GetBroadcastAndNetworkAddrsLookup(interfaceAddresses []string) map[string]bool {
-	list := make(map[string]bool)
-	for _, ifa := range interfaceAddresses {
-		_, netAddr, err := net.ParseCIDR(ifa)
-		if err != nil {
-			continue
-		}
-
-		broadcastAddr := GetBroadcastIP(netAddr).String()
-		networkAddr := netAddr.IP.String()
-		list[broadcastAddr] = true
-		list[networkAddr] = true
-	}
-	return list
-}
-
-// GetAvailableIP get the ip address that can be allocated from an CIDR
-// We need interfaceAddresses to find real broadcast and network addresses
-func GetAvailableIP(cidr string, allocatedList, interfaceAddresses []string) (string, error) {
-	ip, netAddr, err := net.ParseCIDR(cidr)
-	if err != nil {
-		return "", err
-	}
-
-	unavailableIPs := GetBroadcastAndNetworkAddrsLookup(interfaceAddresses)
-
-	for ip := ip.Mask(netAddr.Mask); netAddr.Contains(ip); inc(ip) {
-		available := true
-		suggestedAddr := ip.String()
-		for _, allocatedAddr := range allocatedList {
-			if suggestedAddr == allocatedAddr {
-				available = false
-				break
-			}
-		}
-		if available && !unavailableIPs[suggestedAddr] {
-			return suggestedAddr, nil
-		}
-	}
-
-	return "", errors.New("no more available ip address")
-}
-
-// ValidateIPAllocation to validate the list of client's ip allocation
-// They must have a correct format and available in serverAddresses space
-func ValidateIPAllocation(serverAddresses []string, ipAllocatedList []string, ipAllocationList []string) (bool, error) {
-	for _, clientCIDR := range ipAllocationList {
-		ip, _, _ := net.ParseCIDR(clientCIDR)
-
-		// clientCIDR must be in CIDR format
-		if ip == nil {
-			return false, fmt.Errorf("invalid ip allocation input %s. Must be in CIDR format", clientCIDR)
-		}
-
-		// return false immediately if the ip is already in use (in ipAllocatedList)
-		for _, item := range ipAllocatedList {
-			if item == ip.String() {
-				return false, fmt.Errorf("IP %s already allocated", ip)
-			}
-		}
-
-		// even if it is not in use, we still need to check if it
-		// belongs to a network of the server.
-		var isValid = false
-		for _, serverCIDR := range serverAddresses {
-			_, serverNet, _ := net.ParseCIDR(serverCIDR)
-			if serverNet.Contains(ip) {
-				isValid = true
-				break
-			}
-		}
-
-		// current ip allocation is valid, check the next one
-		if isValid {
-			continue
-		} else {
-			return false, fmt.Errorf("IP %s does not belong to any network addresses of WireGuard server", ip)
-		}
-	}
-
-	return true, nil
-}
-
 // findSubnetRangeForIP to find first SR for IP, and cache the match
 func findSubnetRangeForIP(cidr string) (uint16, error) {
 	ip, _, err := net.ParseCIDR(cidr)
@@ -465,18 +414,20 @@ func ValidateAndFixSubnetRanges(db store.IStore) error {
 		return nil
 	}
 
-	server, err := db.GetServer()
+	servers, err := db.GetServers()
 	if err != nil {
 		return err
 	}
 	var serverSubnets []*net.IPNet
-	for _, addr := range server.Interface.Addresses {
-		addr = strings.TrimSpace(addr)
-		_, netAddr, err := net.ParseCIDR(addr)
-		if err != nil {
-			return err
+	for _, server := range servers {
+		for _, addr := range server.Interface.Addresses {
+			addr = strings.TrimSpace(addr)
+			_, netAddr, err := net.ParseCIDR(addr)
+			if err != nil {
+				return err
+			}
+			serverSubnets = append(serverSubnets, netAddr)
 		}
-		serverSubnets = append(serverSubnets, netAddr)
 	}
 
 	for _, rng := range SubnetRangesOrder {
@@ -540,8 +491,20 @@ func GetSubnetRangesString() string {
 	return strings.TrimSpace(strB.String())
 }
 
-// WriteWireGuardServerConfig to write Wireguard server config. e.g. wg0.conf
+// WriteWireGuardServerConfig to write the config file of a Wireguard server interface. e.g. wg0.conf
 func WriteWireGuardServerConfig(tmplDir fs.FS, serverConfig model.Server, clientDataList []model.ClientData, usersList []model.User, globalSettings model.GlobalSetting) error {
+	var buf bytes.Buffer
+	if err := RenderWireGuardServerConfig(&buf, tmplDir, serverConfig, clientDataList, usersList, globalSettings); err != nil {
+		return err
+	}
+
+	// write config file to disk
+	return writeConfigFile(serverConfig.Interface.ConfigFilePath, buf.Bytes())
+}
+
+// RenderWireGuardServerConfig to render Wireguard server config with the wg.conf template, without writing it. Only
+// the clients of the server's interface are included.
+func RenderWireGuardServerConfig(w io.Writer, tmplDir fs.FS, serverConfig model.Server, clientDataList []model.ClientData, usersList []model.User, globalSettings model.GlobalSetting) error {
 	var tmplWireguardConf string
 
 	// if set, read wg.conf template from WgConfTemplate
@@ -563,6 +526,9 @@ func WriteWireGuardServerConfig(tmplDir fs.FS, serverConfig model.Server, client
 	// escape multiline notes
 	escapedClientDataList := []model.ClientData{}
 	for _, cd := range clientDataList {
+		if cd.Client.Interface != serverConfig.Interface.Name {
+			continue
+		}
 		if cd.Client.AdditionalNotes != "" {
 			cd.Client.AdditionalNotes = strings.ReplaceAll(cd.Client.AdditionalNotes, "\n", "\n# ")
 		}
@@ -575,12 +541,6 @@ func WriteWireGuardServerConfig(tmplDir fs.FS, serverConfig model.Server, client
 		return err
 	}
 
-	// write config file to disk
-	f, err := os.Create(globalSettings.ConfigFilePath)
-	if err != nil {
-		return err
-	}
-
 	config := map[string]interface{}{
 		"serverConfig":   serverConfig,
 		"clientDataList": escapedClientDataList,
@@ -588,13 +548,7 @@ func WriteWireGuardServerConfig(tmplDir fs.FS, serverConfig model.Server, client
 		"usersList":      usersList,
 	}
 
-	err = t.Execute(f, config)
-	if err != nil {
-		return err
-	}
-	f.Close()
-
-	return nil
+	return t.Execute(w, config)
 }
 
 // SendRequestedConfigsToTelegram to send client all their configs. Returns failed configs list.
@@ -613,7 +567,7 @@ func SendRequestedConfigsToTelegram(db store.IStore, userid int64) []string {
 			}
 
 			// build config
-			server, _ := db.GetServer()
+			server, _ := db.GetServer(clientData.Client.Interface)
 			globalSettings, _ := db.GetGlobalSettings()
 			config := BuildClientConfig(*clientData.Client, server, globalSettings)
 			configData := []byte(config)
@@ -727,42 +681,6 @@ func ParseLogLevel(lvl string) (log.Lvl, error) {
 	}
 }
 
-// GetCurrentHash returns current hashes
-func GetCurrentHash(db store.IStore) (string, string) {
-	hashClients, _ := dirhash.HashDir(path.Join(db.GetPath(), "clients"), "prefix", dirhash.Hash1)
-	files := append([]string(nil), "prefix/global_settings.json", "prefix/interfaces.json", "prefix/keypair.json")
-
-	osOpen := func(name string) (io.ReadCloser, error) {
-		return os.Open(filepath.Join(path.Join(db.GetPath(), "server"), strings.TrimPrefix(name, "prefix")))
-	}
-	hashServer, _ := dirhash.Hash1(files, osOpen)
-
-	return hashClients, hashServer
-}
-
-func HashesChanged(db store.IStore) bool {
-	old, _ := db.GetHashes()
-	oldClient := old.Client
-	oldServer := old.Server
-	newClient, newServer := GetCurrentHash(db)
-
-	if oldClient != newClient {
-		//fmt.Println("Hash for client differs")
-		return true
-	}
-	if oldServer != newServer {
-		//fmt.Println("Hash for server differs")
-		return true
-	}
-	return false
-}
-
-func UpdateHashes(db store.IStore) error {
-	var clientServerHashes model.ClientServerHashes
-	clientServerHashes.Client, clientServerHashes.Server = GetCurrentHash(db)
-	return db.SaveHashes(clientServerHashes)
-}
-
 func RandomString(length int) string {
 	var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
 	charset := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
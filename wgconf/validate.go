@@ -0,0 +1,95 @@
+package wgconf
+
+import (
+	"net"
+	"sort"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Check to parse a config file and validate it as a whole, see Parse and Validate. The returned error is Errors with
+// the problems found by both.
+func Check(content []byte) error {
+	var errs Errors
+	config, err := Parse(content)
+	if err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	if err := config.Validate(); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line
+	})
+	return errs.err()
+}
+
+// Validate to check the parts of the config which depend on each other: the interface and its private key are
+// required, each peer needs a public key, public keys must be unique, and no network may be allowed for two peers.
+// A network may contain the networks of other peers, e.g. extra allowed IPs routing a site behind a peer, as WireGuard
+// routes to the peer with the longest prefix. It may not contain the address of another peer though, a host prefix,
+// as the peer could then send packets in the name of the other one.
+func (c *Config) Validate() error {
+	var errs Errors
+
+	var interfacePublicKey string
+	if c.Interface == nil {
+		errs.add(0, "missing [Interface] section")
+	} else if c.Interface.PrivateKey == "" {
+		errs.add(c.Interface.Line, "missing PrivateKey in [Interface] section")
+	} else if key, err := wgtypes.ParseKey(c.Interface.PrivateKey); err == nil {
+		interfacePublicKey = key.PublicKey().String()
+	}
+
+	type peerNetwork struct {
+		network *net.IPNet
+		line    int
+	}
+	publicKeys := map[string]int{}
+	networks := map[string]int{}
+	var broader, hosts []peerNetwork
+	for _, peer := range c.Peers {
+		if peer.PublicKey == "" {
+			errs.add(peer.Line, "missing PublicKey in [Peer] section")
+		} else if line, found := publicKeys[peer.PublicKey]; found {
+			errs.add(peer.Line, "duplicate PublicKey %s, also used by the peer on line %d", peer.PublicKey, line)
+		} else if peer.PublicKey == interfacePublicKey {
+			errs.add(peer.Line, "the peer has the public key of the interface")
+		} else {
+			publicKeys[peer.PublicKey] = peer.Line
+		}
+
+		for _, prefix := range peer.AllowedIPs {
+			_, network, err := net.ParseCIDR(prefix)
+			if err != nil {
+				// reported by Parse
+				continue
+			}
+			if line, found := networks[network.String()]; found {
+				if line != peer.Line {
+					errs.add(peer.Line, "AllowedIPs %s is also allowed for the peer on line %d", network, line)
+				}
+				continue
+			}
+			networks[network.String()] = peer.Line
+			if ones, bits := network.Mask.Size(); ones == bits {
+				hosts = append(hosts, peerNetwork{network, peer.Line})
+			} else {
+				broader = append(broader, peerNetwork{network, peer.Line})
+			}
+		}
+	}
+
+	for _, b := range broader {
+		for _, host := range hosts {
+			if host.line != b.line && b.network.Contains(host.network.IP) {
+				errs.add(b.line, "AllowedIPs %s contains the address %s of the peer on line %d, a broader network "+
+					"such as extra allowed IPs may only contain the networks of other peers, not their addresses",
+					b.network, host.network, host.line)
+				break
+			}
+		}
+	}
+
+	return errs.err()
+}
@@ -0,0 +1,155 @@
+package wgconf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	const iface = `[Interface]
+PrivateKey = ` + testPrivateKey + `
+Address = 10.252.1.1/24
+`
+	tests := []struct {
+		name       string
+		content    string
+		wantErrors []string
+	}{
+		{
+			name: "valid config",
+			content: iface + `
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+AllowedIPs = 10.252.1.2/32
+
+[Peer]
+PublicKey = ` + testPublicKey2 + `
+AllowedIPs = 10.252.1.3/32, fd00::3/128
+`,
+		},
+		{
+			name:       "missing interface",
+			content:    "[Peer]\nPublicKey = " + testPublicKey1 + "\n",
+			wantErrors: []string{"missing [Interface] section"},
+		},
+		{
+			name:       "missing private key",
+			content:    "[Interface]\nAddress = 10.252.1.1/24\n",
+			wantErrors: []string{"line 1: missing PrivateKey in [Interface] section"},
+		},
+		{
+			name: "public keys",
+			content: iface + `
+[Peer]
+AllowedIPs = 10.252.1.2/32
+
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+
+[Peer]
+PublicKey = ` + testInterfacePublicKey + `
+`,
+			wantErrors: []string{
+				"line 5: missing PublicKey in [Peer] section",
+				"line 11: duplicate PublicKey " + testPublicKey1 + ", also used by the peer on line 8",
+				"line 14: the peer has the public key of the interface",
+			},
+		},
+		{
+			name: "network allowed for two peers",
+			content: iface + `
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+AllowedIPs = 10.252.1.2/32, 192.168.0.0/24
+
+[Peer]
+PublicKey = ` + testPublicKey2 + `
+AllowedIPs = 10.252.1.3/32, 192.168.0.1/24
+`,
+			wantErrors: []string{"line 9: AllowedIPs 192.168.0.0/24 is also allowed for the peer on line 5"},
+		},
+		{
+			name: "network repeated within a peer",
+			content: iface + `
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+AllowedIPs = 10.252.1.2/32, 10.252.1.2
+`,
+		},
+		{
+			name: "network containing the networks of other peers",
+			content: iface + `
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+AllowedIPs = 10.252.1.2/32, 192.168.0.0/16
+
+[Peer]
+PublicKey = ` + testPublicKey2 + `
+AllowedIPs = 10.252.1.3/32, 192.168.5.0/24
+`,
+		},
+		{
+			name: "network containing the address of a later peer",
+			content: iface + `
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+AllowedIPs = 10.252.1.2/32, 10.252.1.0/24
+
+[Peer]
+PublicKey = ` + testPublicKey2 + `
+AllowedIPs = 10.252.1.3/32
+`,
+			wantErrors: []string{"line 5: AllowedIPs 10.252.1.0/24 contains the address 10.252.1.3/32 of the peer on " +
+				"line 9, a broader network such as extra allowed IPs may only contain the networks of other peers, " +
+				"not their addresses"},
+		},
+		{
+			name: "network containing the address of an earlier peer",
+			content: iface + `
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+AllowedIPs = fd00::2/128
+
+[Peer]
+PublicKey = ` + testPublicKey2 + `
+AllowedIPs = fd00::3/128, ::/0
+`,
+			wantErrors: []string{"line 9: AllowedIPs ::/0 contains the address fd00::2/128 of the peer on line 5, a " +
+				"broader network such as extra allowed IPs may only contain the networks of other peers, not their " +
+				"addresses"},
+		},
+		{
+			name: "errors of parse and validate sorted by line",
+			content: iface + `ListenPort = x
+
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+`,
+			wantErrors: []string{
+				"line 4: invalid ListenPort \"x\", must be a number from 0 to 65535",
+				"line 9: duplicate PublicKey " + testPublicKey1 + ", also used by the peer on line 6",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check([]byte(tt.content))
+			var got []string
+			if err != nil {
+				for _, e := range err.(Errors) {
+					got = append(got, e.Error())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantErrors) {
+				t.Errorf("errors = %q, want %q", got, tt.wantErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+// Package wgconf parses and validates WireGuard config files in the wg-quick format
+package wgconf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Config is a parsed wg-quick config file
+type Config struct {
+	Interface *Interface
+	Peers     []*Peer
+}
+
+// Interface is the [Interface] section of a config file
+type Interface struct {
+	// Line is the line of the section header
+	Line int
+	// Comments are the "Key: value" comments in front of the section, with lower case keys
+	Comments   map[string]string
+	PrivateKey string
+	Address    []string
+	ListenPort int
+	FwMark     string
+	DNS        []string
+	MTU        int
+	Table      string
+	PreUp      []string
+	PostUp     []string
+	PreDown    []string
+	PostDown   []string
+	SaveConfig bool
+}
+
+// Peer is a [Peer] section of a config file
+type Peer struct {
+	// Line is the line of the section header
+	Line int
+	// Comments are the "Key: value" comments in front of the section, with lower case keys, e.g. "name" in the files
+	// written by wireguard-ui
+	Comments            map[string]string
+	PublicKey           string
+	PresharedKey        string
+	AllowedIPs          []string
+	Endpoint            string
+	PersistentKeepalive int
+}
+
+// Error is a problem in a config file
+type Error struct {
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Errors are all problems found in a config file
+type Errors []*Error
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *Errors) add(line int, format string, args ...interface{}) {
+	*e = append(*e, &Error{Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns nil if there are no errors, so it can be returned as error
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// the keys of each section, as written in the documentation of wg-quick. Keys are case insensitive.
+var (
+	interfaceKeys = keyNames("PrivateKey", "Address", "ListenPort", "FwMark", "DNS", "MTU", "Table", "PreUp", "PostUp",
+		"PreDown", "PostDown", "SaveConfig")
+	peerKeys = keyNames("PublicKey", "PresharedKey", "AllowedIPs", "Endpoint", "PersistentKeepalive")
+	// keys which may be given more than once, their values are combined
+	listKeys = keyNames("Address", "DNS", "PreUp", "PostUp", "PreDown", "PostDown", "AllowedIPs")
+)
+
+func keyNames(names ...string) map[string]string {
+	keys := make(map[string]string, len(names))
+	for _, name := range names {
+		keys[strings.ToLower(name)] = name
+	}
+	return keys
+}
+
+// Parse to read a config file. The syntax and the values of the keys are checked, use Check to also check the
+// config as a whole. Parsing continues after errors, the returned error is Errors with all problems found.
+func Parse(content []byte) (*Config, error) {
+	config := &Config{}
+	var errs Errors
+
+	// section is the lower case name of the current section, sectionHeader its header as written
+	var section, sectionHeader string
+	var sectionLine int
+	var seen map[string]bool
+	// iface is the current [Interface] section, a duplicate section is parsed for its errors only
+	var iface *Interface
+	comments := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		// wg-quick drops everything after a #
+		if i := strings.Index(line, "#"); i >= 0 {
+			if i == 0 {
+				if key, value, found := strings.Cut(strings.TrimSpace(line[1:]), ":"); found && !strings.Contains(key, " ") {
+					comments[strings.ToLower(key)] = strings.TrimSpace(value)
+				}
+				continue
+			}
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				errs.add(lineNo, "invalid section header %q", line)
+				continue
+			}
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			sectionHeader = line
+			sectionLine = lineNo
+			seen = map[string]bool{}
+			switch section {
+			case "interface":
+				iface = &Interface{Line: lineNo, Comments: comments}
+				if config.Interface != nil {
+					errs.add(lineNo, "duplicate [Interface] section, the first one is on line %d", config.Interface.Line)
+				} else {
+					config.Interface = iface
+				}
+			case "peer":
+				config.Peers = append(config.Peers, &Peer{Line: lineNo, Comments: comments})
+			default:
+				errs.add(lineNo, "unknown section %s", line)
+			}
+			comments = map[string]string{}
+			continue
+		}
+		// comments belong to the section following them
+		comments = map[string]string{}
+
+		rawKey, value, found := strings.Cut(line, "=")
+		if !found {
+			errs.add(lineNo, "expected key = value, got %q", line)
+			continue
+		}
+		rawKey, value = strings.TrimSpace(rawKey), strings.TrimSpace(value)
+		key := strings.ToLower(rawKey)
+
+		switch section {
+		case "":
+			errs.add(lineNo, "key %s outside of a section", rawKey)
+			continue
+		case "interface", "peer":
+		default:
+			// the section header was reported already
+			continue
+		}
+
+		keys := interfaceKeys
+		if section == "peer" {
+			keys = peerKeys
+		}
+		name, known := keys[key]
+		if !known {
+			errs.add(lineNo, "unknown key %s in %s section on line %d", rawKey, sectionHeader, sectionLine)
+			continue
+		}
+		if _, list := listKeys[key]; !list && seen[key] {
+			errs.add(lineNo, "duplicate key %s", name)
+			continue
+		}
+		seen[key] = true
+
+		if section == "interface" {
+			parseInterfaceKey(iface, name, value, lineNo, &errs)
+		} else {
+			parsePeerKey(config.Peers[len(config.Peers)-1], name, value, lineNo, &errs)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs.add(0, "cannot read config: %v", err)
+	}
+
+	return config, errs.err()
+}
+
+func parseInterfaceKey(iface *Interface, name string, value string, line int, errs *Errors) {
+	switch name {
+	case "PrivateKey":
+		checkKey(name, value, line, errs)
+		iface.PrivateKey = value
+	case "Address":
+		iface.Address = append(iface.Address, parsePrefixes(name, value, line, errs)...)
+	case "ListenPort":
+		iface.ListenPort = parseInt(name, value, 0, 65535, line, errs)
+	case "FwMark":
+		if value != "off" && value != "" {
+			if _, err := strconv.ParseUint(value, 0, 32); err != nil {
+				errs.add(line, "invalid FwMark %q, must be a number or off", value)
+				return
+			}
+		}
+		iface.FwMark = value
+	case "DNS":
+		iface.DNS = append(iface.DNS, splitList(value)...)
+	case "MTU":
+		iface.MTU = parseInt(name, value, 0, 65535, line, errs)
+	case "Table":
+		iface.Table = value
+	case "PreUp":
+		iface.PreUp = appendCommand(iface.PreUp, value)
+	case "PostUp":
+		iface.PostUp = appendCommand(iface.PostUp, value)
+	case "PreDown":
+		iface.PreDown = appendCommand(iface.PreDown, value)
+	case "PostDown":
+		iface.PostDown = appendCommand(iface.PostDown, value)
+	case "SaveConfig":
+		switch value {
+		case "true":
+			iface.SaveConfig = true
+		case "false", "":
+		default:
+			errs.add(line, "invalid SaveConfig %q, must be true or false", value)
+		}
+	}
+}
+
+func parsePeerKey(peer *Peer, name string, value string, line int, errs *Errors) {
+	switch name {
+	case "PublicKey":
+		checkKey(name, value, line, errs)
+		peer.PublicKey = value
+	case "PresharedKey":
+		checkKey(name, value, line, errs)
+		peer.PresharedKey = value
+	case "AllowedIPs":
+		peer.AllowedIPs = append(peer.AllowedIPs, parsePrefixes(name, value, line, errs)...)
+	case "Endpoint":
+		host, port, err := net.SplitHostPort(value)
+		if err != nil || host == "" {
+			errs.add(line, "invalid Endpoint %q, must be host:port", value)
+			return
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			errs.add(line, "invalid Endpoint %q, the port must be a number up to 65535", value)
+			return
+		}
+		peer.Endpoint = value
+	case "PersistentKeepalive":
+		if value == "off" {
+			return
+		}
+		peer.PersistentKeepalive = parseInt(name, value, 0, 65535, line, errs)
+	}
+}
+
+// checkKey to check that value is a base64 encoded key
+func checkKey(name string, value string, line int, errs *Errors) {
+	if _, err := wgtypes.ParseKey(value); err != nil {
+		errs.add(line, "invalid %s, must be a base64 encoded 32 byte key", name)
+	}
+}
+
+// parsePrefixes to parse a comma separated list of addresses with optional prefix length, e.g. 10.0.0.1/24, and
+// return them in CIDR notation. Addresses without a prefix length are host addresses.
+func parsePrefixes(name string, value string, line int, errs *Errors) []string {
+	var prefixes []string
+	for _, item := range splitList(value) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				errs.add(line, "invalid address %q in %s", item, name)
+				continue
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		if _, _, err := net.ParseCIDR(item); err != nil {
+			errs.add(line, "invalid address %q in %s", item, name)
+			continue
+		}
+		prefixes = append(prefixes, item)
+	}
+	return prefixes
+}
+
+func parseInt(name string, value string, min int, max int, line int, errs *Errors) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		errs.add(line, "invalid %s %q, must be a number from %d to %d", name, value, min, max)
+		return 0
+	}
+	return n
+}
+
+// appendCommand to add a script command, empty commands are ignored by wg-quick
+func appendCommand(commands []string, command string) []string {
+	if command == "" {
+		return commands
+	}
+	return append(commands, command)
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
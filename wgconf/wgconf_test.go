@@ -0,0 +1,151 @@
+package wgconf
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	testPrivateKey = "aH+4rNAsVGP//KZ2qqw+6gI36R0wdmf5bo8L2Ofxt1M="
+	// testInterfacePublicKey is the public key of testPrivateKey
+	testInterfacePublicKey = "0XAb/1L76DbWYEc7mR4+J1J51ww45dSe4aijGIqF3lU="
+	testPublicKey1         = "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+	testPublicKey2         = "AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="
+)
+
+func TestParseComments(t *testing.T) {
+	content := `# This file was generated using wireguard-ui
+# Address updated at:     2024-01-01
+[Interface]
+PrivateKey = ` + testPrivateKey + `
+
+# ID:           cn1
+# Name:         Alice
+# Email:        alice@example.com
+# Notes:
+# free text without a key
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+
+# Name: Bob
+PublicKey = ` + testPublicKey2 + `
+
+[Peer]
+PublicKey = ` + testPublicKey2 + `
+`
+
+	config, err := Parse([]byte(content))
+	if err == nil {
+		t.Fatal("expected an error for the key outside of its section header")
+	}
+	if config.Interface == nil {
+		t.Fatal("missing interface")
+	}
+	// the comment with a space in its key is not captured
+	wantInterface := map[string]string{}
+	if !reflect.DeepEqual(config.Interface.Comments, wantInterface) {
+		t.Errorf("interface comments = %v, want %v", config.Interface.Comments, wantInterface)
+	}
+	if len(config.Peers) != 2 {
+		t.Fatalf("got %d peers, want 2", len(config.Peers))
+	}
+	wantPeer := map[string]string{"id": "cn1", "name": "Alice", "email": "alice@example.com", "notes": ""}
+	if !reflect.DeepEqual(config.Peers[0].Comments, wantPeer) {
+		t.Errorf("peer comments = %v, want %v", config.Peers[0].Comments, wantPeer)
+	}
+	// comments followed by a key belong to no section
+	if len(config.Peers[1].Comments) != 0 {
+		t.Errorf("second peer comments = %v, want none", config.Peers[1].Comments)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantErrors []string
+	}{
+		{
+			name: "valid config",
+			content: `[Interface]
+Address = 10.252.1.1/24, fd00::1/64
+ListenPort = 51820
+PrivateKey = ` + testPrivateKey + `
+MTU = 1450
+PostUp = iptables -A FORWARD -i %i -j ACCEPT # comment
+PostUp = iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE
+
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+AllowedIPs = 10.252.1.2/32
+AllowedIPs = 10.252.1.3
+Endpoint = vpn.example.com:51820
+PersistentKeepalive = off
+`,
+		},
+		{
+			name: "keys are case insensitive",
+			content: `[interface]
+privatekey = ` + testPrivateKey + `
+`,
+		},
+		{
+			name: "errors carry their line",
+			content: `[Interface]
+PrivateKey = not a key
+ListenPort = 70000
+Table = off
+
+[Peer]
+PublicKey = ` + testPublicKey1 + `
+AllowedIPs = 10.252.1.2/33, 10.252.1.x
+Endpoint = vpn.example.com
+Foo = bar
+`,
+			wantErrors: []string{
+				"line 2: invalid PrivateKey, must be a base64 encoded 32 byte key",
+				"line 3: invalid ListenPort \"70000\", must be a number from 0 to 65535",
+				"line 8: invalid address \"10.252.1.2/33\" in AllowedIPs",
+				"line 8: invalid address \"10.252.1.x\" in AllowedIPs",
+				"line 9: invalid Endpoint \"vpn.example.com\", must be host:port",
+				"line 10: unknown key Foo in [Peer] section on line 6",
+			},
+		},
+		{
+			name: "structure",
+			content: `PrivateKey = ` + testPrivateKey + `
+[Interface
+[Interface]
+PrivateKey = ` + testPrivateKey + `
+PrivateKey = ` + testPrivateKey + `
+SaveConfig
+[Interface]
+[Server]
+Address = 10.0.0.1/24
+`,
+			wantErrors: []string{
+				"line 1: key PrivateKey outside of a section",
+				"line 2: invalid section header \"[Interface\"",
+				"line 5: duplicate key PrivateKey",
+				"line 6: expected key = value, got \"SaveConfig\"",
+				"line 7: duplicate [Interface] section, the first one is on line 3",
+				"line 8: unknown section [Server]",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.content))
+			var got []string
+			if err != nil {
+				for _, e := range err.(Errors) {
+					got = append(got, e.Error())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantErrors) {
+				t.Errorf("errors = %q, want %q", got, tt.wantErrors)
+			}
+		})
+	}
+}